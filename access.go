@@ -0,0 +1,298 @@
+package webWorkers
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// newAccessList will return the parsed access lists of a set of Opts
+func newAccessList(o *Opts) (al *accessList, err error) {
+	if o.MaxConnsPerIP < 0 {
+		return nil, ErrInvalidMaxConns
+	}
+
+	al = &accessList{max: o.MaxConnsPerIP}
+	if al.allow, err = parseCIDRs(o.AllowCIDRs); err != nil {
+		return nil, err
+	}
+
+	if al.deny, err = parseCIDRs(o.DenyCIDRs); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// accessList is a parsed set of connection limits and CIDR lists
+type accessList struct {
+	max   int
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// allowed will return whether or not an IP may connect, connections without an IP (IE: unix sockets) are always allowed
+func (al *accessList) allowed(ip net.IP) bool {
+	if ip == nil {
+		return true
+	}
+
+	if isTrustedIP(ip, al.deny) {
+		return false
+	}
+
+	return len(al.allow) == 0 || isTrustedIP(ip, al.allow)
+}
+
+// ReloadAccess will reload MaxConnsPerIP, AllowCIDRs and DenyCIDRs from our configuration file
+// Note: Existing connections are not closed, the lists apply to new connections
+func (ww *Webworkers) ReloadAccess() (err error) {
+	path, ok := ww.o.src.(string)
+	if !ok {
+		// Our Opts were not read from a file, there is nothing to reload
+		return
+	}
+
+	var o Opts
+	if o, err = NewOpts(path); err != nil {
+		return
+	}
+
+	return ww.SetAccess(o.MaxConnsPerIP, o.AllowCIDRs, o.DenyCIDRs)
+}
+
+// SetAccess will replace the maximum number of concurrent connections per client IP, and the allowed and denied CIDRs
+// Note: Existing connections are not closed, the lists apply to new connections
+func (ww *Webworkers) SetAccess(maxConnsPerIP int, allow, deny []string) (err error) {
+	var al *accessList
+	if al, err = newAccessList(&Opts{MaxConnsPerIP: maxConnsPerIP, AllowCIDRs: allow, DenyCIDRs: deny}); err != nil {
+		return
+	}
+
+	ww.access.Store(al)
+	return
+}
+
+// watchAccess will reload our access lists whenever our configuration file has been modified
+// Note: The modification time is polled on the provided interval until the instance is closed
+func (ww *Webworkers) watchAccess(path string, interval time.Duration) {
+	tkr := time.NewTicker(interval)
+	defer tkr.Stop()
+
+	last := mtime(path)
+	for range tkr.C {
+		if ww.isClosed() {
+			return
+		}
+
+		if mt := mtime(path); !mt.Equal(last) {
+			last = mt
+			if err := ww.ReloadAccess(); err != nil {
+				ww.l.Println(err)
+			}
+		}
+	}
+}
+
+// newConnCounts will return a new set of connection counts
+func newConnCounts() *connCounts {
+	return &connCounts{m: make(map[string]int)}
+}
+
+// connCounts is the number of open connections per client IP
+type connCounts struct {
+	mux sync.Mutex
+	m   map[string]int
+}
+
+// acquire will count a connection for the provided IP, false is returned if the IP already has the maximum number of connections
+func (cc *connCounts) acquire(ip string, max int) bool {
+	cc.mux.Lock()
+	defer cc.mux.Unlock()
+
+	if max > 0 && cc.m[ip] >= max {
+		return false
+	}
+
+	cc.m[ip]++
+	return true
+}
+
+// release will remove a connection for the provided IP
+func (cc *connCounts) release(ip string) {
+	cc.mux.Lock()
+	defer cc.mux.Unlock()
+
+	if cc.m[ip]--; cc.m[ip] <= 0 {
+		delete(cc.m, ip)
+	}
+}
+
+// newAccessListener will return a new accessListener
+// Note: Lazy listeners check each connection within its own goroutine, as resolving its client address reads a PROXY protocol header
+func newAccessListener(lst net.Listener, ww *Webworkers, lazy bool) *accessListener {
+	return &accessListener{
+		Listener: lst,
+		ww:       ww,
+		lazy:     lazy,
+		ready:    make(chan net.Conn),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+	}
+}
+
+// accessListener is a listener which enforces our access lists and per-IP connection limits
+type accessListener struct {
+	net.Listener
+	ww *Webworkers
+	// Whether or not connections are checked off of our accept loop, rather than within it
+	lazy bool
+
+	// Lazily checked connections which have been allowed, and the errors of our underlying listener
+	once  sync.Once
+	ready chan net.Conn
+	errs  chan error
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Accept will accept the next allowed connection, rejected connections are closed immediately
+func (a *accessListener) Accept() (c net.Conn, err error) {
+	if a.lazy {
+		a.once.Do(func() {
+			go a.acceptLazy()
+		})
+
+		select {
+		case c = <-a.ready:
+			return
+		case err = <-a.errs:
+			return
+		case <-a.done:
+			return nil, ErrIsClosed
+		}
+	}
+
+	for {
+		if c, err = a.Listener.Accept(); err != nil {
+			return
+		}
+
+		ac := &accessConn{Conn: c, ww: a.ww}
+		if ac.check() {
+			return ac, nil
+		}
+
+		c.Close()
+	}
+}
+
+// acceptLazy will accept connections until our listener is closed, checking each within its own goroutine
+// Note: Our client address is provided by a PROXY protocol header, which must not be read by our accept loop
+func (a *accessListener) acceptLazy() {
+	for {
+		c, err := a.Listener.Accept()
+		if err != nil {
+			select {
+			case a.errs <- err:
+				continue
+			case <-a.done:
+				return
+			}
+		}
+
+		go a.checkLazy(&accessConn{Conn: c, ww: a.ww})
+	}
+}
+
+// checkLazy will pass an allowed connection to Accept, rejected connections are closed without occupying a worker
+func (a *accessListener) checkLazy(ac *accessConn) {
+	if !ac.check() {
+		ac.Conn.Close()
+		return
+	}
+
+	select {
+	case a.ready <- ac:
+	case <-a.done:
+		ac.Close()
+	}
+}
+
+// Close will close the listener
+func (a *accessListener) Close() error {
+	a.closeOnce.Do(func() {
+		close(a.done)
+	})
+
+	return a.Listener.Close()
+}
+
+// accessConn is a connection counted towards its client IP's connection limit
+type accessConn struct {
+	net.Conn
+	ww *Webworkers
+
+	once sync.Once
+	ok   bool
+	// Client IP the connection is counted towards, empty if it is not counted
+	ip string
+
+	closeOnce sync.Once
+}
+
+// check will return whether or not the connection is allowed, counting it towards its client IP's connection limit
+// Note: The connection is only checked once, subsequent calls return the initial result
+func (a *accessConn) check() bool {
+	a.once.Do(func() {
+		al := a.ww.access.Load().(*accessList)
+		ip := addrIP(a.Conn.RemoteAddr())
+		if !al.allowed(ip) {
+			return
+		}
+
+		if ip != nil {
+			if !a.ww.conns.acquire(ip.String(), al.max) {
+				return
+			}
+
+			a.ip = ip.String()
+		}
+
+		a.ok = true
+	})
+
+	return a.ok
+}
+
+// Read will read from the connection, once it has been allowed
+func (a *accessConn) Read(b []byte) (n int, err error) {
+	if !a.check() {
+		return 0, ErrConnRejected
+	}
+
+	return a.Conn.Read(b)
+}
+
+// Write will write to the connection, once it has been allowed
+func (a *accessConn) Write(b []byte) (n int, err error) {
+	if !a.check() {
+		return 0, ErrConnRejected
+	}
+
+	return a.Conn.Write(b)
+}
+
+// Close will close the connection, releasing it from its client IP's connection limit
+func (a *accessConn) Close() error {
+	// Ensure a connection which has not yet been checked is never counted, and wait for an in-progress check
+	a.once.Do(func() {})
+	a.closeOnce.Do(func() {
+		if a.ip != "" {
+			a.ww.conns.release(a.ip)
+		}
+	})
+
+	return a.Conn.Close()
+}
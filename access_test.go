@@ -0,0 +1,210 @@
+package webWorkers
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webWorkers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := filepath.Join(dir, "config.ini")
+	writeCfg := func(extra string) {
+		data := "workerCap = 2\nqueueLen = 16\naddress = \":11127\"\n" + extra
+		if err := ioutil.WriteFile(cfg, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeCfg("maxConnsPerIP = 1\n")
+	opts, err := NewOpts(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ww, err := New(opts, func(res *Response, req *Request) {
+		res.Write([]byte("hello"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ww.Close()
+
+	go ww.Listen()
+	time.Sleep(time.Millisecond * 100)
+
+	// Hold a connection open, so our client IP is at its limit
+	held, err := net.Dial("tcp4", "localhost:11127")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 50)
+
+	if accessRequest(t, "localhost:11127", "") {
+		t.Fatal("expected a connection over our limit to be rejected")
+	}
+
+	held.Close()
+	time.Sleep(time.Millisecond * 50)
+
+	if !accessRequest(t, "localhost:11127", "") {
+		t.Fatal("expected a connection to be allowed once our held connection was closed")
+	}
+
+	if err = ww.SetAccess(0, []string{"10.0.0.0/8"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if accessRequest(t, "localhost:11127", "") {
+		t.Fatal("expected a connection outside of our allowed CIDRs to be rejected")
+	}
+
+	writeCfg("allowCIDRs = 127.0.0.0/8, ::1\ndenyCIDRs = 127.0.0.1\n")
+	if err = ww.ReloadAccess(); err != nil {
+		t.Fatal(err)
+	}
+
+	if accessRequest(t, "localhost:11127", "") {
+		t.Fatal("expected a denied connection to be rejected")
+	}
+
+	writeCfg("denyCIDRs = 127.0.0.1/\n")
+	if err = ww.ReloadAccess(); err != ErrInvalidCIDR {
+		t.Fatalf("expected ErrInvalidCIDR and received %v", err)
+	}
+
+	if err = ww.SetAccess(0, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if !accessRequest(t, "localhost:11127", "") {
+		t.Fatal("expected a connection to be allowed once our lists were cleared")
+	}
+}
+
+func TestAccessProxyProtocol(t *testing.T) {
+	ww, err := New(Opts{
+		WorkerCap:     1,
+		QueueLen:      16,
+		Address:       ":11131",
+		ProxyProtocol: true,
+		ProxyTrusted:  []string{"127.0.0.1"},
+		MaxConnsPerIP: 1,
+		DenyCIDRs:     []string{"192.0.2.1"},
+	}, func(res *Response, req *Request) {
+		res.Write([]byte("hello"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ww.Close()
+
+	go ww.Listen()
+	time.Sleep(time.Millisecond * 100)
+
+	// A connection which never sends its PROXY protocol header must not hold our only worker
+	stalled, err := net.Dial("tcp4", "localhost:11131")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+
+	start := time.Now()
+	if accessRequest(t, "localhost:11131", "PROXY TCP4 192.0.2.1 127.0.0.1 56324 11131\r\n") {
+		t.Fatal("expected a denied client address to be rejected")
+	}
+
+	if !accessRequest(t, "localhost:11131", "PROXY TCP4 192.0.2.2 127.0.0.1 56324 11131\r\n") {
+		t.Fatal("expected an allowed client address to be served")
+	}
+
+	if elapsed := time.Since(start); elapsed > proxyHeaderTimeout/2 {
+		t.Fatalf("expected our requests to be served while a connection was stalled, took %v", elapsed)
+	}
+
+	// Hold a connection open, so our client address is at its limit
+	held, err := net.Dial("tcp4", "localhost:11131")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+
+	held.Write([]byte("PROXY TCP4 192.0.2.3 127.0.0.1 56324 11131\r\n"))
+	time.Sleep(time.Millisecond * 50)
+
+	if accessRequest(t, "localhost:11131", "PROXY TCP4 192.0.2.3 127.0.0.1 56325 11131\r\n") {
+		t.Fatal("expected a connection over our limit to be rejected")
+	}
+}
+
+func TestWatchAccess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webWorkers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := filepath.Join(dir, "config.ini")
+	data := "workerCap = 2\nqueueLen = 16\naddress = \":11132\"\naccessReloadInterval = 20ms\n"
+	if err = ioutil.WriteFile(cfg, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := NewOpts(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ww, err := New(opts, func(res *Response, req *Request) {
+		res.Write([]byte("hello"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ww.Close()
+
+	go ww.Listen()
+	time.Sleep(time.Millisecond * 100)
+
+	if !accessRequest(t, "localhost:11132", "") {
+		t.Fatal("expected a connection to be allowed before our configuration was modified")
+	}
+
+	if err = ioutil.WriteFile(cfg, []byte(data+"denyCIDRs = 127.0.0.0/8\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ensure our modification time changes, regardless of the file system's resolution
+	future := time.Now().Add(time.Second)
+	if err = os.Chtimes(cfg, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Millisecond * 100)
+	if accessRequest(t, "localhost:11132", "") {
+		t.Fatal("expected a connection to be denied once our configuration was reloaded")
+	}
+}
+
+// accessRequest will return whether or not a request (preceded by the provided PROXY protocol header) was served
+func accessRequest(t *testing.T, addr, header string) bool {
+	c, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	c.Write([]byte(header + "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	b, _ := ioutil.ReadAll(c)
+	return strings.HasSuffix(string(b), "hello")
+}
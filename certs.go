@@ -0,0 +1,239 @@
+package webWorkers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// newCertStore will return a new certStore for the provided certificates
+// Note: The first certificate is used as the default for clients which do not match (or do not send) a server name
+func newCertStore(crts []tls.Certificate) (cs *certStore, err error) {
+	if len(crts) == 0 {
+		return nil, ErrEmptyCerts
+	}
+
+	cs = &certStore{
+		crts:  crts,
+		def:   &crts[0],
+		names: make(map[string]*tls.Certificate, len(crts)),
+	}
+
+	for i := range crts {
+		crt := &crts[i]
+		if crt.Leaf == nil {
+			if crt.Leaf, err = x509.ParseCertificate(crt.Certificate[0]); err != nil {
+				return nil, err
+			}
+		}
+
+		names := crt.Leaf.DNSNames
+		if len(names) == 0 && crt.Leaf.Subject.CommonName != "" {
+			// Legacy certificates may only provide a common name
+			names = []string{crt.Leaf.Subject.CommonName}
+		}
+
+		for _, name := range names {
+			name = strings.ToLower(name)
+			if _, ok := cs.names[name]; !ok {
+				// Earlier certificates take precedence for duplicate names
+				cs.names[name] = crt
+			}
+		}
+	}
+
+	return
+}
+
+// certStore is an immutable set of certificates indexed by name
+type certStore struct {
+	crts []tls.Certificate
+	// TLS pairs the certificates were loaded from (nil for development certificates)
+	tps []TLSPair
+
+	def   *tls.Certificate
+	names map[string]*tls.Certificate
+}
+
+// get will return the certificate matching the provided server name
+// Exact matches take precedence over wildcard matches, the default certificate is returned when nothing matches
+func (cs *certStore) get(name string) *tls.Certificate {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if crt, ok := cs.names[name]; ok {
+		return crt
+	}
+
+	// Wildcards only match a single label (IE: "*.example.com" matches "a.example.com" but not "a.b.example.com")
+	if crt, ok := cs.names[wildcardName(name)]; ok {
+		return crt
+	}
+
+	return cs.def
+}
+
+// loadCerts will load the certificates of the provided TLS pairs
+// Note: An error is returned if any pair fails to load, so a partially written renewal is never served
+func loadCerts(tps []TLSPair) (crts []tls.Certificate, err error) {
+	crts = make([]tls.Certificate, 0, len(tps))
+	for _, tp := range tps {
+		var crt tls.Certificate
+		if crt, err = tls.LoadX509KeyPair(tp.CRT, tp.Key); err != nil {
+			return nil, err
+		}
+
+		crts = append(crts, crt)
+	}
+
+	return
+}
+
+// newPairStore will load the provided TLS pairs into a new certStore, stapling OCSP responses where configured
+// Note: Staples which fail to refresh fall back to any still-valid staple within the previous store
+func (ww *Webworkers) newPairStore(tps []TLSPair, prev *certStore) (cs *certStore, err error) {
+	var crts []tls.Certificate
+	if crts, err = loadCerts(tps); err != nil {
+		return
+	}
+
+	if cs, err = newCertStore(crts); err != nil {
+		return
+	}
+
+	cs.tps = tps
+	ww.staple(cs, prev)
+	return
+}
+
+// loadClientCAs will return a certificate pool containing the certificates of the provided CA bundle files
+func loadClientCAs(files []string) (pool *x509.CertPool, err error) {
+	var b []byte
+	pool = x509.NewCertPool()
+	for _, file := range files {
+		if b, err = ioutil.ReadFile(file); err != nil {
+			return
+		}
+
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, ErrInvalidClientCA
+		}
+	}
+
+	return
+}
+
+// getCertificate will select a certificate using the server name indicated by the client (SNI)
+func (ww *Webworkers) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return ww.certs.Load().(*certStore).get(hello.ServerName), nil
+}
+
+// ReloadCerts will reload all certificates and atomically swap them in
+// Connections which have already completed their handshake are unaffected
+// If any certification fails to load, an error is returned and our existing certificates continue to be served
+// Note: When options were created via NewOpts with a file path, the [certification*] sections are re-read as well
+func (ww *Webworkers) ReloadCerts() (err error) {
+	if ww.tc == nil {
+		return ErrTLSDisabled
+	}
+
+	ww.cmux.Lock()
+	defer ww.cmux.Unlock()
+
+	tps := ww.o.Certs
+	if path, ok := ww.o.src.(string); ok {
+		var o Opts
+		if o, err = NewOpts(path); err != nil {
+			return
+		}
+
+		tps = carryFetchers(o.Certs, ww.o.Certs)
+	}
+
+	if len(tps) == 0 && ww.o.DevCerts {
+		// We are using development certificates, which are kept until restart
+		return
+	}
+
+	var cs *certStore
+	if cs, err = ww.newPairStore(tps, ww.certs.Load().(*certStore)); err != nil {
+		// Keep serving our existing certificates
+		return
+	}
+
+	ww.o.Certs = tps
+	ww.certs.Store(cs)
+	ww.cmtimes = certMTimes(ww.o.src, tps)
+	return
+}
+
+// watchCerts will reload certificates whenever a certificate, key, or configuration file has been modified
+// Note: Modification times are polled on the provided interval until the instance is closed
+func (ww *Webworkers) watchCerts(interval time.Duration) {
+	tkr := time.NewTicker(interval)
+	defer tkr.Stop()
+
+	// Modification times of our last failed reload, failures are not retried until a file is modified again
+	var failed mtimes
+	for range tkr.C {
+		if ww.isClosed() {
+			return
+		}
+
+		ww.cmux.Lock()
+		cur := certMTimes(ww.o.src, ww.o.Certs)
+		changed := !cur.equal(ww.cmtimes) && !cur.equal(failed)
+		ww.cmux.Unlock()
+
+		if !changed {
+			continue
+		}
+
+		if err := ww.ReloadCerts(); err != nil {
+			ww.l.Println(err)
+			failed = cur
+		}
+	}
+}
+
+// mtimes is a list of file modification times
+type mtimes []time.Time
+
+// equal will return whether or not two lists of modification times are equal
+func (m mtimes) equal(b mtimes) bool {
+	if len(m) != len(b) {
+		return false
+	}
+
+	for i, t := range m {
+		if !t.Equal(b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// certMTimes will return the modification times of a configuration source and the provided TLS pairs
+// Note: Files which cannot be read are given a zero time, so they are detected once they reappear
+func certMTimes(src interface{}, tps []TLSPair) (m mtimes) {
+	if path, ok := src.(string); ok {
+		m = append(m, mtime(path))
+	}
+
+	for _, tp := range tps {
+		m = append(m, mtime(tp.CRT), mtime(tp.Key))
+	}
+
+	return
+}
+
+// mtime will return the modification time of the provided file
+func mtime(path string) (t time.Time) {
+	if fi, err := os.Stat(path); err == nil {
+		t = fi.ModTime()
+	}
+
+	return
+}
@@ -0,0 +1,262 @@
+package webWorkers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSNI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webWorkers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := Opts{
+		WorkerCap: 1,
+		QueueLen:  16,
+		Address:   ":11115",
+		TLS:       true,
+		Certs: []TLSPair{
+			writeTestCert(t, dir, "default.test"),
+			writeTestCert(t, dir, "*.wild.test"),
+			writeTestCert(t, dir, "exact.wild.test"),
+		},
+	}
+
+	ww, err := New(opts, func(res *Response, req *Request) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go ww.Listen()
+	time.Sleep(time.Millisecond * 100)
+
+	tests := map[string]string{
+		"":                "default.test",
+		"unknown.test":    "default.test",
+		"a.wild.test":     "*.wild.test",
+		"A.WILD.TEST.":    "*.wild.test",
+		"a.b.wild.test":   "default.test",
+		"exact.wild.test": "exact.wild.test",
+	}
+
+	for sn, expected := range tests {
+		if name := peerName(t, sn); name != expected {
+			t.Errorf("invalid certificate for \"%s\", expected %s and received %s", sn, expected, name)
+		}
+	}
+
+	// Replace the default certificate and reload
+	writeTestCert(t, dir, "default.test", "renewed.test")
+	if err = ww.ReloadCerts(); err != nil {
+		t.Fatal(err)
+	}
+
+	if name := peerName(t, "renewed.test"); name != "default.test" {
+		t.Fatalf("certificate was not reloaded, received %s", name)
+	}
+}
+
+func TestWatchCerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webWorkers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tp := writeTestCert(t, dir, "default.test")
+	ww, err := New(Opts{
+		WorkerCap:          1,
+		QueueLen:           16,
+		Address:            ":11134",
+		TLS:                true,
+		Certs:              []TLSPair{tp},
+		CertReloadInterval: time.Millisecond * 20,
+	}, func(res *Response, req *Request) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ww.Close()
+
+	// hosts will return the hosts of our served certificate
+	hosts := func() []string {
+		crt, err := x509.ParseCertificate(ww.certs.Load().(*certStore).def.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return crt.DNSNames
+	}
+
+	// touch will move the modification times of our pair forward, as writes may land within the same mtime
+	touch := func(d time.Duration) {
+		for _, file := range []string{tp.CRT, tp.Key} {
+			if err := os.Chtimes(file, time.Now().Add(d), time.Now().Add(d)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// A partially written renewal must not replace our certificate
+	if err = ioutil.WriteFile(tp.CRT, []byte("invalid"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	touch(time.Hour)
+	time.Sleep(time.Millisecond * 100)
+	if err = ww.ReloadCerts(); err == nil {
+		t.Fatal("expected an invalid certification to fail to reload")
+	}
+
+	if h := hosts(); len(h) != 1 || h[0] != "default.test" {
+		t.Fatalf("expected our existing certificate to be kept, received %v", h)
+	}
+
+	writeTestCert(t, dir, "default.test", "renewed.test")
+	touch(time.Hour * 2)
+	for i := 0; len(hosts()) != 2; i++ {
+		if i == 50 {
+			t.Fatal("certificate was not reloaded once modified")
+		}
+
+		time.Sleep(time.Millisecond * 20)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webWorkers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctp := writeTestCert(t, dir, "client.test")
+	opts := Opts{
+		WorkerCap:  1,
+		QueueLen:   16,
+		Address:    ":11116",
+		TLS:        true,
+		Certs:      []TLSPair{writeTestCert(t, dir, "localhost")},
+		ClientCAs:  []string{ctp.CRT},
+		ClientAuth: ClientAuthRequire,
+	}
+
+	ww, err := New(opts, func(res *Response, req *Request) {
+		if crt := req.PeerCertificate(); crt != nil && len(req.TLS().VerifiedChains) > 0 {
+			res.Write([]byte(crt.Subject.CommonName))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go ww.Listen()
+	time.Sleep(time.Millisecond * 100)
+
+	crt, err := tls.LoadX509KeyPair(ctp.CRT, ctp.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body := mtlsRequest(&tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{crt}}); !strings.HasSuffix(body, "client.test") {
+		t.Fatalf("expected client subject, received \"%s\"", body)
+	}
+
+	if body := mtlsRequest(&tls.Config{InsecureSkipVerify: true}); body != "" {
+		t.Fatalf("expected handshake failure without a client certificate, received \"%s\"", body)
+	}
+}
+
+func TestClientAuthValidation(t *testing.T) {
+	if _, err := New(Opts{WorkerCap: 1, QueueLen: 1, Address: ":0", TLS: true, ClientAuth: "bogus"}, nil); err == nil {
+		t.Fatal("expected an error for an invalid client auth policy")
+	}
+
+	if _, err := New(Opts{WorkerCap: 1, QueueLen: 1, Address: ":0", TLS: true, ClientAuth: ClientAuthRequire}, nil); err == nil {
+		t.Fatal("expected an error when verifying client certificates without client CAs")
+	}
+}
+
+func mtlsRequest(tc *tls.Config) string {
+	c, err := tls.Dial("tcp", "localhost:11116", tc)
+	if err != nil {
+		return ""
+	}
+	defer c.Close()
+
+	c.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	b, _ := ioutil.ReadAll(c)
+	return string(b)
+}
+
+func peerName(t *testing.T, serverName string) string {
+	c, err := tls.Dial("tcp", "localhost:11115", &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	return c.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+// writeTestCert will write a self-signed certificate for the provided hosts to the provided directory
+func writeTestCert(t *testing.T, dir string, hosts ...string) (tp TLSPair) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp.CRT = filepath.Join(dir, hosts[0]+".crt")
+	tp.Key = filepath.Join(dir, hosts[0]+".key")
+
+	if err = ioutil.WriteFile(tp.CRT, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ioutil.WriteFile(tp.Key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
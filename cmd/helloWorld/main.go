@@ -2,12 +2,14 @@ package main
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/itsmontoya/webWorkers"
 )
 
-// cfgLoc is the location of our config ini file
+// cfgLoc is the default location of our config ini file, an alternate location may be provided as the first argument
 // Note: An example config is provided at ./config.ini.example
+// Note: A development TLS config is provided at ./config.dev.ini.example (IE: go run main.go config.dev.ini.example)
 const cfgLoc = "./config.ini"
 
 func main() {
@@ -15,10 +17,15 @@ func main() {
 		ww  *webWorkers.Webworkers
 		o   webWorkers.Opts
 		err error
+		loc = cfgLoc
 	)
 
+	if len(os.Args) > 1 {
+		loc = os.Args[1]
+	}
+
 	// Get a set of webWorkers options using our configuration file location
-	if o, err = webWorkers.NewOpts(cfgLoc); err != nil {
+	if o, err = webWorkers.NewOpts(loc); err != nil {
 		panic(err)
 	}
 
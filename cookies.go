@@ -2,6 +2,8 @@ package webWorkers
 
 import (
 	"bytes"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -39,44 +41,45 @@ func (c *Cookies) set(in []byte) {
 }
 
 // parse will parse an inbound string and populate the Cookies
+// Note: Pairs are separated by ";" with optional whitespace (RFC 6265 section 5.4), pairs with invalid names or values are skipped
 func (c *Cookies) parse() {
-	if len(c.cb) == 0 {
-		// Cannot parse an empty set of cookie bytes
-		return
-	}
+	// Set parsed to true
+	c.parsed = true
 
-	// Our key and value
-	var k, v []byte
-	for _, kv := range bytes.Split(c.cb, []byte{';', ' '}) {
-		// spl is our key/value split into key and value
-		spl := bytes.Split(kv, []byte{'='})
-		k = k[:0]
-		v = v[:0]
+	cb := c.cb
+	for len(cb) > 0 {
+		var kv []byte
+		if i := bytes.IndexByte(cb, ';'); i > -1 {
+			kv, cb = cb[:i], cb[i+1:]
+		} else {
+			kv, cb = cb, nil
+		}
 
-		if len(spl) < 2 {
-			// Our split has less than two values, continue on
+		// Values may contain "=" (IE: base64), so we only split on the first
+		i := bytes.IndexByte(kv, '=')
+		if i == -1 {
+			// Pair does not contain a value, continue on
 			continue
 		}
 
-		if k = spl[0]; len(k) == 0 {
-			// Our key is empty, continue on
+		key := string(trimOWS(kv[:i]))
+		if !isCookieName(key) {
+			// Our key is empty or invalid, continue on
 			continue
 		}
 
-		if v = spl[1]; len(v) == 0 {
-			// Our value is empty, continue on
+		v, ok := parseCookieValue(trimOWS(kv[i+1:]))
+		if !ok {
+			// Our value is invalid, continue on
 			continue
 		}
 
 		// Append our parsed cookie to our list of cookies
 		c.cks = append(c.cks, &Cookie{
-			Key: string(k),
-			Val: string(mapASCII(v)),
+			Key: key,
+			Val: string(v),
 		})
 	}
-
-	// Set parsed to true
-	c.parsed = true
 }
 
 // clean deletes all the keys within the internal map, so the Cookies can be re-used
@@ -91,6 +94,12 @@ func (c *Cookies) clean() {
 
 // Get will return the value of the cookie matching the provided key
 func (c *Cookies) Get(key string) (val string) {
+	val, _ = c.lookup(key)
+	return
+}
+
+// lookup will return the value of the cookie matching the provided key, and whether or not it was found
+func (c *Cookies) lookup(key string) (val string, ok bool) {
 	if !c.parsed {
 		// We haven't yet parsed, do so before attempting to get value
 		c.parse()
@@ -102,29 +111,73 @@ func (c *Cookies) Get(key string) (val string) {
 			continue
 		}
 
-		// Set val as Cookie value
-		val = ck.Val
-		// We have found our match, break out of loop
-		break
+		// Return the first matching cookie value
+		return ck.Val, true
 	}
 
 	return
 }
 
+// GetAll will return the values of every cookie matching the provided key
+// Note: Browsers may send multiple cookies sharing a name when their paths or domains differ, the most specific path is sent first
+func (c *Cookies) GetAll(key string) (vals []string) {
+	if !c.parsed {
+		// We haven't yet parsed, do so before attempting to get values
+		c.parse()
+	}
+
+	for _, ck := range c.cks {
+		if ck.Key == key {
+			vals = append(vals, ck.Val)
+		}
+	}
+
+	return
+}
+
+// Range will call the provided func for every cookie, in order, until it returns false
+// Note: The cookies must not be retained after the func returns, use Dup to retain them
+func (c *Cookies) Range(fn func(ck *Cookie) bool) {
+	if !c.parsed {
+		// We haven't yet parsed, do so before iterating
+		c.parse()
+	}
+
+	for _, ck := range c.cks {
+		if !fn(ck) {
+			return
+		}
+	}
+}
+
 // Set will set the value of the cookie matching the provided key
-func (c *Cookies) Set(key, val, path string, expires int64) {
+// Note: Use SetCookie to set attributes such as Domain, Secure, HttpOnly, and SameSite
+func (c *Cookies) Set(key, val, path string, expires int64) (err error) {
+	return c.SetCookie(Cookie{
+		Key:  key,
+		Val:  val,
+		Path: path,
+		Exp:  expires,
+	})
+}
+
+// SetCookie will set the cookie matching the provided cookie's key, path, and domain, replacing all of its attributes
+// Note: Browsers store cookies by key, path, and domain, so cookies differing in path or domain are sent separately
+func (c *Cookies) SetCookie(ck Cookie) (err error) {
+	if err = ck.validate(); err != nil {
+		return
+	}
+
 	var match bool
 	// Iterate through list of cookies
-	for _, ck := range c.cks {
-		if ck.Key != key {
-			// Cookie key does not match the provided key, continue
+	for _, v := range c.cks {
+		if v.Key != ck.Key || v.Path != ck.Path || !strings.EqualFold(v.Domain, ck.Domain) {
+			// Cookie does not match the provided key, path, and domain, continue
 			continue
 		}
 
-		// Set cookie value, path, and expiration
-		ck.Val = val
-		ck.Path = path
-		ck.Exp = expires
+		// Replace cookie value and attributes
+		*v = ck
 		// Set match to true
 		match = true
 	}
@@ -135,23 +188,49 @@ func (c *Cookies) Set(key, val, path string, expires int64) {
 	}
 
 	// Append new cookie to cookies list
-	c.cks = append(c.cks, &Cookie{
-		Key:  key,
-		Val:  val,
-		Path: path,
-		Exp:  expires,
+	c.cks = append(c.cks, &ck)
+	return
+}
+
+// Delete will delete the cookie matching the provided name, path, and domain by setting an expired cookie
+// Note: The path and domain must match those the cookie was set with, otherwise the browser will retain it
+func (c *Cookies) Delete(name, path, domain string) (err error) {
+	return c.SetCookie(Cookie{
+		Key:    name,
+		Path:   path,
+		Domain: domain,
+		// Expires is sent alongside Max-Age for older clients which do not support Max-Age
+		Exp:    1,
+		MaxAge: -1,
 	})
 }
 
-// Dup will return a copy of the Cookies, so it can be used after the HTTP request/response process has completed
+// Len will return the number of cookies
+func (c *Cookies) Len() int {
+	if !c.parsed {
+		// We haven't yet parsed, do so before counting
+		c.parse()
+	}
+
+	return len(c.cks)
+}
+
+// Dup will return a deep copy of the Cookies, so it can be used after the HTTP request/response process has completed
 func (c *Cookies) Dup() (nc *Cookies) {
+	if !c.parsed {
+		// We haven't yet parsed, do so before copying
+		c.parse()
+	}
+
 	// Create a fresh set of cookies
 	nc = newCookies()
+	nc.parsed = true
 
 	// Iterate through cookies list
 	for _, v := range c.cks {
-		// Append each entry in c.cks to nc.cks
-		nc.cks = append(nc.cks, v)
+		// Copy each entry in c.cks, so our pooled cookies can be safely re-used
+		ck := *v
+		nc.cks = append(nc.cks, &ck)
 	}
 
 	return
@@ -184,12 +263,75 @@ func (c *Cookies) Bytes() (bs []byte) {
 	return
 }
 
+// SameSite represents the SameSite attribute of a cookie
+type SameSite string
+
+const (
+	// SameSiteDefault omits the SameSite attribute, leaving the policy to the browser
+	SameSiteDefault SameSite = ""
+	// SameSiteLax sends cookies with top-level navigations from other sites
+	SameSiteLax SameSite = "Lax"
+	// SameSiteStrict only sends cookies with requests originating from the same site
+	SameSiteStrict SameSite = "Strict"
+	// SameSiteNone sends cookies with all requests, requires Secure
+	SameSiteNone SameSite = "None"
+)
+
+// cookieTimeFormat is the format of cookie expiration dates (RFC 6265 section 5.1.1), always in GMT
+const cookieTimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
 // Cookie represents a cookie
 type Cookie struct {
 	Key  string
 	Val  string
 	Path string
-	Exp  int64
+	// Domain the cookie is sent to, including its subdomains (IE: "example.com")
+	Domain string
+	// Expiration as a unix timestamp, zero omits Expires
+	Exp int64
+	// Max age in seconds, zero omits Max-Age and a negative value expires the cookie immediately (Max-Age=0)
+	MaxAge int
+	// Whether or not the cookie is only sent over HTTPS
+	Secure bool
+	// Whether or not the cookie is hidden from scripts
+	HttpOnly bool
+	// SameSite policy of the cookie
+	SameSite SameSite
+	// Whether or not the cookie is stored per top-level site (CHIPS), requires Secure
+	Partitioned bool
+}
+
+// validate will ensure a cookie's name, value, and attributes are valid
+func (c *Cookie) validate() (err error) {
+	if !isCookieName(c.Key) {
+		return ErrInvalidCookieName
+	}
+
+	if !isCookieValue(c.Val) {
+		return ErrInvalidCookieValue
+	}
+
+	if !isCookieAttr(c.Path) || !isCookieDomain(c.Domain) {
+		return ErrInvalidCookieAttr
+	}
+
+	switch c.SameSite {
+	case SameSiteDefault, SameSiteLax, SameSiteStrict:
+	case SameSiteNone:
+		if !c.Secure {
+			// Browsers reject SameSite=None cookies which are not Secure
+			return ErrInsecureCookie
+		}
+	default:
+		return ErrInvalidSameSite
+	}
+
+	if c.Partitioned && !c.Secure {
+		// Browsers reject Partitioned cookies which are not Secure
+		return ErrInsecureCookie
+	}
+
+	return
 }
 
 func (c *Cookie) String() string {
@@ -199,18 +341,109 @@ func (c *Cookie) String() string {
 	bs = append(bs, c.Val...)
 
 	if c.Path != "" {
-		bs = append(bs, "; "...)
-		bs = append(bs, "Path"...)
-		bs = append(bs, '=')
+		bs = append(bs, "; Path="...)
 		bs = append(bs, c.Path...)
 	}
 
+	if c.Domain != "" {
+		bs = append(bs, "; Domain="...)
+		bs = append(bs, c.Domain...)
+	}
+
 	if c.Exp > 0 {
-		bs = append(bs, "; "...)
-		bs = append(bs, "Expires"...)
-		bs = append(bs, '=')
-		bs = append(bs, time.Unix(c.Exp, 0).Format(time.RFC1123)...)
+		bs = append(bs, "; Expires="...)
+		bs = time.Unix(c.Exp, 0).UTC().AppendFormat(bs, cookieTimeFormat)
+	}
+
+	if c.MaxAge > 0 {
+		bs = append(bs, "; Max-Age="...)
+		bs = strconv.AppendInt(bs, int64(c.MaxAge), 10)
+	} else if c.MaxAge < 0 {
+		bs = append(bs, "; Max-Age=0"...)
+	}
+
+	if c.Secure {
+		bs = append(bs, "; Secure"...)
+	}
+
+	if c.HttpOnly {
+		bs = append(bs, "; HttpOnly"...)
+	}
+
+	if c.SameSite != SameSiteDefault {
+		bs = append(bs, "; SameSite="...)
+		bs = append(bs, c.SameSite...)
+	}
+
+	if c.Partitioned {
+		bs = append(bs, "; Partitioned"...)
 	}
 
 	return string(bs)
 }
+
+// isCookieName will return whether or not a string is a valid cookie name (an RFC 2616 token)
+func isCookieName(str string) bool {
+	return isToken(str)
+}
+
+// isCookieValue will return whether or not a string is a valid cookie value, optionally wrapped in double quotes
+func isCookieValue(str string) bool {
+	if len(str) > 1 && str[0] == '"' && str[len(str)-1] == '"' {
+		str = str[1 : len(str)-1]
+	}
+
+	for i := 0; i < len(str); i++ {
+		// cookie-octet excludes CTLs, whitespace, DQUOTE, comma, semicolon, and backslash
+		if b := str[i]; b <= ' ' || b >= 0x7f || b == '"' || b == ',' || b == ';' || b == '\\' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseCookieValue will return a request cookie value with any surrounding double quotes removed
+// Note: Spaces and commas are accepted, as browsers send them regardless of RFC 6265
+func parseCookieValue(v []byte) (out []byte, ok bool) {
+	if len(v) > 1 && v[0] == '"' && v[len(v)-1] == '"' {
+		v = v[1 : len(v)-1]
+	}
+
+	for _, b := range v {
+		if b < ' ' || b >= 0x7f || b == '"' || b == ';' || b == '\\' {
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+// trimOWS will trim optional whitespace (spaces and tabs) from both ends of a byteslice
+func trimOWS(bs []byte) []byte {
+	return bytes.Trim(bs, " \t")
+}
+
+// isCookieAttr will return whether or not a string is a valid cookie attribute value (any CHAR except CTLs or ";")
+func isCookieAttr(str string) bool {
+	for i := 0; i < len(str); i++ {
+		if b := str[i]; b < ' ' || b >= 0x7f || b == ';' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isCookieDomain will return whether or not a string is a valid cookie domain, an empty domain is valid
+func isCookieDomain(str string) bool {
+	// A leading dot is ignored by browsers (RFC 6265 section 5.2.3)
+	str = strings.TrimPrefix(str, ".")
+	for i := 0; i < len(str); i++ {
+		if !isHostChar(str[i]) {
+			return false
+		}
+	}
+
+	return true
+}
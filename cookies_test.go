@@ -0,0 +1,133 @@
+package webWorkers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCookieString(t *testing.T) {
+	tests := map[string]Cookie{
+		"a=b":                             {Key: "a", Val: "b"},
+		"a=b; Path=/; Domain=example.com": {Key: "a", Val: "b", Path: "/", Domain: "example.com"},
+		"a=b; Expires=Thu, 01 Jan 1970 00:16:40 GMT": {Key: "a", Val: "b", Exp: 1000},
+		"a=b; Max-Age=60": {Key: "a", Val: "b", MaxAge: 60},
+		"a=; Max-Age=0":   {Key: "a", MaxAge: -1},
+		"a=\"b c\"; Secure; HttpOnly; SameSite=Strict": {Key: "a", Val: "\"b c\"", Secure: true, HttpOnly: true, SameSite: SameSiteStrict},
+		"a=b; Secure; SameSite=None; Partitioned":      {Key: "a", Val: "b", Secure: true, SameSite: SameSiteNone, Partitioned: true},
+	}
+
+	for expected, ck := range tests {
+		if str := ck.String(); str != expected {
+			t.Errorf("invalid cookie string, expected \"%s\" and received \"%s\"", expected, str)
+		}
+	}
+}
+
+func TestCookieValidation(t *testing.T) {
+	tests := map[error]Cookie{
+		ErrInvalidCookieName:  {Key: "a b", Val: "b"},
+		ErrInvalidCookieValue: {Key: "a", Val: "b;c"},
+		ErrInvalidCookieAttr:  {Key: "a", Val: "b", Domain: "example.com;x"},
+		ErrInvalidSameSite:    {Key: "a", Val: "b", SameSite: "Loose"},
+		ErrInsecureCookie:     {Key: "a", Val: "b", Partitioned: true},
+	}
+
+	cks := newCookies()
+	for expected, ck := range tests {
+		if err := cks.SetCookie(ck); err != expected {
+			t.Errorf("expected %v and received %v", expected, err)
+		}
+	}
+
+	if err := cks.SetCookie(Cookie{Key: "", Val: "b"}); err != ErrInvalidCookieName {
+		t.Fatalf("expected ErrInvalidCookieName and received %v", err)
+	}
+
+	if err := cks.SetCookie(Cookie{Key: "a", Val: "b", SameSite: SameSiteNone}); err != ErrInsecureCookie {
+		t.Fatalf("expected ErrInsecureCookie and received %v", err)
+	}
+
+	if err := cks.Set("a", "b", "/", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cookies are replaced by key, path, and domain
+	if err := cks.SetCookie(Cookie{Key: "a", Val: "c", Path: "/", HttpOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cks.cks) != 1 || cks.cks[0].String() != "a=c; Path=/; HttpOnly" {
+		t.Fatalf("invalid cookies, received %v", cks.cks)
+	}
+}
+
+func TestCookieParse(t *testing.T) {
+	cks := newCookies()
+	cks.set([]byte("a=b;c=ZGF0YQ==; d=\"quoted\" ;e=;bad name=x; f=g h;noval; a=second;i=\x01"))
+
+	tests := map[string]string{
+		"a": "b",
+		"c": "ZGF0YQ==",
+		"d": "quoted",
+		"e": "",
+		"f": "g h",
+		"i": "",
+	}
+
+	for key, expected := range tests {
+		if val := cks.Get(key); val != expected {
+			t.Errorf("invalid value for \"%s\", expected \"%s\" and received \"%s\"", key, expected, val)
+		}
+	}
+
+	if vals := cks.GetAll("a"); len(vals) != 2 || vals[0] != "b" || vals[1] != "second" {
+		t.Fatalf("invalid values for \"a\", received %v", vals)
+	}
+
+	var keys []string
+	cks.Range(func(ck *Cookie) bool {
+		keys = append(keys, ck.Key)
+		return ck.Key != "f"
+	})
+
+	if strings.Join(keys, ",") != "a,c,d,e,f" {
+		t.Fatalf("invalid range, received %v", keys)
+	}
+}
+
+func TestCookieDelete(t *testing.T) {
+	cks := newCookies()
+	if err := cks.Delete("session", "/", "example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "session=; Path=/; Domain=example.com; Expires=Thu, 01 Jan 1970 00:00:01 GMT; Max-Age=0"
+	if cks.Len() != 1 || cks.cks[0].String() != expected {
+		t.Fatalf("invalid deletion cookie, received %v", cks.cks)
+	}
+
+	if err := cks.Delete("bad name", "/", ""); err != ErrInvalidCookieName {
+		t.Fatalf("expected ErrInvalidCookieName and received %v", err)
+	}
+
+	// Cookies differing in path or domain are distinct, each must be deleted
+	cks.Delete("session", "/admin", "example.com")
+	cks.Delete("session", "/", "EXAMPLE.com")
+	if cks.Len() != 2 || cks.cks[0].Path != "/" || cks.cks[1].Path != "/admin" {
+		t.Fatalf("expected a deletion cookie for each path, received %v", cks.cks)
+	}
+}
+
+func TestCookieDup(t *testing.T) {
+	cks := newCookies()
+	cks.set([]byte("a=b; c=d"))
+
+	dup := cks.Dup()
+	cks.SetCookie(Cookie{Key: "a", Val: "y"})
+	cks.clean()
+	cks.set([]byte("a=x"))
+
+	if dup.Len() != 2 || dup.Get("a") != "b" || dup.Get("c") != "d" {
+		t.Fatalf("duplicated cookies were mutated, received %v", dup.cks)
+	}
+}
@@ -0,0 +1,290 @@
+package webWorkers
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// CSRFMode represents how CSRF tokens are stored
+type CSRFMode uint8
+
+const (
+	// CSRFDoubleSubmit stores tokens within a cookie, which must match the submitted token
+	CSRFDoubleSubmit CSRFMode = iota
+	// CSRFSynchronizer stores tokens within the session, requires the handler to be wrapped by Sessions.Wrap
+	CSRFSynchronizer
+)
+
+const (
+	// defaultCSRFCookie is the default name of CSRF cookies
+	defaultCSRFCookie = "csrf_token"
+	// defaultCSRFField is the default name of the CSRF form field
+	defaultCSRFField = "csrf_token"
+	// csrfSessionKey is the session value which holds synchronizer tokens
+	csrfSessionKey = "_csrf"
+	// maxCSRFFormLen is the largest form body which is read to find a CSRF token
+	maxCSRFFormLen = 1 << 20
+	// formContentType is the content type of url-encoded forms
+	formContentType = "application/x-www-form-urlencoded"
+)
+
+// NewCSRF will return a new CSRF using the provided options
+func NewCSRF(o CSRFOpts) (c *CSRF, err error) {
+	if o.Mode != CSRFDoubleSubmit && o.Mode != CSRFSynchronizer {
+		return nil, ErrInvalidCSRFMode
+	}
+
+	if o.CookieName == "" {
+		o.CookieName = defaultCSRFCookie
+	}
+
+	if o.FieldName == "" {
+		o.FieldName = defaultCSRFField
+	}
+
+	if o.Path == "" {
+		o.Path = "/"
+	}
+
+	if o.SameSite == SameSiteDefault {
+		o.SameSite = SameSiteLax
+	}
+
+	if o.ErrorOutput == nil {
+		o.ErrorOutput = os.Stderr
+	}
+
+	c = &CSRF{
+		o:     o,
+		l:     log.New(o.ErrorOutput, "webWorkers (csrf): ", log.Ldate|log.Ltime),
+		hosts: make(map[string]struct{}, len(o.AllowedHosts)),
+	}
+
+	for _, pattern := range o.AllowedHosts {
+		name := strings.ToLower(strings.TrimSuffix(pattern, "."))
+		if !isHostPattern(name) {
+			return nil, ErrInvalidHostPattern
+		}
+
+		c.hosts[name] = struct{}{}
+	}
+
+	return
+}
+
+// CSRFOpts are the options for CSRF
+type CSRFOpts struct {
+	// Token storage, defaults to CSRFDoubleSubmit
+	Mode CSRFMode
+
+	// Name of the CSRF cookie (CSRFDoubleSubmit), defaults to "csrf_token"
+	CookieName string
+	// Name of the form field containing the token, defaults to "csrf_token"
+	// Note: Only url-encoded forms are read, multipart forms must send the X-CSRF-Token header
+	FieldName string
+	// Path of the CSRF cookie, defaults to "/"
+	Path string
+	// Domain of the CSRF cookie (optional)
+	Domain string
+	// Whether or not the CSRF cookie is only sent over HTTPS
+	Secure bool
+	// SameSite policy of the CSRF cookie, defaults to Lax
+	SameSite SameSite
+
+	// Hostnames (other than the request's own host) which may submit requests on any port, IE: "admin.example.com" or "*.example.com"
+	AllowedHosts []string
+	// Path prefixes which are not checked (IE: "/webhooks/")
+	Exempt []string
+
+	// Handler for rejected requests, defaults to responding with 403 Forbidden
+	ErrorHandler Handler
+	// Output for errors, defaults to os.Stderr
+	ErrorOutput io.Writer
+}
+
+// CSRF protects handlers against cross-site request forgery
+type CSRF struct {
+	o     CSRFOpts
+	l     *log.Logger
+	hosts map[string]struct{}
+}
+
+// Wrap will return a Handler which rejects unsafe requests without a valid CSRF token, see Request.CSRFToken
+// Unsafe requests (any method other than GET, HEAD, OPTIONS or TRACE) must come from our own (or an allowed) origin and
+// provide the token within the X-CSRF-Token header or form field
+func (c *CSRF) Wrap(fn Handler) Handler {
+	return func(res *Response, req *Request) {
+		token, issued, err := c.token(res, req)
+		if err != nil {
+			c.l.Println(err)
+			res.StatusCode(StatusInternalServerError)
+			res.Write(nil)
+			return
+		}
+
+		req.csrfToken = token
+		// Tokens issued during this request were not sent by the client, so unsafe requests are rejected
+		if !isSafeMethod(req.method) && !c.isExempt(req) && (issued || !c.isValid(req, token)) {
+			c.reject(res, req)
+			return
+		}
+
+		fn(res, req)
+	}
+}
+
+// token will return the current token of a request, a new token is issued if the request does not have one
+func (c *CSRF) token(res *Response, req *Request) (token string, issued bool, err error) {
+	var ss *Session
+	if c.o.Mode == CSRFSynchronizer {
+		if ss = req.Session(); ss == nil {
+			return "", false, ErrCSRFNoSession
+		}
+
+		token = ss.Get(csrfSessionKey)
+	} else {
+		token, _ = req.Cookies.lookup(c.o.CookieName)
+	}
+
+	if isSessionID(token) {
+		return
+	}
+
+	// Tokens share the format of session IDs
+	if token, err = newSessionID(); err != nil {
+		return
+	}
+
+	issued = true
+
+	if ss != nil {
+		ss.Set(csrfSessionKey, token)
+		return
+	}
+
+	err = res.Cookies.SetCookie(Cookie{
+		Key:    c.o.CookieName,
+		Val:    token,
+		Path:   c.o.Path,
+		Domain: c.o.Domain,
+		Secure: c.o.Secure,
+		// Not HttpOnly, scripts read the cookie to set the X-CSRF-Token header
+		SameSite: c.o.SameSite,
+	})
+
+	return
+}
+
+// isValid will return whether or not an unsafe request comes from an allowed origin and provides our token
+func (c *CSRF) isValid(req *Request, token string) bool {
+	if !c.isAllowedOrigin(req) {
+		return false
+	}
+
+	sent := string(req.csrfHeader)
+	if sent == "" {
+		sent = c.formToken(req)
+	}
+
+	return sent != "" && subtle.ConstantTimeCompare([]byte(sent), []byte(token)) == 1
+}
+
+// isAllowedOrigin will return whether or not a request's Origin (or Referer) is our own host or an allowed host
+// Note: HTTPS requests without an Origin or Referer are rejected, as they are only stripped by privacy tools
+func (c *CSRF) isAllowedOrigin(req *Request) bool {
+	src := req.origin
+	if len(src) == 0 {
+		src = req.referer
+	}
+
+	if len(src) == 0 {
+		return req.Scheme() != "https"
+	}
+
+	u, err := url.Parse(string(src))
+	if err != nil || u.Host == "" {
+		// Includes "null" origins, sent by sandboxed documents
+		return false
+	}
+
+	host := strings.ToLower(u.Host)
+	if (u.Scheme == "http" && strings.HasSuffix(host, ":80")) || (u.Scheme == "https" && strings.HasSuffix(host, ":443")) {
+		host = host[:strings.LastIndexByte(host, ':')]
+	}
+
+	if host == req.Host() && u.Scheme == req.Scheme() {
+		return true
+	}
+
+	name := strings.ToLower(u.Hostname())
+	if _, ok := c.hosts[name]; ok {
+		return true
+	}
+
+	_, ok := c.hosts[wildcardName(name)]
+	return ok
+}
+
+// formToken will return the token within a url-encoded form body
+// Note: The body is buffered and replaced, so it can still be read by the handler. Forms without a Content-Length are not read
+func (c *CSRF) formToken(req *Request) string {
+	ct := string(req.contentType)
+	if i := strings.IndexByte(ct, ';'); i > -1 {
+		ct = ct[:i]
+	}
+
+	if !strings.EqualFold(strings.TrimSpace(ct), formContentType) || req.Body == nil {
+		return ""
+	}
+
+	if req.contentLength <= 0 || req.contentLength > maxCSRFFormLen {
+		// We must not read past the body, as our connection remains open for our response
+		return ""
+	}
+
+	b := make([]byte, req.contentLength)
+	n, err := io.ReadFull(req.Body, b)
+	req.Body = io.MultiReader(bytes.NewReader(b[:n]), req.Body)
+	if err != nil {
+		return ""
+	}
+
+	vals, err := url.ParseQuery(string(b))
+	if err != nil {
+		return ""
+	}
+
+	return vals.Get(c.o.FieldName)
+}
+
+// isExempt will return whether or not a request's path is exempt from CSRF checks
+// Note: Paths containing dot segments are never exempt, so "/webhooks/../admin" is still checked
+func (c *CSRF) isExempt(req *Request) bool {
+	return hasPathPrefix(req.path, c.o.Exempt)
+}
+
+// reject will respond to a request which failed our CSRF checks
+func (c *CSRF) reject(res *Response, req *Request) {
+	if c.o.ErrorHandler != nil {
+		c.o.ErrorHandler(res, req)
+		return
+	}
+
+	res.StatusCode(StatusForbidden)
+	res.Write(nil)
+}
+
+// isSafeMethod will return whether or not a method is safe (RFC 7231 section 4.2.1)
+func isSafeMethod(method []byte) bool {
+	switch string(method) {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,118 @@
+package webWorkers
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	reject := func(res *Response, req *Request) {}
+	c, err := NewCSRF(CSRFOpts{AllowedHosts: []string{"*.example.com"}, Exempt: []string{"/webhooks/"}, ErrorHandler: reject})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := strings.Repeat("a", 43)
+	cookie := "Cookie: csrf_token=" + token + "\r\n"
+	tests := []struct {
+		name    string
+		req     string
+		body    string
+		allowed bool
+	}{
+		{"safe method", "GET / HTTP/1.1\r\nHost: site.com\r\n", "", true},
+		{"header", "POST / HTTP/1.1\r\nHost: site.com\r\nOrigin: http://site.com\r\nX-CSRF-Token: " + token + "\r\n" + cookie, "", true},
+		{"form", "POST / HTTP/1.1\r\nHost: site.com\r\nContent-Type: application/x-www-form-urlencoded\r\n" + cookie, "a=b&csrf_token=" + token, true},
+		{"allowed host", "POST / HTTP/1.1\r\nHost: site.com\r\nOrigin: https://admin.example.com:8443\r\nX-CSRF-Token: " + token + "\r\n" + cookie, "", true},
+		{"exempt", "POST /webhooks/a HTTP/1.1\r\nHost: site.com\r\n", "", true},
+		{"exempt traversal", "POST /webhooks/../admin/delete HTTP/1.1\r\nHost: site.com\r\n", "", false},
+		{"exempt encoded traversal", "POST /webhooks/%2e%2e/admin/delete HTTP/1.1\r\nHost: site.com\r\n", "", false},
+		{"mismatch", "POST / HTTP/1.1\r\nHost: site.com\r\nX-CSRF-Token: " + strings.Repeat("b", 43) + "\r\n" + cookie, "", false},
+		{"missing token", "POST / HTTP/1.1\r\nHost: site.com\r\n" + cookie, "", false},
+		{"missing cookie", "POST / HTTP/1.1\r\nHost: site.com\r\nX-CSRF-Token: " + token + "\r\n", "", false},
+		{"cross origin", "POST / HTTP/1.1\r\nHost: site.com\r\nOrigin: http://evil.com\r\nX-CSRF-Token: " + token + "\r\n" + cookie, "", false},
+		{"cross scheme", "POST / HTTP/1.1\r\nHost: site.com\r\nReferer: https://site.com/form\r\nX-CSRF-Token: " + token + "\r\n" + cookie, "", false},
+		{"null origin", "DELETE / HTTP/1.1\r\nHost: site.com\r\nOrigin: null\r\nX-CSRF-Token: " + token + "\r\n" + cookie, "", false},
+	}
+
+	for _, tc := range tests {
+		var (
+			served bool
+			body   string
+		)
+
+		res, req := serveCSRF(t, c.Wrap(func(res *Response, req *Request) {
+			b, _ := ioutil.ReadAll(req.Body)
+			served, body = true, string(b)
+		}), tc.req, tc.body)
+
+		if served != tc.allowed {
+			t.Errorf("%s: expected allowed to be %v", tc.name, tc.allowed)
+			continue
+		}
+
+		if served && body != tc.body {
+			t.Errorf("%s: handler received an invalid body \"%s\"", tc.name, body)
+		}
+
+		issued := len(res.Cookies.cks) > 0
+		if expected := !strings.Contains(tc.req, cookie); issued != expected {
+			t.Errorf("%s: expected issued to be %v", tc.name, expected)
+		}
+
+		if issued && req.CSRFToken() != res.Cookies.cks[0].Val {
+			t.Errorf("%s: request token does not match the issued token", tc.name)
+		}
+	}
+
+	if _, err = NewCSRF(CSRFOpts{AllowedHosts: []string{"*.*.example.com"}}); err != ErrInvalidHostPattern {
+		t.Fatalf("expected ErrInvalidHostPattern and received %v", err)
+	}
+}
+
+func TestCSRFSynchronizer(t *testing.T) {
+	var rejected bool
+	c, err := NewCSRF(CSRFOpts{Mode: CSRFSynchronizer, ErrorHandler: func(res *Response, req *Request) {
+		rejected = true
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var token string
+	s := NewSessions(NewMemoryStore(16), SessionOpts{})
+	fn := s.Wrap(c.Wrap(func(res *Response, req *Request) {
+		token = req.CSRFToken()
+	}))
+
+	res, _ := serveCSRF(t, fn, "GET / HTTP/1.1\r\nHost: site.com\r\n", "")
+	session := "Cookie: " + res.Cookies.cks[0].Key + "=" + res.Cookies.cks[0].Val + "\r\n"
+
+	if serveCSRF(t, fn, "POST / HTTP/1.1\r\nHost: site.com\r\nX-CSRF-Token: "+token+"\r\n"+session, ""); rejected {
+		t.Fatal("expected a valid synchronizer token to be allowed")
+	}
+
+	if serveCSRF(t, fn, "POST / HTTP/1.1\r\nHost: site.com\r\nX-CSRF-Token: "+token+"\r\n", ""); !rejected {
+		t.Fatal("expected a token without its session to be rejected")
+	}
+}
+
+// serveCSRF will call a handler with the provided request headers and body
+func serveCSRF(t *testing.T, fn Handler, head, body string) (res *Response, req *Request) {
+	req = &Request{Cookies: newCookies()}
+	if body != "" {
+		head += "Content-Length: " + strconv.Itoa(len(body)) + "\r\n"
+	}
+
+	if _, err := req.processHeader([]byte(head + "\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	req.normalizeHost()
+	req.Body = strings.NewReader(body)
+	res = &Response{Cookies: newCookies()}
+	fn(res, req)
+	return
+}
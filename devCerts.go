@@ -0,0 +1,244 @@
+package webWorkers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// devCertTTL is how long development certificates are valid for
+	devCertTTL = time.Hour * 24 * 30
+	// devCATTL is how long development CAs are valid for, CAs are reused until they expire
+	devCATTL = time.Hour * 24 * 365
+	// devCertOrg is the organization of development certificates
+	devCertOrg = "webWorkers development"
+)
+
+var (
+	defaultDevHosts = []string{"localhost", "127.0.0.1", "::1"}
+)
+
+// newDevCA will generate an ECDSA CA for signing development certificates
+func newDevCA() (ca *x509.Certificate, caKey *ecdsa.PrivateKey, err error) {
+	var der []byte
+	if caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		return
+	}
+
+	caTmpl := &x509.Certificate{
+		SerialNumber:          newSerial(),
+		Subject:               pkix.Name{Organization: []string{devCertOrg}, CommonName: devCertOrg + " CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(devCATTL),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	if der, err = x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey); err != nil {
+		return
+	}
+
+	ca, err = x509.ParseCertificate(der)
+	return
+}
+
+// newDevCert will generate an ECDSA leaf certificate for the provided hosts, signed by the provided CA
+// Note: The returned certificate includes the CA within its chain
+func newDevCert(hosts []string, ca *x509.Certificate, caKey *ecdsa.PrivateKey) (crt tls.Certificate, err error) {
+	var (
+		key *ecdsa.PrivateKey
+		der []byte
+	)
+
+	if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		return
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: newSerial(),
+		Subject:      pkix.Name{Organization: []string{devCertOrg}, CommonName: hosts[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(devCertTTL),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	if tmpl.NotAfter.After(ca.NotAfter) {
+		// Our leaf cannot outlive its CA
+		tmpl.NotAfter = ca.NotAfter
+	}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+
+	if der, err = x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey); err != nil {
+		return
+	}
+
+	crt.Certificate = [][]byte{der, ca.Raw}
+	crt.PrivateKey = key
+	crt.Leaf, err = x509.ParseCertificate(der)
+	return
+}
+
+// readDevCA will read a previously written CA certificate and key from the provided directory
+// Note: A nil CA is returned when the directory does not hold a CA, or its CA has expired
+func readDevCA(dir string) (ca *x509.Certificate, caKey *ecdsa.PrivateKey, err error) {
+	var cb, kb []byte
+	if cb, err = readPEM(filepath.Join(dir, "ca.crt")); os.IsNotExist(err) {
+		return nil, nil, nil
+	} else if err != nil {
+		return
+	}
+
+	if kb, err = readPEM(filepath.Join(dir, "ca.key")); os.IsNotExist(err) {
+		// CA was written without its key, a new CA must be generated
+		return nil, nil, nil
+	} else if err != nil {
+		return
+	}
+
+	if ca, err = x509.ParseCertificate(cb); err != nil {
+		return
+	}
+
+	if caKey, err = x509.ParseECPrivateKey(kb); err != nil {
+		return
+	}
+
+	if time.Now().After(ca.NotAfter) {
+		// CA has expired, a new CA must be generated
+		return nil, nil, nil
+	}
+
+	return
+}
+
+// newSerial will return a random certificate serial number
+func newSerial() *big.Int {
+	// Serial numbers must be positive and no longer than 20 bytes
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+
+	return serial
+}
+
+// writeDevCerts will write the CA certificate, CA key, leaf certificate, and leaf key to the provided directory
+// Note: Only the CA certificate (ca.crt) needs to be trusted by clients, the CA is reused by following loads
+func writeDevCerts(dir string, crt tls.Certificate, ca *x509.Certificate, caKey *ecdsa.PrivateKey) (err error) {
+	var kb, cakb []byte
+	if kb, err = x509.MarshalECPrivateKey(crt.PrivateKey.(*ecdsa.PrivateKey)); err != nil {
+		return
+	}
+
+	if cakb, err = x509.MarshalECPrivateKey(caKey); err != nil {
+		return
+	}
+
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return
+	}
+
+	if err = writePEM(filepath.Join(dir, "ca.key"), "EC PRIVATE KEY", cakb, 0600); err != nil {
+		return
+	}
+
+	if err = writePEM(filepath.Join(dir, "ca.crt"), "CERTIFICATE", ca.Raw, 0644); err != nil {
+		return
+	}
+
+	if err = writePEM(filepath.Join(dir, "dev.crt"), "CERTIFICATE", crt.Certificate[0], 0644); err != nil {
+		return
+	}
+
+	return writePEM(filepath.Join(dir, "dev.key"), "EC PRIVATE KEY", kb, 0600)
+}
+
+// writePEM will write a single PEM block to the provided file
+func writePEM(file, typ string, b []byte, mode os.FileMode) (err error) {
+	if err = ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: b}), mode); err != nil {
+		return
+	}
+
+	// WriteFile does not change the mode of an existing file
+	return os.Chmod(file, mode)
+}
+
+// readPEM will read the first PEM block of the provided file
+func readPEM(file string) (b []byte, err error) {
+	if b, err = ioutil.ReadFile(file); err != nil {
+		return
+	}
+
+	blk, _ := pem.Decode(b)
+	if blk == nil {
+		return nil, ErrInvalidDevCA
+	}
+
+	return blk.Bytes, nil
+}
+
+// fingerprint will return the SHA-256 fingerprint of a certificate
+func fingerprint(crt *x509.Certificate) string {
+	sum := sha256.Sum256(crt.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// loadDevCerts will generate our development certificates, optionally writing them to disk
+// Note: A CA previously written to DevCertDir is reused, so clients only need to trust it once
+func (ww *Webworkers) loadDevCerts(o *Opts) (crts []tls.Certificate, err error) {
+	var (
+		crt   tls.Certificate
+		ca    *x509.Certificate
+		caKey *ecdsa.PrivateKey
+	)
+
+	if o.DevCertDir != "" {
+		if ca, caKey, err = readDevCA(o.DevCertDir); err != nil {
+			return
+		}
+	}
+
+	if ca == nil {
+		if ca, caKey, err = newDevCA(); err != nil {
+			return
+		}
+	}
+
+	if crt, err = newDevCert(o.DevHosts, ca, caKey); err != nil {
+		return
+	}
+
+	if o.DevCertDir != "" {
+		if err = writeDevCerts(o.DevCertDir, crt, ca, caKey); err != nil {
+			return
+		}
+
+		ww.l.Printf("Development certificates written to %s, trust ca.crt to avoid certificate warnings", o.DevCertDir)
+	}
+
+	ww.l.Printf("Using development certificates for %v (CA SHA-256 fingerprint: %s)", o.DevHosts, fingerprint(ca))
+	return []tls.Certificate{crt}, nil
+}
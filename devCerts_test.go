@@ -0,0 +1,107 @@
+package webWorkers
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDevCerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webWorkers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts, err := NewOpts([]byte("workerCap = 1\nqueueLen = 16\naddress = \":11118\"\ntls = true\ndevCerts = true\ndevCertDir = \"" + dir + "\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ww, err := New(opts, func(res *Response, req *Request) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go ww.Listen()
+	time.Sleep(time.Millisecond * 100)
+
+	ca, err := ioutil.ReadFile(filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		t.Fatal("invalid CA certificate")
+	}
+
+	for _, host := range defaultDevHosts[:2] {
+		// Verification is performed against our written CA
+		c, err := tls.Dial("tcp", "localhost:11118", &tls.Config{RootCAs: pool, ServerName: host})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Close()
+	}
+
+	if _, err = tls.LoadX509KeyPair(filepath.Join(dir, "dev.crt"), filepath.Join(dir, "dev.key")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDevCertsReuseCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webWorkers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ww := &Webworkers{l: log.New(ioutil.Discard, "", 0)}
+	o := &Opts{DevHosts: defaultDevHosts, DevCertDir: dir}
+
+	first, err := ww.loadDevCerts(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(filepath.Join(dir, "ca.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mode := fi.Mode().Perm(); mode != 0600 {
+		t.Fatalf("expected ca.key to have a mode of 0600, received %o", mode)
+	}
+
+	second, err := ww.loadDevCerts(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(first[0].Certificate[1], second[0].Certificate[1]) {
+		t.Fatal("expected our CA to be reused")
+	}
+
+	if bytes.Equal(first[0].Certificate[0], second[0].Certificate[0]) {
+		t.Fatal("expected our development certificate to be re-issued")
+	}
+
+	ca, err := x509.ParseCertificate(second[0].Certificate[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	if _, err = second[0].Leaf.Verify(x509.VerifyOptions{Roots: pool, DNSName: "localhost"}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,49 @@
+package webWorkers
+
+import (
+	"bytes"
+	"io"
+)
+
+var (
+	// expectContinue is the only expectation supported by webWorkers
+	expectContinue = []byte("100-continue")
+	// continueResp is the interim response sent to clients awaiting a "100 Continue"
+	continueResp = []byte("HTTP/1.1 100 Continue\r\n\r\n")
+)
+
+// continueReader is a request body which lazily sends a "100 Continue" interim response
+// Note: The interim response is only sent on the first read, and only if a final response has not yet been sent
+type continueReader struct {
+	res  *Response
+	body io.Reader
+	sent bool
+}
+
+// reset will reset the continueReader with a provided response and body
+func (c *continueReader) reset(res *Response, body io.Reader) {
+	c.res = res
+	c.body = body
+	c.sent = false
+}
+
+// Read will read from the underlying body, sending a "100 Continue" before the first read
+func (c *continueReader) Read(b []byte) (n int, err error) {
+	if !c.sent {
+		c.sent = true
+
+		// If the headers have already been sent, the handler has responded early and the client is not owed a "100 Continue"
+		if !c.res.headersSent {
+			if _, err = c.res.conn.Write(continueResp); err != nil {
+				return
+			}
+		}
+	}
+
+	return c.body.Read(b)
+}
+
+// isValidExpect will return whether or not the provided expectation can be met
+func isValidExpect(expect []byte) bool {
+	return bytes.EqualFold(expect, expectContinue)
+}
@@ -0,0 +1,171 @@
+package webWorkers
+
+import (
+	"bytes"
+	"net"
+	"strings"
+)
+
+const (
+	schemeHTTP  = "http"
+	schemeHTTPS = "https"
+)
+
+// hop is a single hop of a forwarded chain
+type hop struct {
+	ip    net.IP
+	proto string
+}
+
+// ClientIP will return the IP of the client (IE: "192.0.2.1")
+// When the immediate peer is a trusted proxy (see Opts.TrustedProxies and Opts.TrustUnixPeers), the client is resolved from the
+// Forwarded, X-Forwarded-For, or X-Real-IP headers (in that order of precedence)
+// Note: Forwarded chains are walked from the nearest hop, the first address which is not a trusted proxy is the client
+// An empty IP is returned for peers without an IP, such as untrusted unix socket peers
+func (r *Request) ClientIP() string {
+	if ip := r.client().ip; ip != nil {
+		return ip.String()
+	}
+
+	return ""
+}
+
+// Scheme will return the scheme used by the client ("http" or "https")
+// When the immediate peer is a trusted proxy, the scheme is resolved from the Forwarded or X-Forwarded-Proto headers
+func (r *Request) Scheme() string {
+	return r.client().proto
+}
+
+// client will resolve the client hop of the request
+func (r *Request) client() (c hop) {
+	c.ip = addrIP(r.remoteAddr)
+	c.proto = schemeHTTP
+	if r.tls != nil {
+		c.proto = schemeHTTPS
+	}
+
+	if !r.isTrustedPeer() {
+		// Our peer is not a trusted proxy, forwarded headers cannot be trusted
+		return
+	}
+
+	var hops []hop
+	switch {
+	case len(r.forwarded) > 0:
+		hops = parseForwarded(r.forwarded)
+	case len(r.xForwardedFor) > 0:
+		hops = parseXForwarded(r.xForwardedFor, r.xForwardedProto)
+	case len(r.xRealIP) > 0:
+		hops = []hop{{ip: net.ParseIP(string(r.xRealIP)), proto: lastValue(r.xForwardedProto)}}
+	default:
+		return
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		h := hops[i]
+		if h.ip == nil {
+			// Unknown or obfuscated identifiers cannot be resolved, our nearest trusted hop is used instead
+			return
+		}
+
+		c.ip = h.ip
+		if h.proto == schemeHTTP || h.proto == schemeHTTPS {
+			c.proto = h.proto
+		}
+
+		if !isTrustedIP(h.ip, r.trusted) {
+			return
+		}
+	}
+
+	return
+}
+
+// isTrustedPeer will return whether or not our immediate peer is a trusted proxy
+// Note: Unix socket peers do not have an IP, they are only trusted when Opts.TrustUnixPeers is set
+func (r *Request) isTrustedPeer() bool {
+	if _, ok := r.remoteAddr.(*net.UnixAddr); ok {
+		return r.trustUnix
+	}
+
+	return isTrusted(r.remoteAddr, r.trusted)
+}
+
+// parseForwarded will parse the hops of a Forwarded header (RFC 7239)
+// IE: for=192.0.2.60;proto=http;by=203.0.113.43, for="[2001:db8:cafe::17]:4711"
+func parseForwarded(val []byte) (hops []hop) {
+	for _, elem := range bytes.Split(val, []byte{','}) {
+		var h hop
+		for _, pair := range bytes.Split(elem, []byte{';'}) {
+			kv := bytes.SplitN(bytes.TrimSpace(pair), []byte{'='}, 2)
+			if len(kv) != 2 {
+				continue
+			}
+
+			v := strings.Trim(string(kv[1]), `"`)
+			switch strings.ToLower(string(kv[0])) {
+			case "for":
+				h.ip = parseNodeIP(v)
+			case "proto":
+				h.proto = strings.ToLower(v)
+			}
+		}
+
+		hops = append(hops, h)
+	}
+
+	return
+}
+
+// parseXForwarded will parse the hops of X-Forwarded-For and X-Forwarded-Proto headers
+// Note: When the number of protocols does not match the number of addresses, the last protocol applies to every hop
+func parseXForwarded(xff, xfp []byte) (hops []hop) {
+	ips := bytes.Split(xff, []byte{','})
+	protos := bytes.Split(xfp, []byte{','})
+	for i, ip := range ips {
+		h := hop{ip: parseNodeIP(string(bytes.TrimSpace(ip)))}
+		if len(protos) == len(ips) {
+			h.proto = strings.ToLower(string(bytes.TrimSpace(protos[i])))
+		} else {
+			h.proto = lastValue(xfp)
+		}
+
+		hops = append(hops, h)
+	}
+
+	return
+}
+
+// parseNodeIP will parse the IP of a node, which may include a port (IE: "192.0.2.1:80" or "[2001:db8::1]:80")
+func parseNodeIP(node string) net.IP {
+	if ip := net.ParseIP(node); ip != nil {
+		return ip
+	}
+
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return net.ParseIP(host)
+	}
+
+	// Bracketed IPv6 without a port
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(node, "["), "]"))
+}
+
+// lastValue will return the last value of a comma-separated header, lowercased
+func lastValue(list []byte) string {
+	if i := bytes.LastIndexByte(list, ','); i > -1 {
+		list = list[i+1:]
+	}
+
+	return strings.ToLower(string(bytes.TrimSpace(list)))
+}
+
+// isTrustedIP will return whether or not an IP belongs to the provided networks
+func isTrustedIP(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
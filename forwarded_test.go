@@ -0,0 +1,77 @@
+package webWorkers
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := parseCIDRs([]string{"10.0.0.0/8", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		peer    string
+		headers [][2]string
+		tls     bool
+		ip      string
+		scheme  string
+	}{
+		{"direct", "192.0.2.1", nil, false, "192.0.2.1", "http"},
+		{"direct tls", "192.0.2.1", nil, true, "192.0.2.1", "https"},
+		{"spoofed", "192.0.2.1", [][2]string{{"X-Forwarded-For", "198.51.100.1"}, {"X-Forwarded-Proto", "https"}}, false, "192.0.2.1", "http"},
+		{"xff", "10.0.0.1", [][2]string{{"x-forwarded-for", "198.51.100.1"}, {"X-Forwarded-Proto", "https"}}, false, "198.51.100.1", "https"},
+		{"xff chain", "10.0.0.1", [][2]string{{"X-Forwarded-For", "203.0.113.9, 198.51.100.1, 10.0.0.2"}}, false, "198.51.100.1", "http"},
+		{"xff multiple", "10.0.0.1", [][2]string{{"X-Forwarded-For", "198.51.100.1"}, {"X-Forwarded-For", "10.0.0.2"}}, false, "198.51.100.1", "http"},
+		{"xff all trusted", "10.0.0.1", [][2]string{{"X-Forwarded-For", "10.0.0.3, 10.0.0.2"}}, false, "10.0.0.3", "http"},
+		{"xff invalid", "10.0.0.1", [][2]string{{"X-Forwarded-For", "garbage"}}, false, "10.0.0.1", "http"},
+		{"forwarded", "10.0.0.1", [][2]string{{"Forwarded", `for=192.0.2.60;proto=https;by=203.0.113.43`}, {"X-Forwarded-For", "198.51.100.1"}}, false, "192.0.2.60", "https"},
+		{"forwarded ipv6", "10.0.0.1", [][2]string{{"Forwarded", `for="[2001:db8:cafe::17]:4711"`}}, false, "2001:db8:cafe::17", "http"},
+		{"forwarded chain", "10.0.0.1", [][2]string{{"Forwarded", `for=192.0.2.43;proto=https, for="[2001:db8::1]"`}}, false, "192.0.2.43", "https"},
+		{"forwarded obfuscated", "10.0.0.1", [][2]string{{"Forwarded", `for=_hidden, for=10.0.0.2`}}, false, "10.0.0.2", "http"},
+		{"x-real-ip", "10.0.0.1", [][2]string{{"X-Real-IP", "198.51.100.2"}}, true, "198.51.100.2", "https"},
+	}
+
+	for _, tc := range tests {
+		req := Request{
+			remoteAddr: &net.TCPAddr{IP: net.ParseIP(tc.peer), Port: 1234},
+			trusted:    trusted,
+		}
+
+		if tc.tls {
+			req.tls = &tls.ConnectionState{}
+		}
+
+		for _, h := range tc.headers {
+			req.setHeader([]byte(h[0]), []byte(h[1]))
+		}
+
+		if ip := req.ClientIP(); ip != tc.ip {
+			t.Errorf("%s: invalid client IP, expected %s and received %s", tc.name, tc.ip, ip)
+		}
+
+		if scheme := req.Scheme(); scheme != tc.scheme {
+			t.Errorf("%s: invalid scheme, expected %s and received %s", tc.name, tc.scheme, scheme)
+		}
+	}
+}
+
+func TestClientIPUnix(t *testing.T) {
+	for _, trustUnix := range []bool{false, true} {
+		req := Request{remoteAddr: &net.UnixAddr{Net: "unix"}, trustUnix: trustUnix}
+		req.setHeader([]byte("X-Forwarded-For"), []byte("198.51.100.1"))
+		req.setHeader([]byte("X-Forwarded-Proto"), []byte("https"))
+
+		ip, scheme := "", "http"
+		if trustUnix {
+			ip, scheme = "198.51.100.1", "https"
+		}
+
+		if req.ClientIP() != ip || req.Scheme() != scheme {
+			t.Errorf("trusted %v: expected %q and %q, received %q and %q", trustUnix, ip, scheme, req.ClientIP(), req.Scheme())
+		}
+	}
+}
@@ -1,6 +1,13 @@
 package webWorkers
 
-import "net"
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+)
 
 // queue is a queue of net.Conn's
 type queue chan net.Conn
@@ -12,34 +19,47 @@ type Handler func(*Response, *Request)
 type TLSPair struct {
 	CRT string
 	Key string
+
+	// OCSP response file (DER) to staple, refreshed every OCSPRefreshInterval (optional)
+	OCSP string
+	// OCSP response fetcher, used in place of the OCSP file when set (optional)
+	OCSPFetcher OCSPFetcher
 }
 
-// mapASCII will return an ASCII-friendly version of a provided byteslice
-func mapASCII(in []byte) (out []byte) {
-	if isASCII(in) {
-		// Provided byteslice is valid ASCII, return early
-		return in
+// trimPrefix will remove all spaces and newlines preceeding characters within a provided byteslice
+func trimPrefix(bs []byte) []byte {
+	for i, b := range bs {
+		if b == '\n' || b == ' ' {
+			// Byte is either a newline or a space, continue
+			continue
+		}
+
+		// Byte is a non-whitespace character, return byteslice starting at current index
+		return bs[i:]
 	}
 
-	// Set out with a max capacity as the length of our inbound byteslice
-	out = make([]byte, 0, len(in))
-	for _, b := range in {
-		if !isASCIIByte(b) {
-			// Byte is not valid ASCII, continue
+	return nil
+}
+
+// trimSuffix will remove all spaces and carriage returns trailing characters within a provided byteslice
+func trimSuffix(bs []byte) []byte {
+	for i := len(bs) - 1; i >= 0; i-- {
+		if b := bs[i]; b == '\r' || b == ' ' {
+			// Byte is either a carriage return or a space, continue
 			continue
 		}
 
-		// Byte is valid ASCII, append it to the outbound byteslice
-		out = append(out, b)
+		// Byte is a non-whitespace character, return byteslice ending at current index
+		return bs[:i+1]
 	}
 
-	return
+	return nil
 }
 
-// isASCII will return if a provided byteslice is valid ASCII
-func isASCII(in []byte) bool {
-	for _, b := range in {
-		if !isASCIIByte(b) {
+// isValidHeader will return whether or not a provided header key or value is free of line breaks
+func isValidHeader(str string) bool {
+	for i := 0; i < len(str); i++ {
+		if b := str[i]; b == '\r' || b == '\n' || b == 0 {
 			return false
 		}
 	}
@@ -47,22 +67,138 @@ func isASCII(in []byte) bool {
 	return true
 }
 
-// isASCIIByte will return if a provided byte is valid ASCII
-func isASCIIByte(b byte) bool {
-	return b >= 32 && b <= 127
+// appendList will append a comma-separated header value to an existing list
+// Note: Headers which are allowed to repeat are equivalent to a single comma-separated header
+func appendList(list, val []byte) []byte {
+	if len(list) > 0 {
+		list = append(list, ", "...)
+	}
+
+	return append(list, val...)
 }
 
-// trimPrefix will remove all spaces and newlines preceeding characters within a provided byteslice
-func trimPrefix(bs []byte) []byte {
-	for i, b := range bs {
-		if b == '\n' || b == ' ' {
-			// Byte is either a newline or a space, continue
-			continue
+// hasToken will return whether or not a comma-separated header value contains the provided token (case-insensitive)
+func hasToken(list []byte, token string) bool {
+	for _, v := range bytes.Split(list, []byte{','}) {
+		if bytes.EqualFold(bytes.TrimSpace(v), []byte(token)) {
+			return true
 		}
+	}
 
-		// Byte is a non-whitespace character, return byteslice starting at current index
-		return bs[i:]
+	return false
+}
+
+// isToken will return whether or not a string is a valid token (RFC 7230 section 3.2.6)
+func isToken(str string) bool {
+	if str == "" {
+		return false
 	}
 
-	return nil
+	for i := 0; i < len(str); i++ {
+		if !isTokenByte(str[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isTokenBytes will return whether or not a byteslice is a valid token, without converting it to a string
+func isTokenBytes(bs []byte) bool {
+	if len(bs) == 0 {
+		return false
+	}
+
+	for _, b := range bs {
+		if !isTokenByte(b) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isTokenByte will return whether or not a byte may be used within a token (RFC 7230 section 3.2.6)
+func isTokenByte(b byte) bool {
+	return b > ' ' && b < 0x7f && strings.IndexByte("()<>@,;:\\\"/[]?={}", b) == -1
+}
+
+// canonicalizeKey will convert a header key to its canonical form in place (IE: "content-type" as "Content-Type")
+// Note: This matches textproto.CanonicalMIMEHeaderKey without allocating, keys which are not tokens are left as-is
+func canonicalizeKey(key []byte) {
+	for _, b := range key {
+		if !isTokenByte(b) {
+			return
+		}
+	}
+
+	upper := true
+	for i, b := range key {
+		if upper && b >= 'a' && b <= 'z' {
+			key[i] = b - ('a' - 'A')
+		} else if !upper && b >= 'A' && b <= 'Z' {
+			key[i] = b + ('a' - 'A')
+		}
+
+		upper = b == '-'
+	}
+}
+
+// cleanPath will return a request path without its query, percent-decoded and cleaned (IE: "//a/./b" becomes "/a/b")
+// False is returned if the path cannot be decoded or contains dot segments, such paths must never match a path prefix
+// Note: A trailing slash is retained, so "/a/" still matches the prefix "/a/"
+func cleanPath(raw []byte) (p string, ok bool) {
+	if i := bytes.IndexByte(raw, '?'); i > -1 {
+		raw = raw[:i]
+	}
+
+	var err error
+	if p, err = url.PathUnescape(string(raw)); err != nil {
+		return "", false
+	}
+
+	for _, seg := range strings.Split(strings.Replace(p, "\\", "/", -1), "/") {
+		if seg == "." || seg == ".." {
+			return "", false
+		}
+	}
+
+	trailing := strings.HasSuffix(p, "/")
+	if p = path.Clean("/" + p); trailing && p != "/" {
+		p += "/"
+	}
+
+	return p, true
+}
+
+// hasPathPrefix will return whether or not a request path matches any of the provided prefixes, once cleaned
+func hasPathPrefix(raw []byte, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return false
+	}
+
+	p, ok := cleanPath(raw)
+	if !ok {
+		return false
+	}
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// detachedConn is a net.Conn which has been detached from a worker
+// Note: Reads are served from any bytes buffered by the worker before reading from the underlying net.Conn
+type detachedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+// Read will read from the buffered bytes, followed by the underlying net.Conn
+func (d *detachedConn) Read(b []byte) (n int, err error) {
+	return d.r.Read(b)
 }
@@ -0,0 +1,236 @@
+package webWorkers
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+)
+
+var (
+	httpPrefix  = []byte("http://")
+	httpsPrefix = []byte("https://")
+)
+
+// processTarget will process an absolute-form request target (IE: "GET http://example.com/index.html HTTP/1.1")
+// The authority of an absolute-form target takes precedence over the Host header (RFC 7230 section 5.4)
+func (r *Request) processTarget() {
+	var rest []byte
+	switch {
+	case hasPrefixFold(r.path, httpPrefix):
+		rest = r.path[len(httpPrefix):]
+	case hasPrefixFold(r.path, httpsPrefix):
+		rest = r.path[len(httpsPrefix):]
+	default:
+		// Origin-form (or asterisk-form) target
+		return
+	}
+
+	end := bytes.IndexAny(rest, "/?#")
+	if end == -1 {
+		end = len(rest)
+	}
+
+	r.host = append(r.host[:0], rest[:end]...)
+	r.absolute = true
+
+	path := rest[end:]
+	if len(path) == 0 || path[0] != '/' {
+		// An empty path is equivalent to "/"
+		path = append([]byte{'/'}, path...)
+	}
+
+	r.path = append(r.path[:0], path...)
+}
+
+// normalizeHost will normalize the host of a request, returning false if the request must be rejected
+// Hosts are lowercased and stripped of any trailing dot, default ports for the connection's scheme are removed
+// Note: HTTP/1.1 requests must provide exactly one Host header (RFC 7230 section 5.4)
+func (r *Request) normalizeHost() bool {
+	if r.hosts > 1 {
+		return false
+	}
+
+	if r.hosts == 0 && !r.absolute && bytes.Equal(r.httpType, httpType) {
+		return false
+	}
+
+	name, port, ok := splitHost(r.host)
+	if !ok {
+		return false
+	}
+
+	for i, b := range name {
+		if b >= 'A' && b <= 'Z' {
+			name[i] = b + ('a' - 'A')
+		}
+	}
+
+	if len(name) > 1 && name[len(name)-1] == '.' {
+		name = name[:len(name)-1]
+	}
+
+	if (r.tls == nil && string(port) == "80") || (r.tls != nil && string(port) == "443") {
+		port = nil
+	}
+
+	host := make([]byte, 0, len(name)+len(port)+1)
+	host = append(host, name...)
+	if len(port) > 0 {
+		host = append(host, ':')
+		host = append(host, port...)
+	}
+
+	r.host = append(r.host[:0], host...)
+	return true
+}
+
+// splitHost will split and validate a host into its name and port
+func splitHost(host []byte) (name, port []byte, ok bool) {
+	name = host
+	if len(host) > 0 && host[0] == '[' {
+		// IPv6 literal
+		end := bytes.IndexByte(host, ']')
+		if end == -1 {
+			return
+		}
+
+		name, host = host[:end+1], host[end+1:]
+		for _, b := range name[1:end] {
+			if !isHex(b) && b != ':' && b != '.' {
+				return
+			}
+		}
+	} else if i := bytes.LastIndexByte(host, ':'); i > -1 {
+		name, host = host[:i], host[i:]
+	} else {
+		host = nil
+	}
+
+	if len(host) > 0 {
+		if host[0] != ':' {
+			return
+		}
+
+		port = host[1:]
+		for _, b := range port {
+			if b < '0' || b > '9' {
+				return
+			}
+		}
+	}
+
+	if len(name) > 0 && name[0] != '[' {
+		for _, b := range name {
+			if !isHostChar(b) {
+				return
+			}
+		}
+	}
+
+	ok = true
+	return
+}
+
+// isHostChar will return whether or not a byte is valid within a registered host name
+func isHostChar(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9') || b == '-' || b == '.' || b == '_'
+}
+
+// isHex will return whether or not a byte is a hexadecimal digit
+func isHex(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'f') || (b >= 'A' && b <= 'F')
+}
+
+// hasPrefixFold will return whether or not a byteslice begins with the provided prefix (case-insensitive)
+func hasPrefixFold(bs, prefix []byte) bool {
+	return len(bs) >= len(prefix) && bytes.EqualFold(bs[:len(prefix)], prefix)
+}
+
+// NewVirtualHosts will return a new VirtualHosts
+// The default handler serves requests which do not match any host, a 404 is returned when it is nil
+func NewVirtualHosts(def Handler) *VirtualHosts {
+	return &VirtualHosts{
+		hosts: make(map[string]Handler),
+		def:   def,
+	}
+}
+
+// VirtualHosts is a Handler which dispatches requests to different handlers by hostname
+// Usage: webWorkers.New(opts, vh.Serve)
+type VirtualHosts struct {
+	mux   sync.RWMutex
+	hosts map[string]Handler
+	def   Handler
+}
+
+// Handle will set the handler for a hostname (IE: "example.com") or a single-label wildcard (IE: "*.example.com")
+// Note: Ports are not considered, exact hostnames take precedence over wildcards
+func (v *VirtualHosts) Handle(pattern string, fn Handler) (err error) {
+	name := strings.TrimSuffix(strings.ToLower(pattern), ".")
+	if !isHostPattern(name) || fn == nil {
+		return ErrInvalidHostPattern
+	}
+
+	v.mux.Lock()
+	v.hosts[name] = fn
+	v.mux.Unlock()
+	return
+}
+
+// isHostPattern will return whether or not a lowercase hostname (IE: "example.com") or wildcard (IE: "*.example.com") is valid
+func isHostPattern(name string) bool {
+	if strings.HasPrefix(name, "*.") {
+		// Validate the remainder of our wildcard
+		name = name[2:]
+	}
+
+	if name == "" {
+		return false
+	}
+
+	for i := 0; i < len(name); i++ {
+		if !isHostChar(name[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Serve will serve a request using the handler matching its hostname
+func (v *VirtualHosts) Serve(res *Response, req *Request) {
+	v.mux.RLock()
+	fn := v.handler(req.Hostname())
+	v.mux.RUnlock()
+
+	if fn == nil {
+		res.StatusCode(StatusNotFound)
+		res.Write(nil)
+		return
+	}
+
+	fn(res, req)
+}
+
+// handler will return the handler matching the provided hostname
+func (v *VirtualHosts) handler(name string) Handler {
+	if fn, ok := v.hosts[name]; ok {
+		return fn
+	}
+
+	if fn, ok := v.hosts[wildcardName(name)]; ok {
+		return fn
+	}
+
+	return v.def
+}
+
+// wildcardName will return the single-label wildcard matching a name (IE: "*.example.com" for "a.example.com")
+// Note: An empty string is returned for names without a parent domain
+func wildcardName(name string) string {
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		return "*" + name[i:]
+	}
+
+	return ""
+}
@@ -0,0 +1,108 @@
+package webWorkers
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestHost(t *testing.T) {
+	tests := []struct {
+		req      string
+		tls      bool
+		ok       bool
+		host     string
+		hostname string
+		path     string
+	}{
+		{"GET / HTTP/1.1\r\nHost: Example.COM.\r\n\r\n", false, true, "example.com", "example.com", "/"},
+		{"GET / HTTP/1.1\r\nhost: example.com:80\r\n\r\n", false, true, "example.com", "example.com", "/"},
+		{"GET / HTTP/1.1\r\nHost: example.com:443\r\n\r\n", true, true, "example.com", "example.com", "/"},
+		{"GET / HTTP/1.1\r\nHost: example.com:8080\r\n\r\n", false, true, "example.com:8080", "example.com", "/"},
+		{"GET / HTTP/1.1\r\nHost: [::1]:8080\r\n\r\n", false, true, "[::1]:8080", "::1", "/"},
+		{"GET http://Other.com:8080/a?b=c HTTP/1.1\r\nHost: example.com\r\n\r\n", false, true, "other.com:8080", "other.com", "/a?b=c"},
+		{"GET https://other.com?b=c HTTP/1.1\r\nHost: example.com\r\n\r\n", true, true, "other.com", "other.com", "/?b=c"},
+		{"GET / HTTP/1.0\r\n\r\n", false, true, "", "", "/"},
+		// Missing, duplicated and invalid hosts
+		{"GET / HTTP/1.1\r\n\r\n", false, false, "", "", "/"},
+		{"GET / HTTP/1.1\r\nHost: a.com\r\nHost: b.com\r\n\r\n", false, false, "", "", "/"},
+		{"GET / HTTP/1.1\r\nHost: a b.com\r\n\r\n", false, false, "", "", "/"},
+		{"GET / HTTP/1.1\r\nHost: a.com:80a\r\n\r\n", false, false, "", "", "/"},
+	}
+
+	for _, tc := range tests {
+		req := Request{Cookies: newCookies()}
+		if tc.tls {
+			req.tls = &tls.ConnectionState{}
+		}
+
+		if _, err := req.processHeader([]byte(tc.req)); err != nil {
+			t.Fatal(err)
+		}
+
+		if ok := req.normalizeHost(); ok != tc.ok {
+			t.Errorf("%q: expected validity of %v", tc.req, tc.ok)
+			continue
+		} else if !ok {
+			continue
+		}
+
+		if req.Host() != tc.host || req.Hostname() != tc.hostname || req.Path() != tc.path {
+			t.Errorf("%q: invalid host, hostname or path: \"%s\", \"%s\", \"%s\"", tc.req, req.Host(), req.Hostname(), req.Path())
+		}
+	}
+}
+
+func TestMissingHost(t *testing.T) {
+	c, err := net.Dial("tcp", "localhost:11110")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	if b, _ := ioutil.ReadAll(c); !strings.HasPrefix(string(b), "HTTP/1.1 400 Bad Request\r\n") {
+		t.Fatalf("invalid response, expected 400 and received \"%s\"", b)
+	}
+}
+
+func TestVirtualHosts(t *testing.T) {
+	var served string
+	handler := func(name string) Handler {
+		return func(res *Response, req *Request) {
+			served = name
+		}
+	}
+
+	vh := NewVirtualHosts(handler("default"))
+	for _, pattern := range []string{"example.com", "*.example.com", "API.example.com."} {
+		if err := vh.Handle(pattern, handler(pattern)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, pattern := range []string{"", "*.", "exa mple.com", "*.*.example.com"} {
+		if err := vh.Handle(pattern, handler(pattern)); err != ErrInvalidHostPattern {
+			t.Errorf("expected ErrInvalidHostPattern for \"%s\", received %v", pattern, err)
+		}
+	}
+
+	tests := map[string]string{
+		"example.com":       "example.com",
+		"example.com:8080":  "example.com",
+		"www.example.com":   "*.example.com",
+		"api.example.com":   "API.example.com.",
+		"a.www.example.com": "default",
+		"other.com":         "default",
+	}
+
+	for host, expected := range tests {
+		req := Request{host: []byte(host)}
+		vh.Serve(nil, &req)
+		if served != expected {
+			t.Errorf("invalid handler for \"%s\", expected %s and received %s", host, expected, served)
+		}
+	}
+}
@@ -0,0 +1,1198 @@
+package webWorkers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/missionMeteora/toolkit/errors"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+const (
+	// ErrStreamClosed is returned when an action is attempted on a closed HTTP/2 stream
+	ErrStreamClosed = errors.Error("stream closed")
+	// ErrStreamTimeout is returned when a read or write of an HTTP/2 stream exceeds its deadline
+	ErrStreamTimeout = errors.Error("stream deadline exceeded")
+)
+
+const (
+	// h2Proto is the ALPN protocol identifier for HTTP/2 over TLS
+	h2Proto = "h2"
+	// h2HTTPType is the http type reported by requests received over HTTP/2
+	h2HTTPType = "HTTP/2.0"
+
+	// defaultH2MaxStreams is the default number of concurrent streams allowed per connection
+	defaultH2MaxStreams = 100
+	// defaultH2IdleTimeout is the default duration a connection may go without sending a frame before it is pinged
+	defaultH2IdleTimeout = time.Minute * 2
+	// h2Window is the flow control window advertised for each stream
+	h2Window = 65535
+	// h2MaxWindow is the largest flow control window permitted by RFC 7540
+	h2MaxWindow = 1<<31 - 1
+	// h2MaxFrameSize is the default maximum frame size
+	h2MaxFrameSize = 16384
+	// h2TableSize is the default HPACK dynamic table size
+	h2TableSize = 4096
+	// h2MaxHeaderList is the maximum size of a decoded header list we will accept
+	h2MaxHeaderList = 1 << 16
+	// h2ResetsPerStream is the number of resets allowed per concurrent stream before a peer which resets most of its streams is told to calm down
+	h2ResetsPerStream = 2
+)
+
+var (
+	h2Preface  = []byte(http2.ClientPreface)
+	h2cUpgrade = []byte("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n")
+)
+
+// serveH2 will detach a TLS connection which negotiated HTTP/2 into its own goroutine
+// Returns true if the connection has been handled and should not be processed as HTTP/1.x
+func (w *worker) serveH2(c net.Conn) bool {
+	tc, ok := c.(*tls.Conn)
+	if !ok || !w.o.HTTP2 {
+		return false
+	}
+
+	// Our handshake is bound by our header deadline, so slow clients cannot hold a worker
+	if err := c.SetDeadline(time.Now().Add(w.o.ReadHeaderTimeout)); err != nil {
+		w.l.Println(err)
+		c.Close()
+		return true
+	}
+
+	if err := tc.Handshake(); err != nil {
+		w.l.Println(err)
+		c.Close()
+		return true
+	}
+
+	c.SetDeadline(time.Time{})
+
+	if tc.ConnectionState().NegotiatedProtocol != h2Proto {
+		return false
+	}
+
+	newH2Conn(c, nil, w.l, w.o.HTTP2MaxStreams, w.o.HTTP2IdleTimeout, w.enqueue).start()
+	return true
+}
+
+// serveH2C will detach a plaintext connection which opened with the HTTP/2 connection preface
+// Returns true if the connection has been handled and should not be processed as HTTP/1.x
+func (w *worker) serveH2C(c net.Conn, pre []byte) bool {
+	if !w.o.H2C || !isH2Preface(pre) {
+		return false
+	}
+
+	// Copy our pre-read bytes, the worker will reuse its buffer for the next request
+	newH2Conn(c, append([]byte(nil), pre...), w.l, w.o.HTTP2MaxStreams, w.o.HTTP2IdleTimeout, w.enqueue).start()
+	return true
+}
+
+// upgradeH2C will upgrade an HTTP/1.1 request carrying "Upgrade: h2c" (RFC 7540 section 3.2)
+// The upgraded request becomes stream 1 and is served by the current worker
+// Returns true if the connection has been upgraded and should not be processed as HTTP/1.x
+func (w *worker) upgradeH2C(c net.Conn) bool {
+	var (
+		req = &w.req
+		res = &w.res
+
+		settings []http2.Setting
+		err      error
+	)
+
+	if !w.o.H2C || !hasToken(req.upgrade, "h2c") || !hasToken(req.connection, "http2-settings") {
+		return false
+	}
+
+	if req.contentLength > 0 {
+		// Upgrading requests with a body would require buffering the body, serve it over HTTP/1.1 instead
+		return false
+	}
+
+	if settings, err = decodeH2Settings(req.h2Settings); err != nil {
+		// The upgrade is optional, a client sending invalid settings is served over HTTP/1.1
+		return false
+	}
+
+	if _, err = c.Write(h2cUpgrade); err != nil {
+		w.l.Println(err)
+		c.Close()
+		return true
+	}
+
+	hc := newH2Conn(c, append([]byte(nil), w.brdr.Bytes()...), w.l, w.o.HTTP2MaxStreams, w.o.HTTP2IdleTimeout, w.enqueue)
+	for _, st := range settings {
+		hc.applySetting(st)
+	}
+
+	s := hc.upgradeStream()
+	hc.start()
+
+	req.httpType = append(req.httpType[:0], h2HTTPType...)
+	req.Body = s
+
+	res.conn = s
+	res.req = req
+
+	w.fn(res, req)
+
+	if res.detached {
+		return true
+	}
+
+	if !res.headersSent {
+		res.Write(nil)
+	}
+
+	s.Close()
+	return true
+}
+
+// isH2Preface will return whether or not the provided bytes begin with (or are the start of) the HTTP/2 connection preface
+func isH2Preface(bs []byte) bool {
+	if len(bs) > len(h2Preface) {
+		bs = bs[:len(h2Preface)]
+	}
+
+	// "PRI" is not a valid HTTP/1.x method, three bytes is enough to tell the two apart
+	return len(bs) >= 3 && bytes.HasPrefix(h2Preface, bs)
+}
+
+// decodeH2Settings will decode the value of an HTTP2-Settings header
+func decodeH2Settings(val []byte) (settings []http2.Setting, err error) {
+	var b []byte
+	// Value is base64url encoded, padding is omitted but may be present
+	if b, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(string(val), "=")); err != nil {
+		return
+	}
+
+	if len(b)%6 != 0 {
+		return nil, http2.ConnectionError(http2.ErrCodeProtocol)
+	}
+
+	for ; len(b) > 0; b = b[6:] {
+		st := http2.Setting{
+			ID:  http2.SettingID(binary.BigEndian.Uint16(b)),
+			Val: binary.BigEndian.Uint32(b[2:]),
+		}
+
+		if err = st.Valid(); err != nil {
+			return
+		}
+
+		settings = append(settings, st)
+	}
+
+	return
+}
+
+// serveStream will process a single HTTP/2 stream
+func (w *worker) serveStream(s *h2Stream) {
+	var (
+		req = &w.req
+		res = &w.res
+	)
+
+	if s.isReset() {
+		// Stream was reset by the peer before reaching a worker
+		s.Close()
+		return
+	}
+
+	err := s.populate(req)
+	req.Body = s
+	if w.o.MaxBodyBytes > 0 {
+		w.lb.reset(s, w.o.MaxBodyBytes)
+		req.Body = &w.lb
+	}
+
+	res.conn = s
+	res.req = req
+
+	if req.tls != nil && w.hsts != "" {
+		res.Header("Strict-Transport-Security", w.hsts)
+	}
+
+	if err == nil {
+		err = w.checkLimits(req)
+	}
+
+	if err != nil {
+		w.respondError(res, err)
+	} else if req.normalizeHost() {
+		w.fn(res, req)
+	} else {
+		// Host is duplicated or invalid
+		w.respondError(res, ErrInvalidHost)
+	}
+
+	if w.lb.exceeded && !res.headersSent && !res.detached {
+		// Handler read beyond our body limit without responding
+		w.respondError(res, ErrBodyTooLarge)
+	}
+
+	if res.detached {
+		// Stream has been taken over by the handler
+		return
+	}
+
+	if !res.headersSent {
+		// Handler did not write a response, send our headers so the stream can be ended
+		res.Write(nil)
+	}
+
+	s.Close()
+}
+
+// h2Headers will return the HTTP/2 header fields of a response
+func (r *Response) h2Headers() (fs []hpack.HeaderField) {
+	now := time.Now().Format(dateFmt)
+	status := "200"
+	if len(r.statusCode) >= 3 {
+		status = string(r.statusCode[:3])
+	}
+
+	fs = make([]hpack.HeaderField, 0, 8+len(r.headers)+len(r.Cookies.cks))
+	fs = append(fs, hpack.HeaderField{Name: ":status", Value: status})
+	fs = append(fs, hpack.HeaderField{Name: "server", Value: serverName})
+
+	if len(r.contentType) > 0 {
+		fs = append(fs, hpack.HeaderField{Name: "content-type", Value: string(r.contentType)})
+	}
+
+	fs = append(fs, hpack.HeaderField{Name: "date", Value: now})
+	fs = append(fs, hpack.HeaderField{Name: "last-modified", Value: now})
+
+	for _, h := range r.headers {
+		key := strings.ToLower(h.key)
+		if isConnectionHeader(key) {
+			// Connection-specific headers are forbidden in HTTP/2
+			continue
+		}
+
+		fs = append(fs, hpack.HeaderField{Name: key, Value: h.val})
+	}
+
+	for _, ck := range r.Cookies.cks {
+		fs = append(fs, hpack.HeaderField{Name: "set-cookie", Value: ck.String()})
+	}
+
+	return
+}
+
+// isConnectionHeader will return whether or not a provided lowercase header key is connection-specific
+func isConnectionHeader(key string) bool {
+	switch key {
+	case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+		return true
+	}
+
+	return false
+}
+
+// newH2Conn will return a new HTTP/2 connection
+// Note: pre is any bytes which have already been read from the connection
+func newH2Conn(c net.Conn, pre []byte, l *log.Logger, maxStreams uint32, idle time.Duration, dispatch func(net.Conn)) (hc *h2Conn) {
+	hc = &h2Conn{
+		lastFrame: time.Now().UnixNano(),
+
+		c:        c,
+		r:        bufio.NewReader(io.MultiReader(bytes.NewReader(pre), c)),
+		l:        l,
+		dispatch: dispatch,
+
+		streams:    make(map[uint32]*h2Stream),
+		maxStreams: maxStreams,
+		idle:       idle,
+
+		sendWindow:   h2Window,
+		peerWindow:   h2Window,
+		peerMaxFrame: h2MaxFrameSize,
+	}
+
+	if tc, ok := c.(*tls.Conn); ok {
+		// Our handshake has already completed during ALPN negotiation
+		state := tc.ConnectionState()
+		hc.tls = &state
+	}
+
+	hc.cond = sync.NewCond(&hc.mux)
+	hc.henc = hpack.NewEncoder(&hc.hbuf)
+
+	hc.fr = http2.NewFramer(c, hc.r)
+	hc.fr.ReadMetaHeaders = hpack.NewDecoder(h2TableSize, nil)
+	hc.fr.MaxHeaderListSize = h2MaxHeaderList
+	return
+}
+
+// h2Conn is a server-side HTTP/2 connection
+// Note: Frames are read by a single goroutine, each stream is dispatched to the worker pool
+type h2Conn struct {
+	// Time the last frame was received in Unix nanoseconds, accessed atomically (first for 64-bit alignment)
+	lastFrame int64
+
+	c  net.Conn
+	r  *bufio.Reader
+	l  *log.Logger
+	fr *http2.Framer
+
+	// Func used to push streams to the worker pool
+	dispatch func(net.Conn)
+	// TLS connection state, shared by all streams (nil for h2c)
+	tls *tls.ConnectionState
+
+	// Write mutex, guards the framer's writes and the HPACK encoder
+	wmux sync.Mutex
+	henc *hpack.Encoder
+	hbuf bytes.Buffer
+
+	// State mutex, guards everything below
+	mux  sync.Mutex
+	cond *sync.Cond
+
+	streams    map[uint32]*h2Stream
+	maxStreams uint32
+	lastStream uint32
+
+	// Streams opened by the peer
+	opened uint32
+	// Streams reset by the peer or refused by us, used to detect rapid resets (CVE-2023-44487)
+	resets uint32
+
+	// Duration the peer may go without sending a frame before it is pinged
+	idle      time.Duration
+	idleTimer *time.Timer
+	// Time our outstanding ping was sent, zero if no ping is outstanding
+	pingSent time.Time
+
+	// Connection-level send window
+	sendWindow int32
+	// Initial stream send window as set by the peer
+	peerWindow int32
+	// Maximum frame size as set by the peer
+	peerMaxFrame uint32
+
+	goingAway bool
+	closed    bool
+}
+
+// start will send our settings and begin reading frames within a new goroutine
+// Note: Our SETTINGS frame must be the first frame we send on the connection
+func (hc *h2Conn) start() {
+	err := hc.write(func(fr *http2.Framer) error {
+		return fr.WriteSettings(
+			http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: hc.maxStreams},
+			http2.Setting{ID: http2.SettingInitialWindowSize, Val: h2Window},
+			http2.Setting{ID: http2.SettingMaxHeaderListSize, Val: h2MaxHeaderList},
+		)
+	})
+
+	if err != nil {
+		hc.close()
+		return
+	}
+
+	hc.mux.Lock()
+	hc.idleTimer = time.AfterFunc(hc.idle, hc.checkIdle)
+	hc.mux.Unlock()
+
+	go hc.serve()
+}
+
+// serve will read frames until the connection is closed
+func (hc *h2Conn) serve() {
+	defer hc.close()
+
+	var (
+		preface [len(http2.ClientPreface)]byte
+		f       http2.Frame
+		err     error
+	)
+
+	if _, err = io.ReadFull(hc.r, preface[:]); err != nil || !bytes.Equal(preface[:], h2Preface) {
+		return
+	}
+
+	for err == nil {
+		if f, err = hc.fr.ReadFrame(); err == nil {
+			atomic.StoreInt64(&hc.lastFrame, time.Now().UnixNano())
+			err = hc.handleFrame(f)
+		}
+
+		if se, ok := err.(http2.StreamError); ok {
+			// Stream errors only affect a single stream, reset the stream and carry on
+			hc.resetStream(se.StreamID, se.Code)
+			err = nil
+		}
+	}
+
+	if ce, ok := err.(http2.ConnectionError); ok {
+		hc.mux.Lock()
+		last := hc.lastStream
+		hc.mux.Unlock()
+
+		hc.write(func(fr *http2.Framer) error {
+			return fr.WriteGoAway(last, http2.ErrCode(ce), nil)
+		})
+	}
+}
+
+// checkIdle will be called by our idle timer, the peer is pinged once it has been idle for our idle timeout
+// Note: Connections without open streams are closed once idle, as are connections which do not answer our ping in time
+func (hc *h2Conn) checkIdle() {
+	hc.mux.Lock()
+	if hc.closed {
+		hc.mux.Unlock()
+		return
+	}
+
+	last := time.Unix(0, atomic.LoadInt64(&hc.lastFrame))
+	if !hc.pingSent.IsZero() && !last.After(hc.pingSent) {
+		// Our ping was not answered, the peer is unresponsive
+		hc.mux.Unlock()
+		hc.close()
+		return
+	}
+
+	hc.pingSent = time.Time{}
+	if idle := time.Since(last); idle < hc.idle {
+		// A frame has been received since our timer was set
+		hc.idleTimer.Reset(hc.idle - idle)
+		hc.mux.Unlock()
+		return
+	}
+
+	if len(hc.streams) == 0 {
+		// Connection is idle, let the peer know we are closing it
+		id := hc.lastStream
+		hc.mux.Unlock()
+
+		hc.write(func(fr *http2.Framer) error {
+			return fr.WriteGoAway(id, http2.ErrCodeNo, nil)
+		})
+
+		hc.close()
+		return
+	}
+
+	// Streams are open, ensure the peer is still there
+	hc.pingSent = time.Now()
+	hc.idleTimer.Reset(hc.idle)
+	hc.mux.Unlock()
+
+	hc.write(func(fr *http2.Framer) error {
+		return fr.WritePing(false, [8]byte{})
+	})
+}
+
+// handleFrame will process a single inbound frame
+func (hc *h2Conn) handleFrame(f http2.Frame) error {
+	switch f := f.(type) {
+	case *http2.SettingsFrame:
+		return hc.onSettings(f)
+	case *http2.MetaHeadersFrame:
+		return hc.onHeaders(f)
+	case *http2.DataFrame:
+		return hc.onData(f)
+	case *http2.WindowUpdateFrame:
+		return hc.onWindowUpdate(f)
+	case *http2.RSTStreamFrame:
+		return hc.onReset(f)
+	case *http2.PingFrame:
+		if f.IsAck() {
+			return nil
+		}
+
+		return hc.write(func(fr *http2.Framer) error {
+			return fr.WritePing(true, f.Data)
+		})
+	case *http2.GoAwayFrame:
+		// Peer will not be sending any new streams, existing streams are allowed to finish
+		hc.mux.Lock()
+		hc.goingAway = true
+		hc.mux.Unlock()
+	case *http2.PushPromiseFrame:
+		// Clients cannot push
+		return http2.ConnectionError(http2.ErrCodeProtocol)
+	}
+
+	// Priority and unknown frames are ignored
+	return nil
+}
+
+// onSettings will apply the peer's settings and acknowledge them
+func (hc *h2Conn) onSettings(f *http2.SettingsFrame) (err error) {
+	if f.IsAck() {
+		return
+	}
+
+	err = f.ForeachSetting(func(s http2.Setting) error {
+		if err := s.Valid(); err != nil {
+			return err
+		}
+
+		hc.applySetting(s)
+		return nil
+	})
+
+	if err != nil {
+		return
+	}
+
+	return hc.write(func(fr *http2.Framer) error {
+		return fr.WriteSettingsAck()
+	})
+}
+
+// applySetting will apply a single setting sent by the peer
+func (hc *h2Conn) applySetting(s http2.Setting) {
+	switch s.ID {
+	case http2.SettingInitialWindowSize:
+		hc.mux.Lock()
+		// Changes to the initial window apply to all open streams (RFC 7540 section 6.9.2)
+		delta := int32(s.Val) - hc.peerWindow
+		hc.peerWindow = int32(s.Val)
+		for _, st := range hc.streams {
+			st.sendWindow += delta
+		}
+
+		hc.cond.Broadcast()
+		hc.mux.Unlock()
+	case http2.SettingMaxFrameSize:
+		atomic.StoreUint32(&hc.peerMaxFrame, s.Val)
+	case http2.SettingHeaderTableSize:
+		hc.wmux.Lock()
+		hc.henc.SetMaxDynamicTableSize(s.Val)
+		hc.wmux.Unlock()
+	}
+}
+
+// upgradeStream will open stream 1 on behalf of an upgraded HTTP/1.1 request
+// Note: The request has been fully received, so the stream is half-closed (remote)
+func (hc *h2Conn) upgradeStream() (s *h2Stream) {
+	hc.mux.Lock()
+	s = newH2Stream(hc, 1, nil)
+	hc.streams[1] = s
+	hc.lastStream = 1
+	hc.mux.Unlock()
+
+	s.endBody(io.EOF)
+	return
+}
+
+// onHeaders will open a new stream and dispatch it to the worker pool
+func (hc *h2Conn) onHeaders(f *http2.MetaHeadersFrame) (err error) {
+	id := f.StreamID
+
+	hc.mux.Lock()
+	if s, ok := hc.streams[id]; ok {
+		hc.mux.Unlock()
+		// Trailers, which must end the stream
+		if !f.StreamEnded() {
+			return http2.ConnectionError(http2.ErrCodeProtocol)
+		}
+
+		s.endBody(io.EOF)
+		return
+	}
+
+	if id%2 == 0 || id <= hc.lastStream {
+		// Client streams must be odd and increasing
+		hc.mux.Unlock()
+		return http2.ConnectionError(http2.ErrCodeProtocol)
+	}
+
+	hc.lastStream = id
+	hc.opened++
+
+	if hc.goingAway {
+		hc.mux.Unlock()
+		return http2.StreamError{StreamID: id, Code: http2.ErrCodeRefusedStream}
+	}
+
+	if uint32(len(hc.streams)) >= hc.maxStreams {
+		// Reset streams are counted until their worker has finished, so resetting streams cannot exceed our limit
+		hc.resets++
+		calm := hc.tooManyResets()
+		hc.mux.Unlock()
+
+		if calm {
+			return http2.ConnectionError(http2.ErrCodeEnhanceYourCalm)
+		}
+
+		return http2.StreamError{StreamID: id, Code: http2.ErrCodeRefusedStream}
+	}
+
+	if f.PseudoValue("method") == "" || f.PseudoValue("path") == "" {
+		hc.mux.Unlock()
+		return http2.StreamError{StreamID: id, Code: http2.ErrCodeProtocol}
+	}
+
+	s := newH2Stream(hc, id, f)
+	hc.streams[id] = s
+	hc.mux.Unlock()
+
+	if f.StreamEnded() {
+		s.endBody(io.EOF)
+	}
+
+	// Streams are counted until closed by their worker, so our pending dispatches are bounded by our stream limit
+	hc.dispatch(s)
+	return
+}
+
+// onReset will cancel a stream which has been reset by the peer
+// ENHANCE_YOUR_CALM is returned if the peer resets too many of its streams
+func (hc *h2Conn) onReset(f *http2.RSTStreamFrame) (err error) {
+	if !hc.cancelStream(f.StreamID) {
+		// Stream has already been reset or closed
+		return
+	}
+
+	hc.mux.Lock()
+	hc.resets++
+	calm := hc.tooManyResets()
+	hc.mux.Unlock()
+
+	if calm {
+		return http2.ConnectionError(http2.ErrCodeEnhanceYourCalm)
+	}
+
+	return
+}
+
+// tooManyResets will return whether or not the peer has had too many of its streams reset
+// Note: The state mutex must be held by the caller
+func (hc *h2Conn) tooManyResets() bool {
+	// Occasional resets are expected (IE: cancelled navigations), peers which reset most of their streams are not
+	return hc.resets > h2ResetsPerStream*hc.maxStreams && hc.resets > hc.opened/2
+}
+
+// onData will push the payload of a data frame to its stream
+func (hc *h2Conn) onData(f *http2.DataFrame) (err error) {
+	var (
+		id   = f.StreamID
+		data = f.Data()
+		// Flow control applies to the entire payload, including padding
+		fl = f.Header().Length
+	)
+
+	if fl > 0 {
+		// We replenish the connection window immediately, streams are bounded by their own windows
+		if err = hc.write(func(fr *http2.Framer) error {
+			return fr.WriteWindowUpdate(0, fl)
+		}); err != nil {
+			return
+		}
+	}
+
+	hc.mux.Lock()
+	s, ok := hc.streams[id]
+	last := hc.lastStream
+	hc.mux.Unlock()
+
+	if !ok {
+		if id > last {
+			// Data cannot be sent on an idle stream
+			return http2.ConnectionError(http2.ErrCodeProtocol)
+		}
+
+		return http2.StreamError{StreamID: id, Code: http2.ErrCodeStreamClosed}
+	}
+
+	return s.pushData(data, int32(fl), f.StreamEnded())
+}
+
+// onWindowUpdate will increase the send window of the connection or a stream
+func (hc *h2Conn) onWindowUpdate(f *http2.WindowUpdateFrame) error {
+	hc.mux.Lock()
+	defer hc.mux.Unlock()
+
+	inc := int64(f.Increment)
+	if f.StreamID == 0 {
+		if int64(hc.sendWindow)+inc > h2MaxWindow {
+			return http2.ConnectionError(http2.ErrCodeFlowControl)
+		}
+
+		hc.sendWindow += int32(inc)
+	} else if s, ok := hc.streams[f.StreamID]; ok {
+		if int64(s.sendWindow)+inc > h2MaxWindow {
+			return http2.StreamError{StreamID: f.StreamID, Code: http2.ErrCodeFlowControl}
+		}
+
+		s.sendWindow += int32(inc)
+	}
+
+	hc.cond.Broadcast()
+	return nil
+}
+
+// write will call the provided func while holding the write lock
+func (hc *h2Conn) write(fn func(*http2.Framer) error) error {
+	hc.wmux.Lock()
+	defer hc.wmux.Unlock()
+	return fn(hc.fr)
+}
+
+// writeHeaders will HPACK-encode and write a header block, splitting it into CONTINUATION frames as needed
+func (hc *h2Conn) writeHeaders(id uint32, fs []hpack.HeaderField, end bool) (err error) {
+	hc.wmux.Lock()
+	defer hc.wmux.Unlock()
+
+	hc.hbuf.Reset()
+	for _, f := range fs {
+		if err = hc.henc.WriteField(f); err != nil {
+			return
+		}
+	}
+
+	var (
+		block = hc.hbuf.Bytes()
+		max   = int(atomic.LoadUint32(&hc.peerMaxFrame))
+		first = true
+	)
+
+	for first || len(block) > 0 {
+		chunk := block
+		if len(chunk) > max {
+			chunk = chunk[:max]
+		}
+
+		block = block[len(chunk):]
+		if first {
+			err = hc.fr.WriteHeaders(http2.HeadersFrameParam{
+				StreamID:      id,
+				BlockFragment: chunk,
+				EndStream:     end,
+				EndHeaders:    len(block) == 0,
+			})
+
+			first = false
+		} else {
+			err = hc.fr.WriteContinuation(id, len(block) == 0, chunk)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// resetStream will send a RST_STREAM frame and cancel the stream
+func (hc *h2Conn) resetStream(id uint32, code http2.ErrCode) {
+	hc.cancelStream(id)
+	hc.write(func(fr *http2.Framer) error {
+		return fr.WriteRSTStream(id, code)
+	})
+}
+
+// cancelStream will mark a stream as reset, failing any pending reads or writes
+// Returns true if the stream was open and has not already been reset
+// Note: The stream remains counted against our stream limit until it is closed by its worker
+func (hc *h2Conn) cancelStream(id uint32) (ok bool) {
+	hc.mux.Lock()
+	s, ok := hc.streams[id]
+	if ok = ok && !s.reset; ok {
+		s.reset = true
+		hc.cond.Broadcast()
+	}
+	hc.mux.Unlock()
+
+	if ok {
+		s.endBody(ErrStreamClosed)
+		s.finish()
+	}
+
+	return
+}
+
+// removeStream will remove a stream, failing any pending reads or writes with the provided error
+func (hc *h2Conn) removeStream(id uint32, err error) {
+	hc.mux.Lock()
+	s, ok := hc.streams[id]
+	if ok {
+		delete(hc.streams, id)
+		s.reset = true
+		hc.cond.Broadcast()
+	}
+	hc.mux.Unlock()
+
+	if ok {
+		s.endBody(err)
+		s.finish()
+	}
+}
+
+// close will close the connection and all of its streams
+func (hc *h2Conn) close() {
+	hc.mux.Lock()
+	hc.closed = true
+	if hc.idleTimer != nil {
+		hc.idleTimer.Stop()
+	}
+
+	streams := hc.streams
+	hc.streams = make(map[uint32]*h2Stream)
+	for _, s := range streams {
+		s.reset = true
+	}
+
+	hc.cond.Broadcast()
+	hc.mux.Unlock()
+
+	for _, s := range streams {
+		s.endBody(ErrStreamClosed)
+		s.finish()
+	}
+
+	hc.c.Close()
+}
+
+// newH2Stream will return a new stream
+// Note: The headers frame may be nil for streams opened via an HTTP/1.1 upgrade
+func newH2Stream(hc *h2Conn, id uint32, f *http2.MetaHeadersFrame) (s *h2Stream) {
+	s = &h2Stream{
+		hc: hc,
+		id: id,
+
+		sendWindow: hc.peerWindow,
+		recvWindow: h2Window,
+
+		done: make(chan struct{}),
+	}
+
+	if f != nil {
+		s.method = f.PseudoValue("method")
+		s.path = f.PseudoValue("path")
+		s.authority = f.PseudoValue("authority")
+		s.fields = f.RegularFields()
+	}
+
+	s.bcond = sync.NewCond(&s.bmux)
+	return
+}
+
+// h2Stream is a single HTTP/2 stream, it satisfies net.Conn so it may be pushed through the worker queue
+// Note: Reads return the request body and writes are sent as DATA frames
+type h2Stream struct {
+	hc *h2Conn
+	id uint32
+
+	method    string
+	path      string
+	authority string
+	fields    []hpack.HeaderField
+
+	// Body mutex, guards everything below
+	bmux    sync.Mutex
+	bcond   *sync.Cond
+	body    bytes.Buffer
+	bodyErr error
+	// Bytes the peer may send before we issue a WINDOW_UPDATE
+	recvWindow int32
+	// Read deadline, the timer wakes any pending reads once it has passed
+	readDeadline time.Time
+	readTimer    *time.Timer
+
+	// Guarded by the connection's state mutex
+	sendWindow int32
+	reset      bool
+	// Write deadline, the timer wakes any pending writes once it has passed
+	writeDeadline time.Time
+	writeTimer    *time.Timer
+
+	headersSent bool
+	ended       bool
+
+	once sync.Once
+	done chan struct{}
+}
+
+// populate will populate a request with the headers of the stream
+// ErrInvalidHeaderStatus is returned if the stream's fields are malformed (IE: an invalid Content-Length)
+func (s *h2Stream) populate(req *Request) (err error) {
+	var cookies []string
+
+	req.method = append(req.method, s.method...)
+	req.path = append(req.path, s.path...)
+	req.httpType = append(req.httpType, h2HTTPType...)
+	req.host = append(req.host, s.authority...)
+	// The :authority pseudo-header takes precedence over any Host header, as with absolute-form targets
+	req.absolute = len(s.authority) > 0
+	req.tls = s.hc.tls
+	req.remoteAddr = s.RemoteAddr()
+	req.localAddr = s.LocalAddr()
+
+	for _, f := range s.fields {
+		if f.Name == "cookie" {
+			// Cookies may be split into multiple fields (RFC 7540 section 8.1.2.5)
+			cookies = append(cookies, f.Value)
+			continue
+		}
+
+		if err = req.setHeader([]byte(f.Name), []byte(f.Value)); err != nil {
+			return
+		}
+	}
+
+	if len(cookies) > 0 {
+		err = req.setHeader([]byte("Cookie"), []byte(strings.Join(cookies, "; ")))
+	}
+
+	return
+}
+
+// pushData will append inbound data to the body of the stream
+func (s *h2Stream) pushData(data []byte, fl int32, end bool) (err error) {
+	s.bmux.Lock()
+	defer s.bmux.Unlock()
+
+	if s.bodyErr != nil {
+		return http2.StreamError{StreamID: s.id, Code: http2.ErrCodeStreamClosed}
+	}
+
+	if fl > s.recvWindow {
+		return http2.StreamError{StreamID: s.id, Code: http2.ErrCodeFlowControl}
+	}
+
+	s.recvWindow -= fl
+	s.body.Write(data)
+
+	if pad := fl - int32(len(data)); pad > 0 && !end {
+		// Padding will never be read, replenish it immediately
+		s.recvWindow += pad
+		s.hc.write(func(fr *http2.Framer) error {
+			return fr.WriteWindowUpdate(s.id, uint32(pad))
+		})
+	}
+
+	if end {
+		s.bodyErr = io.EOF
+	}
+
+	s.bcond.Broadcast()
+	return
+}
+
+// endBody will end the body of the stream with the provided error
+func (s *h2Stream) endBody(err error) {
+	s.bmux.Lock()
+	if s.bodyErr == nil {
+		s.bodyErr = err
+	}
+
+	s.bcond.Broadcast()
+	s.bmux.Unlock()
+}
+
+// finish will mark the stream as finished
+func (s *h2Stream) finish() {
+	s.once.Do(func() { close(s.done) })
+}
+
+// Read will read from the request body
+func (s *h2Stream) Read(b []byte) (n int, err error) {
+	s.bmux.Lock()
+	for s.body.Len() == 0 && s.bodyErr == nil {
+		if isExpired(s.readDeadline) {
+			s.bmux.Unlock()
+			return 0, ErrStreamTimeout
+		}
+
+		s.bcond.Wait()
+	}
+
+	if s.body.Len() == 0 {
+		err = s.bodyErr
+		s.bmux.Unlock()
+		return
+	}
+
+	n, _ = s.body.Read(b)
+	// Only replenish the window if the peer has more to send
+	update := s.bodyErr == nil
+	if update {
+		s.recvWindow += int32(n)
+	}
+	s.bmux.Unlock()
+
+	if update {
+		s.hc.write(func(fr *http2.Framer) error {
+			return fr.WriteWindowUpdate(s.id, uint32(n))
+		})
+	}
+
+	return
+}
+
+// writeHeaders will write the response headers of the stream
+func (s *h2Stream) writeHeaders(fs []hpack.HeaderField) (err error) {
+	if err = s.hc.writeHeaders(s.id, fs, false); err == nil {
+		s.headersSent = true
+	}
+
+	return
+}
+
+// Write will write the provided bytes as DATA frames, respecting flow control
+func (s *h2Stream) Write(b []byte) (n int, err error) {
+	hc := s.hc
+	for len(b) > 0 {
+		hc.mux.Lock()
+		for !s.reset && !hc.closed && (s.sendWindow <= 0 || hc.sendWindow <= 0) {
+			if isExpired(s.writeDeadline) {
+				// The peer has not opened its window in time
+				hc.mux.Unlock()
+				return n, ErrStreamTimeout
+			}
+
+			hc.cond.Wait()
+		}
+
+		if s.reset || hc.closed {
+			hc.mux.Unlock()
+			return n, ErrStreamClosed
+		}
+
+		size := int32(len(b))
+		if size > s.sendWindow {
+			size = s.sendWindow
+		}
+
+		if size > hc.sendWindow {
+			size = hc.sendWindow
+		}
+
+		if max := int32(atomic.LoadUint32(&hc.peerMaxFrame)); size > max {
+			size = max
+		}
+
+		s.sendWindow -= size
+		hc.sendWindow -= size
+		hc.mux.Unlock()
+
+		if err = hc.write(func(fr *http2.Framer) error {
+			return fr.WriteData(s.id, false, b[:size])
+		}); err != nil {
+			return
+		}
+
+		b = b[size:]
+		n += int(size)
+	}
+
+	return
+}
+
+// Close will end the stream
+func (s *h2Stream) Close() (err error) {
+	if s.ended {
+		return
+	}
+
+	s.ended = true
+	// Stop our deadline timers
+	s.SetDeadline(time.Time{})
+
+	if s.isReset() {
+		// Our stream no longer counts against the stream limit of our connection
+		s.hc.removeStream(s.id, ErrStreamClosed)
+		return ErrStreamClosed
+	}
+
+	// Empty DATA frames are not subject to flow control
+	err = s.hc.write(func(fr *http2.Framer) error {
+		return fr.WriteData(s.id, true, nil)
+	})
+
+	s.bmux.Lock()
+	unread := s.bodyErr == nil
+	s.bmux.Unlock()
+
+	if unread {
+		// Our response is complete, the client no longer needs to send its body (RFC 7540 section 8.1)
+		s.hc.resetStream(s.id, http2.ErrCodeNo)
+	}
+
+	s.hc.removeStream(s.id, ErrStreamClosed)
+	return
+}
+
+// isReset will return whether or not the stream has been reset
+func (s *h2Stream) isReset() (reset bool) {
+	s.hc.mux.Lock()
+	reset = s.reset
+	s.hc.mux.Unlock()
+	return
+}
+
+// LocalAddr will return the local address of the underlying connection
+func (s *h2Stream) LocalAddr() net.Addr {
+	return s.hc.c.LocalAddr()
+}
+
+// RemoteAddr will return the remote address of the underlying connection
+func (s *h2Stream) RemoteAddr() net.Addr {
+	return s.hc.c.RemoteAddr()
+}
+
+// SetDeadline will set the read and write deadlines of the stream
+func (s *h2Stream) SetDeadline(t time.Time) error {
+	s.SetReadDeadline(t)
+	return s.SetWriteDeadline(t)
+}
+
+// SetReadDeadline will set the deadline for reads of the request body, a zero value disables the deadline
+// Note: Reads which are pending once the deadline has passed return ErrStreamTimeout
+func (s *h2Stream) SetReadDeadline(t time.Time) error {
+	s.bmux.Lock()
+	s.readDeadline = t
+	s.readTimer = deadlineTimer(s.readTimer, t, s.bcond)
+	s.bmux.Unlock()
+	return nil
+}
+
+// SetWriteDeadline will set the deadline for writes awaiting flow control, a zero value disables the deadline
+// Note: Writes which are pending once the deadline has passed return ErrStreamTimeout
+func (s *h2Stream) SetWriteDeadline(t time.Time) error {
+	s.hc.mux.Lock()
+	s.writeDeadline = t
+	s.writeTimer = deadlineTimer(s.writeTimer, t, s.hc.cond)
+	s.hc.mux.Unlock()
+	return nil
+}
+
+// deadlineTimer will stop the provided timer and return a new timer which wakes the waiters of cond once the deadline has passed
+// Note: Nil is returned for a zero deadline, the lock of cond must be held by the caller
+func deadlineTimer(tmr *time.Timer, t time.Time, cond *sync.Cond) *time.Timer {
+	if tmr != nil {
+		tmr.Stop()
+	}
+
+	if t.IsZero() {
+		return nil
+	}
+
+	return time.AfterFunc(time.Until(t), func() {
+		cond.L.Lock()
+		cond.Broadcast()
+		cond.L.Unlock()
+	})
+}
+
+// isExpired will return whether or not a deadline has passed, zero deadlines never pass
+func isExpired(t time.Time) bool {
+	return !t.IsZero() && !time.Now().Before(t)
+}
@@ -0,0 +1,475 @@
+package webWorkers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+const (
+	h2Addr  = "localhost:11113"
+	h2cAddr = "localhost:11114"
+)
+
+func TestHTTP2(t *testing.T) {
+	var (
+		dir string
+		tp  TLSPair
+		ww  *Webworkers
+		err error
+	)
+
+	if dir, err = ioutil.TempDir("", "webWorkers"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tp = writeTestCert(t, dir, "localhost")
+	opts := Opts{
+		WorkerCap: 4,
+		QueueLen:  64,
+		Address:   ":11113",
+		TLS:       true,
+		Certs:     []TLSPair{tp},
+		HTTP2:     true,
+	}
+
+	if ww, err = New(opts, h2Handler); err != nil {
+		t.Fatal(err)
+	}
+
+	go ww.Listen()
+	time.Sleep(time.Millisecond * 100)
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Bodies larger than the default window exercise flow control in both directions
+			body := bytes.Repeat([]byte{'a'}, 100000+i)
+			resp, err := client.Post("https://"+h2Addr+"/echo", "text/plain", bytes.NewReader(body))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+
+			if resp.ProtoMajor != 2 {
+				t.Errorf("expected HTTP/2, received %s", resp.Proto)
+			}
+
+			if resp.Header.Get("X-Length") != strconv.Itoa(len(body)) {
+				t.Errorf("invalid length header, received %s", resp.Header.Get("X-Length"))
+			}
+
+			out, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Error(err)
+			} else if !bytes.Equal(out, body) {
+				t.Errorf("invalid echo, received %d bytes", len(out))
+			}
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+func TestHTTP2HandshakeTimeout(t *testing.T) {
+	var (
+		dir string
+		ww  *Webworkers
+		err error
+	)
+
+	if dir, err = ioutil.TempDir("", "webWorkers"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := Opts{
+		WorkerCap:         1,
+		QueueLen:          16,
+		Address:           ":11136",
+		TLS:               true,
+		Certs:             []TLSPair{writeTestCert(t, dir, "localhost")},
+		HTTP2:             true,
+		ReadHeaderTimeout: time.Millisecond * 200,
+	}
+
+	if ww, err = New(opts, h2Handler); err != nil {
+		t.Fatal(err)
+	}
+
+	go ww.Listen()
+	time.Sleep(time.Millisecond * 100)
+
+	// Stall before sending a ClientHello, holding our only worker
+	slow, err := net.Dial("tcp4", "localhost:11136")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+
+	slow.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = slow.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected our stalled handshake to be closed, received %v", err)
+	}
+
+	// Our worker has been released for other clients
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		},
+		Timeout: time.Second,
+	}
+
+	resp, err := client.Post("https://localhost:11136/echo", "text/plain", bytes.NewReader(jsonB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("expected HTTP/2, received %s", resp.Proto)
+	}
+}
+
+func TestH2CPriorKnowledge(t *testing.T) {
+	startH2C(t)
+
+	client := &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		},
+	}
+
+	for i := 0; i < 4; i++ {
+		resp, err := client.Post("http://"+h2cAddr+"/echo", "text/plain", bytes.NewReader(jsonB))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.ProtoMajor != 2 || !bytes.Equal(out, jsonB) {
+			t.Fatalf("invalid response, received %s \"%s\"", resp.Proto, out)
+		}
+	}
+}
+
+func TestH2CUpgrade(t *testing.T) {
+	startH2C(t)
+
+	c, err := net.Dial("tcp", h2cAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Write([]byte("GET /upgrade HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAMAAABkAAQAAP__\r\n\r\n"))
+
+	br := bufio.NewReader(c)
+	if status, _ := br.ReadString('\n'); !strings.HasPrefix(status, "HTTP/1.1 101 Switching Protocols\r\n") {
+		t.Fatalf("expected 101, received \"%s\"", status)
+	}
+
+	for {
+		if line, err := br.ReadString('\n'); err != nil {
+			t.Fatal(err)
+		} else if line == "\r\n" {
+			break
+		}
+	}
+
+	c.Write(h2Preface)
+	fr := http2.NewFramer(c, br)
+	fr.ReadMetaHeaders = hpack.NewDecoder(h2TableSize, nil)
+	fr.WriteSettings()
+
+	f, err := fr.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := f.(*http2.SettingsFrame); !ok {
+		t.Fatalf("expected server preface SETTINGS frame, received %v", f)
+	}
+
+	var status string
+	for {
+		if f, err = fr.ReadFrame(); err != nil {
+			t.Fatal(err)
+		}
+
+		if f.Header().StreamID != 1 {
+			continue
+		}
+
+		if hf, ok := f.(*http2.MetaHeadersFrame); ok {
+			status = hf.PseudoValue("status")
+		}
+
+		if df, ok := f.(*http2.DataFrame); ok && df.StreamEnded() {
+			break
+		}
+	}
+
+	if status != "200" {
+		t.Fatalf("expected status 200 on stream 1, received \"%s\"", status)
+	}
+}
+
+func TestH2CRapidReset(t *testing.T) {
+	var (
+		calls   int32
+		started = make(chan struct{}, 1)
+		release = make(chan struct{})
+	)
+
+	ww, err := New(Opts{WorkerCap: 1, QueueLen: 16, Address: ":11137", H2C: true, HTTP2MaxStreams: 4}, func(res *Response, req *Request) {
+		atomic.AddInt32(&calls, 1)
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+
+		<-release
+		res.Write(nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go ww.Listen()
+	time.Sleep(time.Millisecond * 100)
+
+	c, err := net.Dial("tcp", "localhost:11137")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	block := h2cHeaderBlock("/")
+	c.Write(h2Preface)
+	fr := http2.NewFramer(c, bufio.NewReader(c))
+	fr.WriteSettings()
+
+	open := func(id uint32) {
+		fr.WriteHeaders(http2.HeadersFrameParam{StreamID: id, BlockFragment: block, EndStream: true, EndHeaders: true})
+	}
+
+	// Hold our only worker with the first stream
+	open(1)
+	<-started
+
+	// Open and reset more than HTTP2MaxStreams streams, reset streams must remain counted until their worker finishes
+	for id := uint32(3); id < 20; id += 2 {
+		open(id)
+		fr.WriteRSTStream(id, http2.ErrCodeCancel)
+	}
+
+	var refused []uint32
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		f, err := fr.ReadFrame()
+		if err != nil {
+			t.Fatalf("expected GOAWAY, received %v", err)
+		}
+
+		if rf, ok := f.(*http2.RSTStreamFrame); ok && rf.ErrCode == http2.ErrCodeRefusedStream {
+			refused = append(refused, rf.StreamID)
+		}
+
+		if gf, ok := f.(*http2.GoAwayFrame); ok {
+			if gf.ErrCode != http2.ErrCodeEnhanceYourCalm {
+				t.Fatalf("expected ENHANCE_YOUR_CALM, received %v", gf.ErrCode)
+			}
+
+			break
+		}
+	}
+
+	if len(refused) == 0 || refused[0] != 9 {
+		t.Fatalf("expected streams beyond our limit to be refused from stream 9, received %v", refused)
+	}
+
+	close(release)
+	time.Sleep(time.Millisecond * 100)
+
+	// Reset streams which were pending dispatch are never handled
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("expected our handler to be called once, received %d", n)
+	}
+}
+
+func TestH2CWriteDeadline(t *testing.T) {
+	errs := make(chan error, 1)
+	ww, err := New(Opts{WorkerCap: 1, QueueLen: 16, Address: ":11138", H2C: true}, func(res *Response, req *Request) {
+		// Headers are not subject to flow control
+		res.Write(nil)
+		res.conn.SetWriteDeadline(time.Now().Add(time.Millisecond * 100))
+		_, err := res.conn.Write([]byte("hello"))
+		errs <- err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go ww.Listen()
+	time.Sleep(time.Millisecond * 100)
+
+	c, err := net.Dial("tcp", "localhost:11138")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Our window is never opened, our handler's write must not wait forever
+	c.Write(h2Preface)
+	fr := http2.NewFramer(c, bufio.NewReader(c))
+	fr.WriteSettings(http2.Setting{ID: http2.SettingInitialWindowSize, Val: 0})
+	fr.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, BlockFragment: h2cHeaderBlock("/"), EndStream: true, EndHeaders: true})
+
+	select {
+	case err = <-errs:
+		if err != ErrStreamTimeout {
+			t.Fatalf("expected ErrStreamTimeout and received %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("write did not return once its deadline had passed")
+	}
+}
+
+func TestH2CIdleTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	ww, err := New(Opts{WorkerCap: 2, QueueLen: 16, Address: ":11139", H2C: true, HTTP2IdleTimeout: time.Millisecond * 200}, func(res *Response, req *Request) {
+		if req.Path() == "/block" {
+			<-release
+		}
+
+		res.Write(nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go ww.Listen()
+	time.Sleep(time.Millisecond * 100)
+
+	// Connections without open streams are sent GOAWAY once idle
+	c, fr := h2cDial(t, "localhost:11139")
+	defer c.Close()
+
+	f, err := h2cReadUntil(fr, func(f http2.Frame) bool {
+		_, ok := f.(*http2.GoAwayFrame)
+		return ok
+	})
+	if err != nil {
+		t.Fatalf("expected GOAWAY, received %v", err)
+	}
+
+	if code := f.(*http2.GoAwayFrame).ErrCode; code != http2.ErrCodeNo {
+		t.Fatalf("expected NO_ERROR, received %v", code)
+	}
+
+	// Connections with open streams are pinged, and closed if the ping is not answered
+	pc, pfr := h2cDial(t, "localhost:11139")
+	defer pc.Close()
+
+	pfr.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, BlockFragment: h2cHeaderBlock("/block"), EndStream: true, EndHeaders: true})
+	if _, err = h2cReadUntil(pfr, func(f http2.Frame) bool {
+		pf, ok := f.(*http2.PingFrame)
+		return ok && !pf.IsAck()
+	}); err != nil {
+		t.Fatalf("expected PING, received %v", err)
+	}
+
+	if _, err = h2cReadUntil(pfr, func(http2.Frame) bool { return false }); err != io.EOF {
+		t.Fatalf("expected our unresponsive connection to be closed, received %v", err)
+	}
+}
+
+// h2cDial will open a prior knowledge h2c connection
+func h2cDial(t *testing.T, addr string) (c net.Conn, fr *http2.Framer) {
+	var err error
+	if c, err = net.Dial("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+
+	c.SetReadDeadline(time.Now().Add(time.Second * 2))
+	c.Write(h2Preface)
+	fr = http2.NewFramer(c, bufio.NewReader(c))
+	fr.WriteSettings()
+	return
+}
+
+// h2cReadUntil will read frames until the provided func returns true
+func h2cReadUntil(fr *http2.Framer, fn func(http2.Frame) bool) (f http2.Frame, err error) {
+	for {
+		if f, err = fr.ReadFrame(); err != nil || fn(f) {
+			return
+		}
+	}
+}
+
+// h2cHeaderBlock will return the HPACK-encoded header block of a GET request
+func h2cHeaderBlock(path string) []byte {
+	var buf bytes.Buffer
+	enc := hpack.NewEncoder(&buf)
+	enc.WriteField(hpack.HeaderField{Name: ":method", Value: "GET"})
+	enc.WriteField(hpack.HeaderField{Name: ":scheme", Value: "http"})
+	enc.WriteField(hpack.HeaderField{Name: ":path", Value: path})
+	enc.WriteField(hpack.HeaderField{Name: ":authority", Value: "localhost"})
+	return buf.Bytes()
+}
+
+func startH2C(t *testing.T) {
+	h2cOnce.Do(func() {
+		ww, err := New(Opts{WorkerCap: 2, QueueLen: 64, Address: ":11114", H2C: true}, h2Handler)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go ww.Listen()
+		time.Sleep(time.Millisecond * 100)
+	})
+}
+
+var h2cOnce sync.Once
+
+func h2Handler(res *Response, req *Request) {
+	body, _ := ioutil.ReadAll(io.LimitReader(req.Body, int64(req.ContentLength())))
+	res.StatusCode(StatusOK)
+	res.ContentType("text/plain")
+	res.Header("X-Length", strconv.Itoa(len(body)))
+	res.Write(body)
+}
@@ -0,0 +1,151 @@
+package webWorkers
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	// defaultReadHeaderTimeout is the default maximum duration to read a request line and headers
+	defaultReadHeaderTimeout = time.Second * 10
+	// defaultMaxHeaderBytes is the default maximum size of a request line and headers
+	defaultMaxHeaderBytes = 1 << 16
+	// defaultMaxHeaderCount is the default maximum number of request headers
+	defaultMaxHeaderCount = 100
+	// defaultMaxURILength is the default maximum length of a request URI
+	defaultMaxURILength = 1 << 13
+)
+
+// readHeader will read from a connection until the empty line which terminates the request header
+// Note: Headers larger than our buffer are read into a growing buffer, up to MaxHeaderBytes
+// If the connection is closed before the header is complete, the bytes read so far are returned
+// ErrHeaderTimeout is returned if the header is not complete within ReadHeaderTimeout, so slow clients cannot hold a worker
+func (w *worker) readHeader(c net.Conn) (bs []byte, err error) {
+	if err = c.SetReadDeadline(time.Now().Add(w.o.ReadHeaderTimeout)); err != nil {
+		return
+	}
+	// Our handler is not bound by our header deadline
+	defer c.SetReadDeadline(time.Time{})
+
+	var n int
+	bs = w.hbuf[:0]
+	for {
+		if len(bs) >= w.o.MaxHeaderBytes {
+			if bytes.IndexByte(bs, '\n') == -1 && len(bs) > w.o.MaxURILength {
+				// We have not reached the end of our request line, our URI is too long
+				return bs, ErrURITooLong
+			}
+
+			return bs, ErrHeaderTooLarge
+		}
+
+		if len(bs) == cap(bs) {
+			// Grow our buffer, retaining it for following requests
+			nb := make([]byte, len(bs), cap(bs)*2)
+			copy(nb, bs)
+			bs, w.hbuf = nb, nb
+		}
+
+		// The end of our header may begin within the previous read
+		from := len(bs) - 2
+		if from < 0 {
+			from = 0
+		}
+
+		end := cap(bs)
+		if end > w.o.MaxHeaderBytes {
+			end = w.o.MaxHeaderBytes
+		}
+
+		n, err = c.Read(bs[len(bs):end])
+		bs = bs[:len(bs)+n]
+
+		if headerEnd(bs, from) > -1 {
+			return bs, nil
+		}
+
+		if err == io.EOF {
+			return bs, nil
+		} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return bs, ErrHeaderTimeout
+		} else if err != nil {
+			return
+		}
+	}
+}
+
+// headerEnd will return the index following the empty line which terminates a request header, searching from the provided index
+// -1 is returned if the header is incomplete
+func headerEnd(bs []byte, from int) int {
+	for i := from; i < len(bs); i++ {
+		j := bytes.IndexByte(bs[i:], '\n')
+		if j == -1 {
+			break
+		}
+
+		i += j
+		switch rest := bs[i+1:]; {
+		case len(rest) > 0 && rest[0] == '\n':
+			return i + 2
+		case len(rest) > 1 && rest[0] == '\r' && rest[1] == '\n':
+			return i + 3
+		}
+	}
+
+	return -1
+}
+
+// checkLimits will return an error if a parsed request exceeds our limits
+func (w *worker) checkLimits(req *Request) (err error) {
+	switch {
+	case len(req.path) > w.o.MaxURILength:
+		err = ErrURITooLong
+	case len(req.hdrs) > w.o.MaxHeaderCount:
+		err = ErrTooManyHeaders
+	case w.o.MaxBodyBytes > 0 && int64(req.contentLength) > w.o.MaxBodyBytes:
+		err = ErrBodyTooLarge
+	}
+
+	return
+}
+
+// limitedBody is a request body which returns ErrBodyTooLarge once more than its limit has been read
+type limitedBody struct {
+	body io.Reader
+	// Bytes remaining before our limit is reached
+	n int64
+	// Whether or not our limit has been exceeded
+	exceeded bool
+}
+
+// reset will reset the limitedBody with a provided body and limit
+func (l *limitedBody) reset(body io.Reader, max int64) {
+	l.body = body
+	l.n = max
+	l.exceeded = false
+}
+
+// Read will read from the underlying body, ErrBodyTooLarge is returned once our limit has been exceeded
+func (l *limitedBody) Read(b []byte) (n int, err error) {
+	if l.exceeded {
+		return 0, ErrBodyTooLarge
+	}
+
+	// Read a single byte beyond our limit, so a body of exactly our limit is not rejected
+	if int64(len(b)) > l.n+1 {
+		b = b[:l.n+1]
+	}
+
+	n, err = l.body.Read(b)
+	if int64(n) > l.n {
+		n = int(l.n)
+		l.n = 0
+		l.exceeded = true
+		return n, ErrBodyTooLarge
+	}
+
+	l.n -= int64(n)
+	return
+}
@@ -0,0 +1,163 @@
+package webWorkers
+
+import (
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimits(t *testing.T) {
+	ww, err := New(Opts{
+		WorkerCap:      2,
+		QueueLen:       16,
+		Address:        ":11128",
+		MaxHeaderBytes: 1 << 15,
+		MaxHeaderCount: 8,
+		MaxURILength:   1 << 10,
+		MaxBodyBytes:   16,
+	}, func(res *Response, req *Request) {
+		b, err := ioutil.ReadAll(req.Body)
+		if err == ErrBodyTooLarge {
+			return
+		}
+
+		res.Write([]byte("hello " + req.Header("X-Big")[:1] + string(b)))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ww.Close()
+
+	go ww.Listen()
+	time.Sleep(time.Millisecond * 100)
+
+	big := strings.Repeat("a", 1<<14)
+	// Requests which exceed our limits are sized to be read in full, so our responses are not lost to a reset connection
+	pad := func(prefix string) string {
+		return prefix + strings.Repeat("a", 1<<15-len(prefix))
+	}
+
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{"split header", []string{"GET / HTTP/1.1\r\nHo", "st: localhost\r\nX-Big: a\r", "\n\r\n"}, "hello a"},
+		{"header larger than our buffer", []string{"GET / HTTP/1.1\r\nHost: localhost\r\nX-Big: " + big + "\r\n\r\n"}, "hello a"},
+		{"body", []string{"POST / HTTP/1.1\r\nHost: localhost\r\nX-Big: a\r\nContent-Length: 4\r\n\r\n", "body"}, "hello abody"},
+		{"header too large", []string{pad("GET / HTTP/1.1\r\nHost: localhost\r\nX-Big: ")}, "HTTP/1.1 431 Request Header Fields Too Large\r\n"},
+		{"too many headers", []string{"GET / HTTP/1.1\r\nHost: localhost\r\n" + strings.Repeat("X-Big: a\r\n", 8) + "\r\n"}, "HTTP/1.1 431 Request Header Fields Too Large\r\n"},
+		{"URI too long", []string{"GET /" + big[:1<<10] + " HTTP/1.1\r\nHost: localhost\r\n\r\n"}, "HTTP/1.1 414 Request-URI Too Long\r\n"},
+		{"request line too large", []string{pad("GET /")}, "HTTP/1.1 414 Request-URI Too Long\r\n"},
+		{"content length too large", []string{"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 17\r\n\r\n"}, "HTTP/1.1 413 Request Entity Too Large\r\n"},
+		{"body too large", []string{"POST / HTTP/1.1\r\nHost: localhost\r\n\r\n" + big[:20]}, "HTTP/1.1 413 Request Entity Too Large\r\n"},
+	}
+
+	for _, tt := range tests {
+		c, err := net.Dial("tcp4", "localhost:11128")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, w := range tt.writes {
+			c.Write([]byte(w))
+			time.Sleep(time.Millisecond * 10)
+		}
+
+		c.(*net.TCPConn).CloseWrite()
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		b, _ := ioutil.ReadAll(c)
+		c.Close()
+
+		if out := string(b); !strings.HasPrefix(out, tt.want) && !strings.HasSuffix(out, tt.want) {
+			t.Fatalf("%s: expected %q and received %q", tt.name, tt.want, out)
+		}
+	}
+}
+
+func TestHeaderEnd(t *testing.T) {
+	tests := []struct {
+		in   string
+		from int
+		want int
+	}{
+		{"GET / HTTP/1.1\r\nHost: a\r\n\r\nbody", 0, 27},
+		{"GET / HTTP/1.1\nHost: a\n\nbody", 0, 24},
+		{"GET / HTTP/1.1\r\nHost: a\r\n", 0, -1},
+		{"GET / HTTP/1.1\r\nHost: a\r\n\r", 0, -1},
+		{"GET / HTTP/1.1\r\nHost: a\r\n\r\n", 24, 27},
+	}
+
+	for _, tt := range tests {
+		if got := headerEnd([]byte(tt.in), tt.from); got != tt.want {
+			t.Fatalf("headerEnd(%q, %d): expected %d and received %d", tt.in, tt.from, tt.want, got)
+		}
+	}
+}
+
+func TestLimitedBody(t *testing.T) {
+	var lb limitedBody
+	lb.reset(strings.NewReader("hello"), 5)
+	if b, err := ioutil.ReadAll(&lb); err != nil || string(b) != "hello" {
+		t.Fatalf("expected \"hello\" and received %q (%v)", b, err)
+	}
+
+	lb.reset(strings.NewReader("hello world"), 5)
+	b, err := ioutil.ReadAll(&lb)
+	if err != ErrBodyTooLarge {
+		t.Fatalf("expected ErrBodyTooLarge and received %v", err)
+	}
+
+	if string(b) != "hello" || !lb.exceeded {
+		t.Fatalf("expected \"hello\" to be read before our limit and received %q", b)
+	}
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	ww, err := New(Opts{
+		WorkerCap:         1,
+		QueueLen:          16,
+		Address:           ":11130",
+		ReadHeaderTimeout: time.Millisecond * 200,
+	}, func(res *Response, req *Request) {
+		res.Write([]byte("hello"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ww.Close()
+
+	go ww.Listen()
+	time.Sleep(time.Millisecond * 100)
+
+	// Trickle an incomplete header, holding our only worker
+	slow, err := net.Dial("tcp4", "localhost:11130")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+
+	slow.Write([]byte("GET / HTTP/1.1\r\n"))
+	time.Sleep(time.Millisecond * 100)
+	slow.Write([]byte("Host: local"))
+
+	slow.SetReadDeadline(time.Now().Add(time.Second))
+	if b, _ := ioutil.ReadAll(slow); !strings.HasPrefix(string(b), "HTTP/1.1 408 Request Timeout\r\n") {
+		t.Fatalf("expected 408 and received %q", b)
+	}
+
+	// Our worker has been released for other clients
+	c, err := net.Dial("tcp4", "localhost:11130")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if b, _ := ioutil.ReadAll(c); !strings.HasPrefix(string(b), "HTTP/1.1 200 OK\r\n") || !strings.HasSuffix(string(b), "hello") {
+		t.Fatalf("expected 200 and received %q", b)
+	}
+}
@@ -0,0 +1,341 @@
+package webWorkers
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	networkTCP  = "tcp"
+	networkUnix = "unix"
+
+	prefixTCP  = "tcp:"
+	prefixTLS  = "tls:"
+	prefixUnix = "unix:"
+
+	// acceptMinDelay is the initial delay before retrying a failed accept
+	acceptMinDelay = time.Millisecond * 5
+	// acceptMaxDelay is the maximum delay before retrying a failed accept
+	acceptMaxDelay = time.Second
+
+	// staleDialTimeout is how long we wait when checking whether an existing unix socket is still in use
+	staleDialTimeout = time.Second
+)
+
+// listenerSpec is a parsed listener address
+type listenerSpec struct {
+	network  string
+	addr     string
+	tls      bool
+	redirect bool
+}
+
+// parseListener will parse a listener address
+// Addresses may be prefixed with "tcp:" (plaintext), "tls:" (TLS), or "unix:" (unix domain socket)
+// Unprefixed addresses are TCP and use TLS when Opts.TLS is set to true (IE: ":443", "tcp::80", "unix:/run/app.sock")
+func parseListener(spec string, defTLS bool) (ls listenerSpec, err error) {
+	switch {
+	case strings.HasPrefix(spec, prefixUnix):
+		ls = listenerSpec{network: networkUnix, addr: spec[len(prefixUnix):]}
+	case strings.HasPrefix(spec, prefixTCP):
+		ls = listenerSpec{network: networkTCP, addr: spec[len(prefixTCP):]}
+	case strings.HasPrefix(spec, prefixTLS):
+		ls = listenerSpec{network: networkTCP, addr: spec[len(prefixTLS):], tls: true}
+	default:
+		ls = listenerSpec{network: networkTCP, addr: spec, tls: defTLS}
+	}
+
+	if ls.addr == "" {
+		err = ErrInvalidListener
+	}
+
+	return
+}
+
+// listenerSpecs will return the parsed listeners of a set of Opts, starting with Opts.Address
+func listenerSpecs(o *Opts) (lss []listenerSpec, err error) {
+	specs := o.Listeners
+	if o.Address != "" {
+		specs = append([]string{o.Address}, specs...)
+	}
+
+	for _, spec := range specs {
+		var ls listenerSpec
+		if ls, err = parseListener(spec, o.TLS); err != nil {
+			return
+		}
+
+		if ls.tls && !o.TLS {
+			// TLS listeners require our TLS configuration
+			return nil, ErrTLSDisabled
+		}
+
+		lss = append(lss, ls)
+	}
+
+	if o.RedirectAddress != "" {
+		if !o.TLS {
+			// Redirecting to HTTPS requires our TLS configuration
+			return nil, ErrTLSDisabled
+		}
+
+		lss = append(lss, listenerSpec{network: networkTCP, addr: o.RedirectAddress, redirect: true})
+	}
+
+	return
+}
+
+// listenerNames will return the listener addresses of a set of Opts as a comma-separated list
+func listenerNames(o *Opts) string {
+	names := o.Listeners
+	if o.Address != "" {
+		names = append([]string{o.Address}, names...)
+	}
+
+	if o.RedirectAddress != "" {
+		names = append(names, o.RedirectAddress)
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// newListeners will open every listener, any open listeners are closed if one fails to open
+func (ww *Webworkers) newListeners() (lsts []net.Listener, err error) {
+	var lss []listenerSpec
+	if lss, err = listenerSpecs(&ww.o); err != nil {
+		return
+	}
+
+	for _, ls := range lss {
+		var lst net.Listener
+		if lst, err = ww.newListener(ls); err != nil {
+			for _, lst = range lsts {
+				lst.Close()
+			}
+
+			return nil, err
+		}
+
+		lsts = append(lsts, lst)
+	}
+
+	return
+}
+
+// newListener will open a single listener
+func (ww *Webworkers) newListener(ls listenerSpec) (lst net.Listener, err error) {
+	if ls.network == networkUnix {
+		lst, err = ww.listenUnix(ls.addr)
+	} else {
+		lst, err = net.Listen(networkTCP, ls.addr)
+	}
+
+	if err != nil {
+		return
+	}
+
+	if ww.o.ProxyProtocol {
+		// PROXY protocol headers precede the TLS handshake
+		trusted, _ := parseCIDRs(ww.o.ProxyTrusted)
+		lst = newProxyListener(lst, trusted)
+	}
+
+	// Access lists apply to the client address, so they must follow any PROXY protocol header
+	lst = newAccessListener(lst, ww, ww.o.ProxyProtocol)
+
+	if ls.tls {
+		lst = tls.NewListener(lst, ww.tc)
+	}
+
+	if ls.redirect {
+		lst = &redirectListener{lst}
+	}
+
+	return
+}
+
+// listenUnix will listen on a unix domain socket, removing any stale socket and applying our mode and ownership
+// Note: The socket file is removed once the listener is closed
+func (ww *Webworkers) listenUnix(path string) (lst net.Listener, err error) {
+	if err = removeStaleSocket(path); err != nil {
+		return
+	}
+
+	if lst, err = net.Listen(networkUnix, path); err != nil {
+		return
+	}
+
+	if err = ww.setSocketPerms(path); err != nil {
+		lst.Close()
+		return nil, err
+	}
+
+	return
+}
+
+// setSocketPerms will apply the configured mode and ownership to a unix domain socket
+func (ww *Webworkers) setSocketPerms(path string) (err error) {
+	if ww.o.UnixSocketMode != "" {
+		mode, _ := parseSocketMode(ww.o.UnixSocketMode)
+		if err = os.Chmod(path, mode); err != nil {
+			return
+		}
+	}
+
+	if ww.o.UnixSocketOwner == "" {
+		return
+	}
+
+	var uid, gid int
+	if uid, gid, err = lookupOwner(ww.o.UnixSocketOwner); err != nil {
+		return
+	}
+
+	return os.Chown(path, uid, gid)
+}
+
+// removeStaleSocket will remove a unix domain socket left behind by a previous process
+// Sockets which are still accepting connections are left in place, as are files which are not sockets
+func removeStaleSocket(path string) (err error) {
+	var fi os.FileInfo
+	if fi, err = os.Lstat(path); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return
+	}
+
+	if fi.Mode()&os.ModeSocket == 0 {
+		return ErrNotSocket
+	}
+
+	var c net.Conn
+	if c, err = net.DialTimeout(networkUnix, path, staleDialTimeout); err == nil {
+		c.Close()
+		return ErrSocketInUse
+	}
+
+	return os.Remove(path)
+}
+
+// parseSocketMode will parse an octal file mode (IE: "0660")
+func parseSocketMode(str string) (mode os.FileMode, err error) {
+	var m uint64
+	if m, err = strconv.ParseUint(str, 8, 32); err != nil || m > 0777 {
+		return 0, ErrInvalidSocketMode
+	}
+
+	return os.FileMode(m), nil
+}
+
+// lookupOwner will return the uid and gid of an owner (IE: "www-data:www-data", "1000:1000", "www-data", or ":www-data")
+// Note: An omitted user or group is returned as -1, which leaves it unchanged
+func lookupOwner(owner string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+	name, group := owner, ""
+	if i := strings.IndexByte(owner, ':'); i > -1 {
+		name, group = owner[:i], owner[i+1:]
+	}
+
+	if name != "" {
+		if uid, err = strconv.Atoi(name); err != nil {
+			var u *user.User
+			if u, err = user.Lookup(name); err != nil {
+				return
+			}
+
+			if uid, err = strconv.Atoi(u.Uid); err != nil {
+				return
+			}
+		}
+	}
+
+	if group != "" {
+		if gid, err = strconv.Atoi(group); err != nil {
+			var g *user.Group
+			if g, err = user.LookupGroup(group); err != nil {
+				return
+			}
+
+			gid, err = strconv.Atoi(g.Gid)
+		}
+	}
+
+	return
+}
+
+// accept will accept connections from a listener until it is closed
+// Note: Accept errors (IE: running out of file descriptors) are retried with an exponential backoff
+func (ww *Webworkers) accept(lst net.Listener) {
+	var delay time.Duration
+	for {
+		c, err := lst.Accept()
+		if err == nil {
+			delay = 0
+			ww.admit(c)
+			continue
+		}
+
+		if ww.isClosed() {
+			return
+		}
+
+		ww.l.Println(err)
+		if delay *= 2; delay == 0 {
+			delay = acceptMinDelay
+		} else if delay > acceptMaxDelay {
+			delay = acceptMaxDelay
+		}
+
+		time.Sleep(delay)
+	}
+}
+
+// admit will push a net.Conn to our queue if it is within our connection limits, otherwise it is closed
+func (ww *Webworkers) admit(c net.Conn) {
+	rl, _ := ww.connLimiter.Load().(*RateLimiter)
+	if rl == nil {
+		ww.push(c)
+		return
+	}
+
+	if ww.o.ProxyProtocol {
+		// Resolving the client address reads the PROXY protocol header, which must not block our accept loop
+		go ww.check(c, rl)
+		return
+	}
+
+	ww.check(c, rl)
+}
+
+// check will push a net.Conn to our queue if it is allowed by the provided rate limiter, otherwise it is closed
+func (ww *Webworkers) check(c net.Conn, rl *RateLimiter) {
+	if !rl.allowConn(c) {
+		c.Close()
+		return
+	}
+
+	ww.push(c)
+}
+
+// push will push a net.Conn to our queue
+// Note: If we have been closed, the net.Conn is closed instead
+func (ww *Webworkers) push(c net.Conn) {
+	ww.qmux.RLock()
+	defer ww.qmux.RUnlock()
+
+	if ww.isClosed() {
+		c.Close()
+		return
+	}
+
+	select {
+	case ww.q <- c:
+	case <-ww.done:
+		c.Close()
+	}
+}
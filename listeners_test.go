@@ -0,0 +1,118 @@
+package webWorkers
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListeners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webWorkers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "ww.sock")
+
+	// Leave a stale socket behind, as a crashed process would
+	ul, err := net.ListenUnix(networkUnix, &net.UnixAddr{Name: sock, Net: networkUnix})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ul.SetUnlinkOnClose(false)
+	ul.Close()
+
+	opts := Opts{
+		WorkerCap:      2,
+		QueueLen:       16,
+		Listeners:      []string{"tcp::11123", prefixUnix + sock},
+		UnixSocketMode: "0600",
+	}
+
+	ww, err := New(opts, func(res *Response, req *Request) {
+		res.Write([]byte("hello"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() { done <- ww.Listen() }()
+	time.Sleep(time.Millisecond * 100)
+
+	fi, err := os.Stat(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("invalid socket mode, received %v", fi.Mode().Perm())
+	}
+
+	for _, addr := range [][2]string{{networkTCP, "localhost:11123"}, {networkUnix, sock}} {
+		c, err := net.Dial(addr[0], addr[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		b, _ := ioutil.ReadAll(c)
+		c.Close()
+
+		if !strings.HasSuffix(string(b), "hello") {
+			t.Fatalf("invalid response over %s, received \"%s\"", addr[0], b)
+		}
+	}
+
+	// A second instance must not remove a socket which is still in use
+	if err = removeStaleSocket(sock); err != ErrSocketInUse {
+		t.Fatalf("expected ErrSocketInUse, received %v", err)
+	}
+
+	if err = ww.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Listen did not return once closed")
+	}
+
+	if _, err = os.Stat(sock); !os.IsNotExist(err) {
+		t.Fatalf("expected socket to be removed, received %v", err)
+	}
+
+	file := filepath.Join(dir, "file")
+	ioutil.WriteFile(file, nil, 0600)
+	if err = removeStaleSocket(file); err != ErrNotSocket {
+		t.Fatalf("expected ErrNotSocket, received %v", err)
+	}
+}
+
+func TestListenerValidation(t *testing.T) {
+	tests := map[string]Opts{
+		"empty":       {},
+		"unix":        {Listeners: []string{"unix:"}},
+		"tls":         {Listeners: []string{"tls::443"}},
+		"socket mode": {Address: ":80", UnixSocketMode: "0999"},
+	}
+
+	for name, o := range tests {
+		o.WorkerCap = 1
+		o.QueueLen = 1
+		if err := o.validate(); err == nil {
+			t.Errorf("expected an error for invalid %s", name)
+		}
+	}
+
+	if uid, gid, err := lookupOwner(":0"); err != nil || uid != -1 || gid != 0 {
+		t.Fatalf("invalid owner, received %d:%d (%v)", uid, gid, err)
+	}
+}
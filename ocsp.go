@@ -0,0 +1,186 @@
+package webWorkers
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// defaultOCSPRefresh is the default interval to refresh stapled OCSP responses
+	defaultOCSPRefresh = time.Hour
+)
+
+// OCSPFetcher is the func used to fetch a DER encoded OCSP response for a certificate
+type OCSPFetcher func(leaf, issuer *x509.Certificate) ([]byte, error)
+
+// hasOCSP will return whether or not any of the provided TLS pairs provide an OCSP source
+func hasOCSP(tps []TLSPair) bool {
+	for _, tp := range tps {
+		if tp.OCSP != "" || tp.OCSPFetcher != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// carryFetchers will carry OCSP fetchers over to re-read TLS pairs with matching certificate files
+// Note: Fetchers cannot be expressed within ini files, so they would otherwise be lost on reload
+func carryFetchers(tps, prev []TLSPair) []TLSPair {
+	for i := range tps {
+		for _, ptp := range prev {
+			if ptp.CRT == tps[i].CRT && tps[i].OCSPFetcher == nil {
+				tps[i].OCSPFetcher = ptp.OCSPFetcher
+			}
+		}
+	}
+
+	return tps
+}
+
+// issuer will return the issuer of a certificate, which must be the second certificate within its chain
+func issuer(crt *tls.Certificate) (*x509.Certificate, error) {
+	if len(crt.Certificate) < 2 {
+		return nil, ErrOCSPNoIssuer
+	}
+
+	return x509.ParseCertificate(crt.Certificate[1])
+}
+
+// fetchOCSP will return the OCSP response for a certificate from the source of its TLS pair
+func fetchOCSP(crt *tls.Certificate, tp TLSPair) (b []byte, err error) {
+	if tp.OCSPFetcher == nil {
+		return ioutil.ReadFile(tp.OCSP)
+	}
+
+	var iss *x509.Certificate
+	if iss, err = issuer(crt); err != nil {
+		return
+	}
+
+	return tp.OCSPFetcher(crt.Leaf, iss)
+}
+
+// validateOCSP will ensure an OCSP response is signed by the certificate's issuer, covers the certificate,
+// reports a good status, and is currently valid
+func validateOCSP(crt *tls.Certificate, b []byte) (err error) {
+	var (
+		iss  *x509.Certificate
+		resp *ocsp.Response
+	)
+
+	if iss, err = issuer(crt); err != nil {
+		return
+	}
+
+	if resp, err = ocsp.ParseResponseForCert(b, crt.Leaf, iss); err != nil {
+		return
+	}
+
+	if resp.Status != ocsp.Good {
+		return ErrOCSPNotGood
+	}
+
+	now := time.Now()
+	if resp.ThisUpdate.After(now) || (!resp.NextUpdate.IsZero() && now.After(resp.NextUpdate)) {
+		return ErrOCSPExpired
+	}
+
+	return
+}
+
+// staple will staple OCSP responses to the certificates of a store
+// Note: When a response cannot be fetched or is invalid, a still-valid staple for the same certificate within
+// the previous store is kept rather than stapling nothing
+func (ww *Webworkers) staple(cs, prev *certStore) {
+	for i, tp := range cs.tps {
+		if tp.OCSP == "" && tp.OCSPFetcher == nil {
+			continue
+		}
+
+		crt := &cs.crts[i]
+		b, err := fetchOCSP(crt, tp)
+		if err == nil {
+			err = validateOCSP(crt, b)
+		}
+
+		if err == nil {
+			crt.OCSPStaple = b
+			continue
+		}
+
+		ww.l.Printf("Error stapling OCSP response for %s: %v", tp.CRT, err)
+		crt.OCSPStaple = prev.staple(crt)
+	}
+}
+
+// staple will return the still-valid OCSP staple for a certificate, nil is returned if none exists
+func (cs *certStore) staple(crt *tls.Certificate) []byte {
+	if cs == nil {
+		return nil
+	}
+
+	for i := range cs.crts {
+		pcrt := &cs.crts[i]
+		if len(pcrt.OCSPStaple) == 0 || !bytes.Equal(pcrt.Certificate[0], crt.Certificate[0]) {
+			continue
+		}
+
+		if validateOCSP(pcrt, pcrt.OCSPStaple) == nil {
+			return pcrt.OCSPStaple
+		}
+	}
+
+	return nil
+}
+
+// RefreshOCSP will refresh the stapled OCSP responses of our current certificates
+func (ww *Webworkers) RefreshOCSP() (err error) {
+	if ww.tc == nil {
+		return ErrTLSDisabled
+	}
+
+	ww.cmux.Lock()
+	defer ww.cmux.Unlock()
+
+	prev := ww.certs.Load().(*certStore)
+	if !hasOCSP(prev.tps) {
+		// Nothing to refresh
+		return
+	}
+
+	// Copy our certificates so connections using the current store are unaffected
+	crts := make([]tls.Certificate, len(prev.crts))
+	copy(crts, prev.crts)
+
+	var cs *certStore
+	if cs, err = newCertStore(crts); err != nil {
+		return
+	}
+
+	cs.tps = prev.tps
+	ww.staple(cs, prev)
+	ww.certs.Store(cs)
+	return
+}
+
+// watchOCSP will refresh stapled OCSP responses on the provided interval until the instance is closed
+func (ww *Webworkers) watchOCSP(interval time.Duration) {
+	tkr := time.NewTicker(interval)
+	defer tkr.Stop()
+
+	for range tkr.C {
+		if ww.isClosed() {
+			return
+		}
+
+		if err := ww.RefreshOCSP(); err != nil {
+			ww.l.Println(err)
+		}
+	}
+}
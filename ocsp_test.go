@@ -0,0 +1,173 @@
+package webWorkers
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestOCSPStapling(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webWorkers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tp, leaf, ca, caKey := writeTestChain(t, dir)
+	good := newTestOCSP(t, leaf, ca, caKey, ocsp.Good)
+
+	var (
+		mux  sync.Mutex
+		resp = good
+	)
+
+	tp.OCSPFetcher = func(l, iss *x509.Certificate) ([]byte, error) {
+		mux.Lock()
+		defer mux.Unlock()
+		return resp, nil
+	}
+
+	ww, err := New(Opts{WorkerCap: 1, QueueLen: 16, Address: ":11119", TLS: true, Certs: []TLSPair{tp}}, func(res *Response, req *Request) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go ww.Listen()
+	time.Sleep(time.Millisecond * 100)
+
+	if staple := stapledOCSP(t); !bytes.Equal(staple, good) {
+		t.Fatalf("expected stapled OCSP response, received %d bytes", len(staple))
+	}
+
+	// A revoked response must never be stapled, our previous (still-valid) staple is kept instead
+	mux.Lock()
+	resp = newTestOCSP(t, leaf, ca, caKey, ocsp.Revoked)
+	mux.Unlock()
+
+	if err = ww.RefreshOCSP(); err != nil {
+		t.Fatal(err)
+	}
+
+	if staple := stapledOCSP(t); !bytes.Equal(staple, good) {
+		t.Fatalf("expected previous OCSP response, received %d bytes", len(staple))
+	}
+
+	if err = validateOCSP(&tls.Certificate{Certificate: [][]byte{leaf.Raw}, Leaf: leaf}, good); err != ErrOCSPNoIssuer {
+		t.Fatalf("expected ErrOCSPNoIssuer, received %v", err)
+	}
+}
+
+func stapledOCSP(t *testing.T) []byte {
+	c, err := tls.Dial("tcp", "localhost:11119", &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	return c.ConnectionState().OCSPResponse
+}
+
+// newTestOCSP will return an OCSP response for the provided leaf, signed by the provided CA
+func newTestOCSP(t *testing.T, leaf, ca *x509.Certificate, caKey crypto.Signer, status int) []byte {
+	tmpl := ocsp.Response{
+		Status:       status,
+		SerialNumber: leaf.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   time.Now().Add(time.Hour),
+	}
+
+	if status == ocsp.Revoked {
+		tmpl.RevokedAt = time.Now().Add(-time.Minute)
+	}
+
+	b, err := ocsp.CreateResponse(ca, ca, tmpl, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// writeTestChain will write a leaf certificate for localhost, followed by its issuing CA, to the provided directory
+func writeTestChain(t *testing.T, dir string) (tp TLSPair, leaf, ca *x509.Certificate, caKey *ecdsa.PrivateKey) {
+	var err error
+	if caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ca, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	if der, err = x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if leaf, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp.CRT = filepath.Join(dir, "chain.crt")
+	tp.Key = filepath.Join(dir, "chain.key")
+
+	chain := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})...)
+	if err = ioutil.WriteFile(tp.CRT, chain, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ioutil.WriteFile(tp.Key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
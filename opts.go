@@ -1,14 +1,29 @@
 package webWorkers
 
 import (
+	"crypto/tls"
 	"io"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/go-ini/ini"
 	"github.com/missionMeteora/toolkit/errors"
 )
 
+const (
+	// ClientAuthNone will not request a client certificate (default)
+	ClientAuthNone = ""
+	// ClientAuthRequest will request a client certificate, but will not require or verify it
+	ClientAuthRequest = "request"
+	// ClientAuthRequireAny will require a client certificate, but will not verify it
+	ClientAuthRequireAny = "requireAny"
+	// ClientAuthVerifyIfGiven will verify a client certificate against ClientCAs if one is provided
+	ClientAuthVerifyIfGiven = "verifyIfGiven"
+	// ClientAuthRequire will require a client certificate which is verified against ClientCAs
+	ClientAuthRequire = "require"
+)
+
 // NewOpts returns new options given a provided source
 // Please see the go-ini/ini docu (https://godoc.org/github.com/go-ini/ini#Load) for more information on the source argument
 func NewOpts(src interface{}) (o Opts, err error) {
@@ -23,13 +38,20 @@ func NewOpts(src interface{}) (o Opts, err error) {
 		return
 	}
 
+	// Retain our source so certificates may be reloaded
+	o.src = src
+
 	if !o.TLS {
 		// This is a non-TLS configuration, return early
 		return
 	}
 
 	// Load TLS pairs
-	err = o.loadTLSPairs(srcF)
+	if err = o.loadTLSPairs(srcF); err == ErrEmptyCerts && o.DevCerts {
+		// Development certificates will be generated in place of our certifications
+		err = nil
+	}
+
 	return
 }
 
@@ -42,13 +64,131 @@ type Opts struct {
 	// Address to be serving from
 	// TODO: Consider changing this to port, and making it a uint16
 	Address string `ini:"address"`
+	// Additional listener addresses, served by the same worker pool (see parseListener for the address format)
+	// IE: "tcp::80", "tls::443", "unix:/run/app.sock"
+	Listeners []string `ini:"listeners"`
+	// Address of a plaintext listener which redirects every request to HTTPS (IE: ":80"), requires TLS
+	RedirectAddress string `ini:"redirectAddress"`
+	// HTTPS port used within redirect locations, defaults to 443 (which is omitted from locations)
+	RedirectPort int `ini:"redirectPort"`
+	// Status code of redirects, either 301 or 308 (default), 308 preserves the request method and body
+	RedirectStatus int `ini:"redirectStatus"`
+	// List of path prefixes served by our handler on the redirect listener, rather than redirected (IE: "/.well-known/")
+	RedirectPassthrough []string `ini:"redirectPassthrough"`
+
+	// Max age of the Strict-Transport-Security header sent with TLS responses, zero disables HSTS
+	HSTSMaxAge time.Duration `ini:"hstsMaxAge"`
+	// Whether or not HSTS applies to subdomains
+	HSTSIncludeSubdomains bool `ini:"hstsIncludeSubdomains"`
+	// Whether or not HSTS preloading is requested (see https://hstspreload.org)
+	HSTSPreload bool `ini:"hstsPreload"`
+
+	// Mode of unix socket listeners (IE: "0660"), defaults to the process umask
+	UnixSocketMode string `ini:"unixSocketMode"`
+	// Owner of unix socket listeners (IE: "www-data:www-data", "1000:1000", or ":www-data")
+	UnixSocketOwner string `ini:"unixSocketOwner"`
 
 	// Whether or not TLS is enabled
 	TLS bool `ini:"tls"`
 	// List of TLS certifications (Only needed if TLS is set to true)
+	// Note: The first certification is the default for clients which do not match another certification using SNI
 	Certs []TLSPair
+	// Interval to check certification files for changes, zero disables automatic reloading (see Webworkers.ReloadCerts)
+	CertReloadInterval time.Duration `ini:"certReloadInterval"`
+
+	// List of client CA bundle files (PEM) used to verify client certificates (Only used if TLS is set to true)
+	ClientCAs []string `ini:"clientCAs"`
+	// Client certificate policy, see ClientAuth constants (Only used if TLS is set to true)
+	ClientAuth string `ini:"clientAuth"`
+
+	// Interval to refresh stapled OCSP responses, defaults to an hour when any certification provides an OCSP source
+	OCSPRefreshInterval time.Duration `ini:"ocspRefreshInterval"`
+
+	// Whether or not self-signed development certificates are generated when no certifications are provided
+	// Note: Never use development certificates in production
+	DevCerts bool `ini:"devCerts"`
+	// Hostnames and IPs for development certificates, defaults to localhost, 127.0.0.1 and ::1
+	DevHosts []string `ini:"devHosts"`
+	// Directory to write development certificates to (ca.crt, ca.key, dev.crt and dev.key), empty keeps them in-memory
+	// Note: An existing CA within the directory is reused, only the development certificate is re-issued
+	DevCertDir string `ini:"devCertDir"`
+
+	// Minimum TLS version (IE: "1.2"), defaults to the crypto/tls default (Only used if TLS is set to true)
+	TLSMinVersion string `ini:"tlsMinVersion"`
+	// Maximum TLS version (IE: "1.3"), defaults to the crypto/tls default (Only used if TLS is set to true)
+	TLSMaxVersion string `ini:"tlsMaxVersion"`
+	// Allowed TLS 1.0-1.2 cipher suites by name (IE: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"), in order of preference
+	TLSCiphers []string `ini:"tlsCiphers"`
+	// Elliptic curves by name ("X25519", "P256", "P384", "P521"), in order of preference
+	TLSCurves []string `ini:"tlsCurves"`
+	// Whether or not session ticket resumption is disabled
+	DisableSessionTickets bool `ini:"disableSessionTickets"`
+	// List of session ticket key files, the first key encrypts new tickets (see Webworkers.ReloadTicketKeys)
+	TicketKeys []string `ini:"ticketKeys"`
+	// Interval to reload session ticket key files, zero disables automatic reloading
+	TicketKeyReloadInterval time.Duration `ini:"ticketKeyReloadInterval"`
+	// List of ALPN protocols to advertise, defaults to "h2" and "http/1.1" when HTTP2 is set to true
+	ALPN []string `ini:"alpn"`
+
+	// Whether or not HTTP/2 will be negotiated via ALPN (Only used if TLS is set to true)
+	HTTP2 bool `ini:"http2"`
+	// Whether or not cleartext HTTP/2 (h2c) is accepted on plaintext connections, via prior knowledge or "Upgrade: h2c"
+	H2C bool `ini:"h2c"`
+	// Maximum number of concurrent streams per HTTP/2 connection
+	HTTP2MaxStreams uint32 `ini:"http2MaxStreams"`
+	// Duration an HTTP/2 connection may go without sending a frame before it is pinged, defaults to 2 minutes
+	// Note: Connections without open streams are closed once idle, as are connections which do not answer our ping in time
+	HTTP2IdleTimeout time.Duration `ini:"http2IdleTimeout"`
+
+	// Whether or not connections begin with a PROXY protocol (v1 or v2) header, as sent by TCP load balancers
+	ProxyProtocol bool `ini:"proxyProtocol"`
+	// List of CIDRs (or IPs) trusted to send PROXY protocol headers, required when ProxyProtocol is set to true
+	// Note: Connections from untrusted sources are served using their own address
+	ProxyTrusted []string `ini:"proxyTrusted"`
+
+	// List of CIDRs (or IPs) of proxies trusted to provide the client address and scheme via the Forwarded,
+	// X-Forwarded-For, X-Forwarded-Proto and X-Real-IP headers (see Request.ClientIP and Request.Scheme)
+	TrustedProxies []string `ini:"trustedProxies"`
+	// Whether or not unix socket peers (IE: a reverse proxy sidecar) are trusted proxies, as they do not have an IP
+	// Note: Only set this if every process able to connect to our unix sockets is a trusted proxy
+	TrustUnixPeers bool `ini:"trustUnixPeers"`
+
+	// Maximum number of concurrent connections per client IP, zero is unlimited
+	MaxConnsPerIP int `ini:"maxConnsPerIP"`
+	// List of CIDRs (or IPs) allowed to connect, an empty list allows every client which is not denied
+	AllowCIDRs []string `ini:"allowCIDRs"`
+	// List of CIDRs (or IPs) which are not allowed to connect, takes precedence over AllowCIDRs
+	DenyCIDRs []string `ini:"denyCIDRs"`
+	// Interval the configuration file is checked for access changes (MaxConnsPerIP, AllowCIDRs and DenyCIDRs), zero disables reloading
+	// Note: Only applies to Opts created using NewOpts
+	AccessReloadInterval time.Duration `ini:"accessReloadInterval"`
+
+	// Maximum duration to read an HTTP/1.x request line and headers, defaults to 10 seconds (slower requests receive 408)
+	ReadHeaderTimeout time.Duration `ini:"readHeaderTimeout"`
+	// Maximum size of an HTTP/1.x request line and headers in bytes, defaults to 64KB (larger requests receive 431)
+	// Note: HTTP/2 header lists are limited to 64KB by the HTTP/2 layer
+	MaxHeaderBytes int `ini:"maxHeaderBytes"`
+	// Maximum number of request headers, defaults to 100 (larger requests receive 431)
+	MaxHeaderCount int `ini:"maxHeaderCount"`
+	// Maximum length of a request URI, defaults to 8KB (longer URIs receive 414)
+	MaxURILength int `ini:"maxURILength"`
+	// Maximum size of a request body in bytes, zero is unlimited (larger bodies receive 413)
+	// Note: Bodies without a Content-Length are cut off once the limit is reached, see ErrBodyTooLarge
+	MaxBodyBytes int64 `ini:"maxBodyBytes"`
+
+	// List of request methods served by our handler (IE: "GET", "POST", "PROPFIND"), an empty list serves every method
+	// Note: Requests using other methods receive 501, methods are case-sensitive
+	AllowedMethods []string `ini:"allowedMethods"`
+
+	// Renders the body of error responses sent by our workers (IE: 400 for malformed requests), the returned
+	// content type and body are sent with the provided status code. Defaults to responding with an empty body
+	// Note: err describes why the request could not be served, it should not be rendered for clients as-is
+	ErrorHandler func(code int, err error) (contentType string, body []byte)
 
 	ErrorOutput io.Writer
+
+	// Source the options were loaded from (if loaded via NewOpts)
+	src interface{}
 }
 
 func (o *Opts) loadTLSPairs(srcF *ini.File) (err error) {
@@ -78,6 +218,8 @@ func (o *Opts) loadTLSPairs(srcF *ini.File) (err error) {
 
 		// Set the value for CRT
 		tp.CRT = ik.Value()
+		// Set the value for OCSP (optional)
+		tp.OCSP = sec.Key("ocsp").Value()
 		// Append TLSPair to o.Certs
 		o.Certs = append(o.Certs, tp)
 	}
@@ -90,6 +232,22 @@ func (o *Opts) loadTLSPairs(srcF *ini.File) (err error) {
 	return
 }
 
+// clientAuthType will return the tls.ClientAuthType matching our client auth policy
+func (o *Opts) clientAuthType() tls.ClientAuthType {
+	switch o.ClientAuth {
+	case ClientAuthRequest:
+		return tls.RequestClientCert
+	case ClientAuthRequireAny:
+		return tls.RequireAnyClientCert
+	case ClientAuthVerifyIfGiven:
+		return tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		return tls.RequireAndVerifyClientCert
+	}
+
+	return tls.NoClientCert
+}
+
 // validate will return any errors (if any) with the set of Opts
 func (o *Opts) validate() (err error) {
 	var errs errors.ErrorList
@@ -103,11 +261,130 @@ func (o *Opts) validate() (err error) {
 		errs.Append(ErrEmptyQueue)
 	}
 
-	if o.Address == "" {
-		// Address is empty, append ErrEmptyAddress
+	if o.Address == "" && len(o.Listeners) == 0 {
+		// Address and listeners are empty, append ErrEmptyAddress
 		errs.Append(ErrEmptyAddress)
 	}
 
+	if _, err = listenerSpecs(o); err != nil {
+		// Listeners are invalid, append the parsing error
+		errs.Append(err)
+	}
+
+	if o.RedirectStatus == 0 {
+		// Redirect status has not been set, set it to the default
+		o.RedirectStatus = StatusPermanentRedirect
+	}
+
+	if o.RedirectStatus != StatusMovedPerminantly && o.RedirectStatus != StatusPermanentRedirect {
+		// Redirect status is not a permanent redirect, append ErrInvalidRedirectStatus
+		errs.Append(ErrInvalidRedirectStatus)
+	}
+
+	if o.RedirectPort < 0 || o.RedirectPort > 65535 {
+		// Redirect port is out of range, append ErrInvalidRedirectPort
+		errs.Append(ErrInvalidRedirectPort)
+	}
+
+	if o.UnixSocketMode != "" {
+		if _, err = parseSocketMode(o.UnixSocketMode); err != nil {
+			// Unix socket mode is invalid, append the parsing error
+			errs.Append(err)
+		}
+	}
+
+	switch o.ClientAuth {
+	case ClientAuthNone, ClientAuthRequest, ClientAuthRequireAny:
+	case ClientAuthVerifyIfGiven, ClientAuthRequire:
+		if o.TLS && len(o.ClientCAs) == 0 {
+			// Client certificates cannot be verified without a CA, append ErrEmptyClientCAs
+			errs.Append(ErrEmptyClientCAs)
+		}
+	default:
+		// Client auth policy is not supported, append ErrInvalidClientAuth
+		errs.Append(ErrInvalidClientAuth)
+	}
+
+	if o.TLS {
+		validateTLSPolicy(o, &errs)
+	}
+
+	if o.OCSPRefreshInterval == 0 && hasOCSP(o.Certs) {
+		// OCSP refresh interval has not been set, set it to the default
+		o.OCSPRefreshInterval = defaultOCSPRefresh
+	}
+
+	if o.DevCerts && len(o.DevHosts) == 0 {
+		// Development hosts have not been set, set them to the default
+		o.DevHosts = defaultDevHosts
+	}
+
+	if o.ProxyProtocol && len(o.ProxyTrusted) == 0 {
+		// Any client could spoof its address without trusted sources, append ErrEmptyProxyTrusted
+		errs.Append(ErrEmptyProxyTrusted)
+	}
+
+	if _, err = parseCIDRs(o.ProxyTrusted); err != nil {
+		// Trusted PROXY protocol sources are invalid, append the parsing error
+		errs.Append(err)
+	}
+
+	if _, err = parseCIDRs(o.TrustedProxies); err != nil {
+		// Trusted proxies are invalid, append the parsing error
+		errs.Append(err)
+	}
+
+	if _, err = newAccessList(o); err != nil {
+		// Access lists are invalid, append the parsing error
+		errs.Append(err)
+	}
+
+	for _, m := range o.AllowedMethods {
+		if !isToken(m) {
+			// Allowed method is not a valid token, append ErrInvalidAllowedMethod
+			errs.Append(ErrInvalidAllowedMethod)
+			break
+		}
+	}
+
+	if o.ReadHeaderTimeout < 0 || o.MaxHeaderBytes < 0 || o.MaxHeaderCount < 0 || o.MaxURILength < 0 || o.MaxBodyBytes < 0 {
+		// Request limits are negative, append ErrInvalidRequestLimit
+		errs.Append(ErrInvalidRequestLimit)
+	}
+
+	if o.ReadHeaderTimeout == 0 {
+		// Read header timeout has not been set, set it to the default
+		o.ReadHeaderTimeout = defaultReadHeaderTimeout
+	}
+
+	if o.MaxHeaderBytes == 0 {
+		// Max header bytes has not been set, set it to the default
+		o.MaxHeaderBytes = defaultMaxHeaderBytes
+	}
+
+	if o.MaxHeaderCount == 0 {
+		// Max header count has not been set, set it to the default
+		o.MaxHeaderCount = defaultMaxHeaderCount
+	}
+
+	if o.MaxURILength == 0 {
+		// Max URI length has not been set, set it to the default
+		o.MaxURILength = defaultMaxURILength
+	}
+
+	if o.HTTP2MaxStreams == 0 {
+		// HTTP/2 max streams has not been set, set it to the default
+		o.HTTP2MaxStreams = defaultH2MaxStreams
+	}
+
+	if o.HTTP2IdleTimeout < 0 {
+		// HTTP/2 idle timeout is negative, append ErrInvalidHTTP2IdleTimeout
+		errs.Append(ErrInvalidHTTP2IdleTimeout)
+	} else if o.HTTP2IdleTimeout == 0 {
+		// HTTP/2 idle timeout has not been set, set it to the default
+		o.HTTP2IdleTimeout = defaultH2IdleTimeout
+	}
+
 	if o.ErrorOutput == nil {
 		// ErrorOutput has not been set, set it to os.Stderr
 		o.ErrorOutput = os.Stderr
@@ -0,0 +1,282 @@
+package webWorkers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// proxyHeaderTimeout is how long a trusted source has to send its PROXY protocol header
+	proxyHeaderTimeout = time.Second * 5
+	// proxyV1MaxLen is the maximum length of a v1 (text) header, including the trailing CRLF
+	proxyV1MaxLen = 107
+	// proxyBufSize is the size of the read buffer of a PROXY protocol connection
+	proxyBufSize = 512
+)
+
+var (
+	proxyV1Sig = []byte("PROXY ")
+	proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// newProxyListener will return a listener which parses PROXY protocol headers from trusted sources
+// Note: Headers are parsed lazily on first use, so slow clients do not block our accept loop
+func newProxyListener(lst net.Listener, trusted []*net.IPNet) *proxyListener {
+	return &proxyListener{
+		Listener: lst,
+		trusted:  trusted,
+	}
+}
+
+// proxyListener is a listener which accepts PROXY protocol (v1 and v2) connections
+type proxyListener struct {
+	net.Listener
+	trusted []*net.IPNet
+}
+
+// Accept will accept the next connection
+func (p *proxyListener) Accept() (c net.Conn, err error) {
+	if c, err = p.Listener.Accept(); err != nil {
+		return
+	}
+
+	if !isTrusted(c.RemoteAddr(), p.trusted) {
+		// Untrusted sources are served as-is, any header they send will fail to parse as HTTP
+		return
+	}
+
+	return &proxyConn{
+		Conn: c,
+		r:    bufio.NewReaderSize(c, proxyBufSize),
+	}, nil
+}
+
+// proxyConn is a connection which begins with a PROXY protocol header
+type proxyConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	once   sync.Once
+	err    error
+	remote net.Addr
+	local  net.Addr
+}
+
+// init will parse the PROXY protocol header, once
+func (p *proxyConn) init() {
+	p.once.Do(func() {
+		p.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		p.remote, p.local, p.err = readProxyHeader(p.r)
+		p.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+// Read will read from the connection, following the PROXY protocol header
+func (p *proxyConn) Read(b []byte) (n int, err error) {
+	if p.init(); p.err != nil {
+		return 0, p.err
+	}
+
+	return p.r.Read(b)
+}
+
+// RemoteAddr will return the client address as provided by the proxy
+func (p *proxyConn) RemoteAddr() net.Addr {
+	if p.init(); p.remote != nil {
+		return p.remote
+	}
+
+	return p.Conn.RemoteAddr()
+}
+
+// LocalAddr will return the destination address as provided by the proxy
+func (p *proxyConn) LocalAddr() net.Addr {
+	if p.init(); p.local != nil {
+		return p.local
+	}
+
+	return p.Conn.LocalAddr()
+}
+
+// readProxyHeader will read a v1 or v2 PROXY protocol header
+// Note: Nil addresses are returned for LOCAL (v2) and UNKNOWN (v1) headers, in which case the connection's own addresses apply
+func readProxyHeader(r *bufio.Reader) (remote, local net.Addr, err error) {
+	var sig []byte
+	if sig, err = r.Peek(len(proxyV1Sig)); err != nil {
+		return
+	}
+
+	if bytes.Equal(sig, proxyV1Sig) {
+		return readProxyV1(r)
+	}
+
+	if sig, err = r.Peek(len(proxyV2Sig)); err != nil {
+		return
+	}
+
+	if bytes.Equal(sig, proxyV2Sig) {
+		return readProxyV2(r)
+	}
+
+	// Trusted sources must always send a header
+	err = ErrInvalidProxyHeader
+	return
+}
+
+// readProxyV1 will read a v1 (text) header (IE: "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n")
+func readProxyV1(r *bufio.Reader) (remote, local net.Addr, err error) {
+	var line []byte
+	for len(line) < proxyV1MaxLen {
+		var b byte
+		if b, err = r.ReadByte(); err != nil {
+			return
+		}
+
+		if line = append(line, b); b == '\n' {
+			break
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		err = ErrInvalidProxyHeader
+		return
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		// Proxy could not determine the client, the remainder of the line is ignored
+		return
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		err = ErrInvalidProxyHeader
+		return
+	}
+
+	if remote, err = parseProxyAddr(fields[2], fields[4]); err != nil {
+		return
+	}
+
+	local, err = parseProxyAddr(fields[3], fields[5])
+	return
+}
+
+// parseProxyAddr will parse a v1 address and port
+func parseProxyAddr(host, port string) (addr *net.TCPAddr, err error) {
+	ip := net.ParseIP(host)
+	p, perr := strconv.ParseUint(port, 10, 16)
+	if ip == nil || perr != nil {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// readProxyV2 will read a v2 (binary) header
+func readProxyV2(r *bufio.Reader) (remote, local net.Addr, err error) {
+	var hdr [16]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return
+	}
+
+	if hdr[12]>>4 != 2 {
+		// Unsupported version
+		err = ErrInvalidProxyHeader
+		return
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err = io.ReadFull(r, body); err != nil {
+		return
+	}
+
+	switch hdr[12] & 0x0F {
+	case 0x0:
+		// LOCAL command, the connection was established by the proxy itself (IE: health checks)
+		return
+	case 0x1:
+		// PROXY command
+	default:
+		err = ErrInvalidProxyHeader
+		return
+	}
+
+	// Any TLVs following our addresses are ignored
+	switch hdr[13] >> 4 {
+	case 0x1:
+		// AF_INET
+		if len(body) < 12 {
+			err = ErrInvalidProxyHeader
+			return
+		}
+
+		remote = &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:]))}
+		local = &net.TCPAddr{IP: net.IP(body[4:8]), Port: int(binary.BigEndian.Uint16(body[10:]))}
+	case 0x2:
+		// AF_INET6
+		if len(body) < 36 {
+			err = ErrInvalidProxyHeader
+			return
+		}
+
+		remote = &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:]))}
+		local = &net.TCPAddr{IP: net.IP(body[16:32]), Port: int(binary.BigEndian.Uint16(body[34:]))}
+	}
+
+	// AF_UNSPEC and AF_UNIX use the connection's own addresses
+	return
+}
+
+// parseCIDRs will parse a list of CIDRs, single IPs are treated as a /32 (or /128 for IPv6)
+func parseCIDRs(strs []string) (nets []*net.IPNet, err error) {
+	for _, str := range strs {
+		var n *net.IPNet
+		if strings.IndexByte(str, '/') == -1 {
+			ip := net.ParseIP(str)
+			if ip == nil {
+				return nil, ErrInvalidCIDR
+			}
+
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+
+			n = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		} else if _, n, err = net.ParseCIDR(str); err != nil {
+			return nil, ErrInvalidCIDR
+		}
+
+		nets = append(nets, n)
+	}
+
+	return
+}
+
+// isTrusted will return whether or not an address belongs to the provided networks
+// Note: An empty list of networks trusts no address
+func isTrusted(addr net.Addr, nets []*net.IPNet) bool {
+	ip := addrIP(addr)
+	return ip != nil && isTrustedIP(ip, nets)
+}
+
+// addrIP will return the IP of an address, nil is returned for non-IP addresses
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package webWorkers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxyProtocol(t *testing.T) {
+	startProxy(t, ":11120", "127.0.0.1")
+	startProxy(t, ":11121", "10.0.0.0/8")
+
+	v2 := append([]byte{}, proxyV2Sig...)
+	v2 = append(v2, 0x21, 0x11, 0, 12)
+	v2 = append(v2, 198, 51, 100, 7, 127, 0, 0, 1, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(v2[len(v2)-4:], 41000)
+	binary.BigEndian.PutUint16(v2[len(v2)-2:], 11120)
+
+	tests := []struct {
+		addr     string
+		header   string
+		expected string
+	}{
+		{"localhost:11120", "PROXY TCP4 192.0.2.1 127.0.0.1 56324 11120\r\n", "192.0.2.1:56324"},
+		{"localhost:11120", "PROXY TCP6 2001:db8::1 ::1 56324 11120\r\n", "[2001:db8::1]:56324"},
+		{"localhost:11120", string(v2), "198.51.100.7:41000"},
+		{"localhost:11120", "PROXY UNKNOWN\r\n", "127.0.0.1:"},
+		// Trusted sources must send a header
+		{"localhost:11120", "", ""},
+		// Untrusted sources are served using their own address
+		{"localhost:11121", "", "127.0.0.1:"},
+	}
+
+	for _, tc := range tests {
+		if body := proxyRequest(t, tc.addr, tc.header); !strings.Contains(body, tc.expected) || (tc.expected == "" && body != "") {
+			t.Errorf("invalid remote address for \"%q\", expected %s and received \"%s\"", tc.header, tc.expected, body)
+		}
+	}
+}
+
+func TestProxyV2Local(t *testing.T) {
+	hdr := append(append([]byte{}, proxyV2Sig...), 0x20, 0x00, 0, 0)
+	remote, local, err := readProxyHeader(bufio.NewReader(bytes.NewReader(hdr)))
+	if err != nil || remote != nil || local != nil {
+		t.Fatalf("invalid LOCAL header result: %v, %v, %v", remote, local, err)
+	}
+}
+
+func TestProxyTrusted(t *testing.T) {
+	o := Opts{WorkerCap: 1, QueueLen: 16, Address: ":11133", ProxyProtocol: true}
+	if err := o.validate(); err == nil || !strings.Contains(err.Error(), ErrEmptyProxyTrusted.Error()) {
+		t.Fatalf("expected %v and received %v", ErrEmptyProxyTrusted, err)
+	}
+
+	if isTrusted(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil) {
+		t.Fatal("expected no address to be trusted by an empty list")
+	}
+}
+
+func startProxy(t *testing.T, addr, trusted string) {
+	ww, err := New(Opts{WorkerCap: 1, QueueLen: 16, Address: addr, ProxyProtocol: true, ProxyTrusted: []string{trusted}}, func(res *Response, req *Request) {
+		res.Write([]byte("\n" + req.RemoteAddr()))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go ww.Listen()
+	time.Sleep(time.Millisecond * 100)
+}
+
+func proxyRequest(t *testing.T, addr, header string) string {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Write([]byte(header + "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	b, _ := ioutil.ReadAll(c)
+	return string(b)
+}
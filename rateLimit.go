@@ -0,0 +1,271 @@
+package webWorkers
+
+import (
+	"hash/fnv"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitAlgorithm represents how a RateLimiter counts requests
+type RateLimitAlgorithm uint8
+
+const (
+	// TokenBucket allows bursts of up to Limit requests, refilling at Limit requests per Window
+	TokenBucket RateLimitAlgorithm = iota
+	// SlidingWindow allows Limit requests within any Window, approximated from the counts of the current and previous windows
+	SlidingWindow
+)
+
+const (
+	// rateLimitShards is the number of shards our state is split into, reducing lock contention between workers
+	rateLimitShards = 32
+	// rateLimitNoIPKey is the key shared by clients and connections without an IP (IE: unix socket peers)
+	rateLimitNoIPKey = "unix"
+)
+
+// KeyFunc returns the key a request is rate limited by, an empty key is not rate limited
+type KeyFunc func(*Request) string
+
+// RateLimitByIP will rate limit requests by their client IP, see Request.ClientIP
+// Note: Clients without an IP (IE: untrusted unix socket peers) share a single bucket, keyed by "unix"
+func RateLimitByIP(req *Request) string {
+	if ip := req.ClientIP(); ip != "" {
+		return ip
+	}
+
+	return rateLimitNoIPKey
+}
+
+// RateLimitByHeader will return a KeyFunc which rate limits requests by a header (IE: "X-API-Key")
+// valid reports whether or not a header value is a known key (IE: an issued API key)
+// Note: Requests without the header, or with an unknown value, are rate limited by their client IP. Otherwise
+// clients could bypass the limit by sending a new value with each request, growing our state with every one
+func RateLimitByHeader(key string, valid func(string) bool) KeyFunc {
+	return func(req *Request) string {
+		if val := req.Header(key); val != "" && valid != nil && valid(val) {
+			return "header:" + val
+		}
+
+		return RateLimitByIP(req)
+	}
+}
+
+// NewRateLimiter will return a new RateLimiter using the provided options
+func NewRateLimiter(o RateLimitOpts) (rl *RateLimiter, err error) {
+	if o.Algorithm != TokenBucket && o.Algorithm != SlidingWindow {
+		return nil, ErrInvalidRateLimit
+	}
+
+	if o.Limit <= 0 || o.Window <= 0 {
+		return nil, ErrInvalidRateLimit
+	}
+
+	if o.Key == nil {
+		o.Key = RateLimitByIP
+	}
+
+	rl = &RateLimiter{o: o}
+	for i := range rl.shards {
+		rl.shards[i].m = make(map[string]*rateEntry)
+	}
+
+	return
+}
+
+// RateLimitOpts are the options for a RateLimiter
+type RateLimitOpts struct {
+	// Counting algorithm, defaults to TokenBucket
+	Algorithm RateLimitAlgorithm
+	// Number of requests allowed per Window
+	Limit int
+	// Duration Limit applies to
+	Window time.Duration
+
+	// Key requests are rate limited by, defaults to RateLimitByIP
+	// Note: Connections limited via Webworkers.LimitConns are always keyed by IP
+	Key KeyFunc
+	// Handler for rejected requests, defaults to responding with 429 Too Many Requests
+	ErrorHandler Handler
+}
+
+// RateLimiter limits the rate of requests (or connections) per key
+type RateLimiter struct {
+	o      RateLimitOpts
+	shards [rateLimitShards]rateShard
+}
+
+// rateShard is a shard of rate limiting state
+type rateShard struct {
+	mux sync.Mutex
+	m   map[string]*rateEntry
+	// Last time idle keys were removed
+	swept time.Time
+}
+
+// rateEntry is the state of a single key
+type rateEntry struct {
+	// Token bucket state
+	tokens float64
+	// Sliding window state
+	start time.Time
+	cur   int
+	prev  int
+
+	// Last time the key was used
+	last time.Time
+}
+
+// RateLimitResult is the outcome of a rate limiting decision
+type RateLimitResult struct {
+	Allowed bool
+	Limit   int
+	// Requests remaining before the limit is reached
+	Remaining int
+	// Time until the limit has fully reset
+	Reset time.Duration
+	// Time until a request will be allowed, zero if allowed
+	RetryAfter time.Duration
+}
+
+// Allow will count a request for the provided key, returning whether or not it is allowed
+func (rl *RateLimiter) Allow(key string) (res RateLimitResult) {
+	now := time.Now()
+	s := rl.shard(key)
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if now.Sub(s.swept) > rl.o.Window {
+		// State of keys idle for longer than our window has fully reset, remove them
+		for k, e := range s.m {
+			if now.Sub(e.last) > rl.o.Window {
+				delete(s.m, k)
+			}
+		}
+
+		s.swept = now
+	}
+
+	e, ok := s.m[key]
+	if !ok {
+		e = &rateEntry{tokens: float64(rl.o.Limit), start: now}
+		s.m[key] = e
+	}
+
+	if rl.o.Algorithm == SlidingWindow {
+		res = rl.slidingWindow(e, now)
+	} else {
+		res = rl.tokenBucket(e, now)
+	}
+
+	e.last = now
+	res.Limit = rl.o.Limit
+	return
+}
+
+// shard will return the shard holding the state of the provided key
+func (rl *RateLimiter) shard(key string) *rateShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &rl.shards[h.Sum32()%rateLimitShards]
+}
+
+// tokenBucket will count a request using a token bucket
+func (rl *RateLimiter) tokenBucket(e *rateEntry, now time.Time) (res RateLimitResult) {
+	limit := float64(rl.o.Limit)
+	// Nanoseconds taken to refill a single token
+	per := float64(rl.o.Window) / limit
+
+	if !e.last.IsZero() {
+		e.tokens = math.Min(limit, e.tokens+float64(now.Sub(e.last))/per)
+	}
+
+	if res.Allowed = e.tokens >= 1; res.Allowed {
+		e.tokens--
+	} else {
+		res.RetryAfter = time.Duration((1 - e.tokens) * per)
+	}
+
+	res.Remaining = int(e.tokens)
+	res.Reset = time.Duration((limit - e.tokens) * per)
+	return
+}
+
+// slidingWindow will count a request using a sliding window
+func (rl *RateLimiter) slidingWindow(e *rateEntry, now time.Time) (res RateLimitResult) {
+	if elapsed := now.Sub(e.start); elapsed >= rl.o.Window*2 {
+		// Both windows have passed
+		e.start, e.cur, e.prev = now, 0, 0
+	} else if elapsed >= rl.o.Window {
+		e.start, e.cur, e.prev = e.start.Add(rl.o.Window), 0, e.cur
+	}
+
+	// The previous window is weighted by how much of it still overlaps our sliding window
+	elapsed := now.Sub(e.start)
+	weight := 1 - float64(elapsed)/float64(rl.o.Window)
+	count := int(math.Ceil(float64(e.prev)*weight)) + e.cur
+
+	if res.Allowed = count < rl.o.Limit; res.Allowed {
+		e.cur++
+		count++
+	} else {
+		res.RetryAfter = rl.o.Window - elapsed
+	}
+
+	if res.Remaining = rl.o.Limit - count; res.Remaining < 0 {
+		res.Remaining = 0
+	}
+
+	res.Reset = rl.o.Window - elapsed
+	return
+}
+
+// Wrap will return a Handler which rejects requests exceeding our rate limit
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers are sent with every response, Retry-After is sent when rejected
+func (rl *RateLimiter) Wrap(fn Handler) Handler {
+	return func(res *Response, req *Request) {
+		key := rl.o.Key(req)
+		if key == "" {
+			fn(res, req)
+			return
+		}
+
+		rr := rl.Allow(key)
+		res.Header("RateLimit-Limit", strconv.Itoa(rr.Limit))
+		res.Header("RateLimit-Remaining", strconv.Itoa(rr.Remaining))
+		res.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(rr.Reset)))
+
+		if rr.Allowed {
+			fn(res, req)
+			return
+		}
+
+		res.Header("Retry-After", strconv.Itoa(ceilSeconds(rr.RetryAfter)))
+		if rl.o.ErrorHandler != nil {
+			rl.o.ErrorHandler(res, req)
+			return
+		}
+
+		res.StatusCode(StatusTooManyRequests)
+		res.Write(nil)
+	}
+}
+
+// allowConn will return whether or not a connection is within our rate limit, keyed by its IP
+// Note: Connections without an IP (IE: unix socket connections) share a single bucket, keyed by "unix"
+func (rl *RateLimiter) allowConn(c net.Conn) bool {
+	ip := addrIP(c.RemoteAddr())
+	if ip == nil {
+		return rl.Allow(rateLimitNoIPKey).Allowed
+	}
+
+	return rl.Allow(ip.String()).Allowed
+}
+
+// ceilSeconds will return a duration in whole seconds, rounded up
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
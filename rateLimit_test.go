@@ -0,0 +1,163 @@
+package webWorkers
+
+import (
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	for _, alg := range []RateLimitAlgorithm{TokenBucket, SlidingWindow} {
+		rl, err := NewRateLimiter(RateLimitOpts{Algorithm: alg, Limit: 3, Window: time.Millisecond * 300})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 3; i++ {
+			if rr := rl.Allow("a"); !rr.Allowed || rr.Remaining != 2-i {
+				t.Fatalf("%d: expected request %d to be allowed, received %+v", alg, i, rr)
+			}
+		}
+
+		if rr := rl.Allow("a"); rr.Allowed || rr.RetryAfter <= 0 || rr.Remaining != 0 {
+			t.Fatalf("%d: expected request to be rejected, received %+v", alg, rr)
+		}
+
+		// Keys are limited independently, use a key sharing our shard so its removal can be tested
+		other := "b"
+		for i := 0; rl.shard(other) != rl.shard("a"); i++ {
+			other = "b" + strconv.Itoa(i)
+		}
+
+		if !rl.Allow(other).Allowed {
+			t.Fatalf("%d: expected another key to be allowed", alg)
+		}
+
+		time.Sleep(time.Millisecond * 650)
+		if !rl.Allow("a").Allowed {
+			t.Fatalf("%d: expected request to be allowed once our window has passed", alg)
+		}
+
+		// Idle keys are removed
+		if _, ok := rl.shard("a").m[other]; ok {
+			t.Fatalf("%d: expected idle key to be removed", alg)
+		}
+	}
+
+	if _, err := NewRateLimiter(RateLimitOpts{Limit: 1}); err != ErrInvalidRateLimit {
+		t.Fatalf("expected ErrInvalidRateLimit and received %v", err)
+	}
+}
+
+func TestRateLimiterWrap(t *testing.T) {
+	var rejected bool
+	rl, err := NewRateLimiter(RateLimitOpts{
+		Limit:  1,
+		Window: time.Minute,
+		Key: RateLimitByHeader("X-API-Key", func(val string) bool {
+			return val == "a" || val == "b"
+		}),
+		ErrorHandler: func(res *Response, req *Request) {
+			rejected = true
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fn := rl.Wrap(func(res *Response, req *Request) {})
+	for i, key := range []string{"a", "b", "a"} {
+		res, _ := serveCSRF(t, fn, "GET / HTTP/1.1\r\nHost: site.com\r\nx-api-key: "+key+"\r\n", "")
+		if expected := i == 2; rejected != expected {
+			t.Fatalf("%d: expected rejected to be %v", i, expected)
+		}
+
+		var retry bool
+		for _, h := range res.headers {
+			retry = retry || h.key == "Retry-After" && h.val == "60"
+		}
+
+		if len(res.headers) < 3 || res.headers[0].key != "RateLimit-Limit" || retry != rejected {
+			t.Fatalf("%d: invalid headers, received %v", i, res.headers)
+		}
+	}
+}
+
+func TestRateLimitByHeader(t *testing.T) {
+	fn := RateLimitByHeader("X-API-Key", func(val string) bool { return val == "known" })
+	for val, expected := range map[string]string{"known": "header:known", "random": "192.0.2.1", "": "192.0.2.1"} {
+		req := &Request{remoteAddr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1)}}
+		if val != "" {
+			req.setHeader([]byte("X-API-Key"), []byte(val))
+		}
+
+		if key := fn(req); key != expected {
+			t.Fatalf("expected %q to be limited by %q and received %q", val, expected, key)
+		}
+	}
+}
+
+func TestRateLimitByIPUnix(t *testing.T) {
+	req := &Request{remoteAddr: &net.UnixAddr{Net: "unix"}}
+	req.setHeader([]byte("X-Forwarded-For"), []byte("198.51.100.1"))
+	if key := RateLimitByIP(req); key != rateLimitNoIPKey {
+		t.Fatalf("expected untrusted unix peers to share %q, received %q", rateLimitNoIPKey, key)
+	}
+
+	// Trusted unix peers are limited by the client they forward
+	req.trustUnix = true
+	if key := RateLimitByIP(req); key != "198.51.100.1" {
+		t.Fatalf("expected trusted unix peers to be limited by their client, received %q", key)
+	}
+
+	rl, err := NewRateLimiter(RateLimitOpts{Limit: 1, Window: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pipes do not have an IP, as with unix sockets
+	c, peer := net.Pipe()
+	defer c.Close()
+	defer peer.Close()
+
+	if !rl.allowConn(c) || rl.allowConn(c) {
+		t.Fatal("expected connections without an IP to share a single limit")
+	}
+}
+
+func TestLimitConns(t *testing.T) {
+	ww, err := New(Opts{WorkerCap: 1, QueueLen: 4, Address: ":11126"}, func(res *Response, req *Request) {
+		res.Write([]byte("hello"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ww.Close()
+
+	rl, err := NewRateLimiter(RateLimitOpts{Limit: 2, Window: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ww.LimitConns(rl)
+	go ww.Listen()
+	time.Sleep(time.Millisecond * 100)
+
+	for i := 0; i < 3; i++ {
+		c, err := net.Dial("tcp", "localhost:11126")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		b, _ := ioutil.ReadAll(c)
+		c.Close()
+
+		if served := strings.HasSuffix(string(b), "hello"); served != (i < 2) {
+			t.Fatalf("%d: invalid response, received \"%s\"", i, b)
+		}
+	}
+}
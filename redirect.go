@@ -0,0 +1,86 @@
+package webWorkers
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultHTTPSPort is the port omitted from redirect locations
+	defaultHTTPSPort = 443
+)
+
+// redirectListener is a plaintext listener whose requests are redirected to HTTPS
+type redirectListener struct {
+	net.Listener
+}
+
+// Accept will accept the next connection, marking it for redirection
+func (r *redirectListener) Accept() (c net.Conn, err error) {
+	if c, err = r.Listener.Accept(); err != nil {
+		return
+	}
+
+	return &redirectConn{c}, nil
+}
+
+// redirectConn is a connection whose requests are redirected to HTTPS
+type redirectConn struct {
+	net.Conn
+}
+
+// isRedirect will return whether or not a connection's requests are redirected to HTTPS
+func isRedirect(c net.Conn) bool {
+	_, ok := c.(*redirectConn)
+	return ok
+}
+
+// shouldRedirect will return whether or not a request should be redirected, rather than served by our handler
+// Note: Paths containing dot segments are always redirected, so "/.well-known/../admin" cannot be served over plaintext
+func (w *worker) shouldRedirect(req *Request) bool {
+	// Passthrough paths are served as-is (IE: ACME challenges)
+	return !hasPathPrefix(req.path, w.o.RedirectPassthrough)
+}
+
+// redirect will respond with a redirect to the HTTPS equivalent of a request
+func (w *worker) redirect(res *Response, req *Request) {
+	name := req.Hostname()
+	if name == "" {
+		// We cannot build a location without a host
+		w.respondError(res, ErrInvalidHost)
+		return
+	}
+
+	if strings.IndexByte(name, ':') > -1 {
+		// IPv6 literal
+		name = "[" + name + "]"
+	}
+
+	loc := "https://" + name
+	if port := w.o.RedirectPort; port != 0 && port != defaultHTTPSPort {
+		loc += ":" + strconv.Itoa(port)
+	}
+
+	res.Header("Location", loc+string(req.path))
+	w.respond(res, w.o.RedirectStatus)
+}
+
+// hstsHeader will return the Strict-Transport-Security header value of a set of Opts, empty if HSTS is disabled
+func hstsHeader(o *Opts) (val string) {
+	if o.HSTSMaxAge <= 0 {
+		return
+	}
+
+	val = "max-age=" + strconv.FormatInt(int64(o.HSTSMaxAge/time.Second), 10)
+	if o.HSTSIncludeSubdomains {
+		val += "; includeSubDomains"
+	}
+
+	if o.HSTSPreload {
+		val += "; preload"
+	}
+
+	return
+}
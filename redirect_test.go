@@ -0,0 +1,142 @@
+package webWorkers
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedirect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webWorkers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := Opts{
+		WorkerCap:           2,
+		QueueLen:            16,
+		Address:             ":11124",
+		TLS:                 true,
+		Certs:               []TLSPair{writeTestCert(t, dir, "localhost")},
+		RedirectAddress:     ":11125",
+		RedirectPort:        11124,
+		RedirectPassthrough: []string{"/.well-known/"},
+		HSTSMaxAge:          time.Hour,
+	}
+
+	ww, err := New(opts, func(res *Response, req *Request) {
+		res.Write([]byte("hello"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ww.Close()
+
+	go ww.Listen()
+	time.Sleep(time.Millisecond * 100)
+
+	redirectRequest := func(req string) string {
+		c, err := net.Dial("tcp", "localhost:11125")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		c.Write([]byte(req))
+		b, _ := ioutil.ReadAll(c)
+		return string(b)
+	}
+
+	res := redirectRequest("GET /path?q HTTP/1.1\r\nHost: Localhost:11125\r\n\r\n")
+	if !strings.HasPrefix(res, "HTTP/1.1 308 Permanent Redirect\r\n") || !strings.Contains(res, "\r\nLocation: https://localhost:11124/path?q\r\n") {
+		t.Fatalf("invalid redirect, received \"%s\"", res)
+	}
+
+	if strings.Contains(res, "Strict-Transport-Security") {
+		t.Fatalf("HSTS header sent over plaintext, received \"%s\"", res)
+	}
+
+	if res = redirectRequest("GET /.well-known/acme HTTP/1.1\r\nHost: localhost\r\n\r\n"); !strings.HasSuffix(res, "hello") {
+		t.Fatalf("invalid passthrough response, received \"%s\"", res)
+	}
+
+	for _, path := range []string{"/.well-known/../admin", "/.well-known/%2e%2e/admin", "/.well-known/%2E%2E%2Fadmin", "/.well-known/..\\admin"} {
+		// Dot segments must not escape our passthrough prefix
+		if res = redirectRequest("GET " + path + " HTTP/1.1\r\nHost: localhost\r\n\r\n"); !strings.HasPrefix(res, "HTTP/1.1 308 Permanent Redirect\r\n") {
+			t.Fatalf("expected %s to be redirected, received \"%s\"", path, res)
+		}
+	}
+
+	if res = redirectRequest("GET / HTTP/1.1\r\n\r\n"); !strings.HasPrefix(res, "HTTP/1.1 400 Bad Request\r\n") {
+		t.Fatalf("invalid response without a host, received \"%s\"", res)
+	}
+
+	c, err := tls.Dial("tcp", "localhost:11124", &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	b, _ := ioutil.ReadAll(c)
+	if !strings.Contains(string(b), "Strict-Transport-Security: max-age=3600") || !strings.HasSuffix(string(b), "hello") {
+		t.Fatalf("invalid TLS response, received \"%s\"", b)
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"/.well-known/acme?a=b", "/.well-known/acme", true},
+		{"//a/./b/", "", false},
+		{"//a//b/", "/a/b/", true},
+		{"/a%2Fb", "/a/b", true},
+		{"/a/../b", "", false},
+		{"/a/%2e%2e/b", "", false},
+		{"/a/%2E%2E", "", false},
+		{"/a/..%5Cb", "", false},
+		{"/a%zz", "", false},
+	}
+
+	for _, tt := range tests {
+		if p, ok := cleanPath([]byte(tt.in)); p != tt.want || ok != tt.ok {
+			t.Errorf("cleanPath(%q): expected %q (%v) and received %q (%v)", tt.in, tt.want, tt.ok, p, ok)
+		}
+	}
+}
+
+func TestRedirectValidation(t *testing.T) {
+	tests := map[string]Opts{
+		"tls":    {Address: ":80", RedirectAddress: ":81"},
+		"status": {Address: ":80", RedirectStatus: StatusFound},
+		"port":   {Address: ":80", RedirectPort: 65536},
+	}
+
+	for name, o := range tests {
+		o.WorkerCap = 1
+		o.QueueLen = 1
+		if err := o.validate(); err == nil {
+			t.Errorf("expected an error for invalid %s", name)
+		}
+	}
+
+	headers := map[string]Opts{
+		"":                                       {},
+		"max-age=60":                             {HSTSMaxAge: time.Minute},
+		"max-age=60; includeSubDomains; preload": {HSTSMaxAge: time.Minute, HSTSIncludeSubdomains: true, HSTSPreload: true},
+	}
+
+	for expected, o := range headers {
+		if val := hstsHeader(&o); val != expected {
+			t.Errorf("invalid HSTS header, expected \"%s\" and received \"%s\"", expected, val)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package webWorkers
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"io"
-	"strconv"
+	"net"
+	"net/textproto"
 
 	"bytes"
 )
@@ -10,6 +13,8 @@ import (
 // Request is an HTTP request
 type Request struct {
 	host           []byte
+	hosts          int  // Number of Host headers
+	absolute       bool // Whether or not the host was provided by an absolute-form target (or :authority)
 	method         []byte
 	path           []byte
 	httpType       []byte
@@ -20,6 +25,52 @@ type Request struct {
 	acceptLanguage []byte
 	contentLength  int
 	contentType    []byte
+	expect         []byte
+	origin         []byte
+	referer        []byte
+	upgrade        []byte
+
+	// Whether or not contentLength was set by a Content-Length header
+	hasContentLength bool
+
+	// WebSocket handshake headers
+	wsKey        []byte
+	wsVersion    []byte
+	wsProtocol   []byte
+	wsExtensions []byte
+
+	// HTTP/2 upgrade headers
+	h2Settings []byte
+
+	// CSRF token header
+	csrfHeader []byte
+
+	// Every header, canonical keys and values are referenced within hsrc by hdrs
+	// Note: hsrc is the worker's read buffer for HTTP/1.x requests, otherwise headers are copied into hbuf
+	hsrc []byte
+	hbuf []byte
+	hdrs []rawHeader
+
+	// Forwarding headers, only trusted when our peer is a trusted proxy
+	forwarded       []byte
+	xForwardedFor   []byte
+	xForwardedProto []byte
+	xRealIP         []byte
+
+	// TLS connection state (nil for plaintext connections)
+	tls *tls.ConnectionState
+	// Address of the client (as provided by the PROXY protocol, when enabled)
+	remoteAddr net.Addr
+	// Address the client connected to
+	localAddr net.Addr
+	// Trusted proxies, set once by the worker
+	trusted []*net.IPNet
+	// Whether or not unix socket peers are trusted proxies, set once by the worker
+	trustUnix bool
+	// Session, set by Sessions.Wrap
+	session *Session
+	// CSRF token, set by CSRF.Wrap
+	csrfToken string
 
 	Body    io.Reader
 	Cookies *Cookies
@@ -27,6 +78,8 @@ type Request struct {
 
 func (r *Request) clean() {
 	r.host = r.host[:0]
+	r.hosts = 0
+	r.absolute = false
 	r.method = r.method[:0]
 	r.path = r.path[:0]
 	r.httpType = r.httpType[:0]
@@ -36,18 +89,58 @@ func (r *Request) clean() {
 	r.acceptEncoding = r.acceptEncoding[:0]
 	r.acceptLanguage = r.acceptLanguage[:0]
 	r.contentLength = 0
+	r.hasContentLength = false
 	r.contentType = r.contentType[:0]
+	r.expect = r.expect[:0]
+	r.origin = r.origin[:0]
+	r.referer = r.referer[:0]
+	r.upgrade = r.upgrade[:0]
+
+	r.wsKey = r.wsKey[:0]
+	r.wsVersion = r.wsVersion[:0]
+	r.wsProtocol = r.wsProtocol[:0]
+	r.wsExtensions = r.wsExtensions[:0]
+
+	r.h2Settings = r.h2Settings[:0]
+
+	r.csrfHeader = r.csrfHeader[:0]
+
+	r.hsrc = nil
+	r.hbuf = r.hbuf[:0]
+	r.hdrs = r.hdrs[:0]
+
+	r.forwarded = r.forwarded[:0]
+	r.xForwardedFor = r.xForwardedFor[:0]
+	r.xForwardedProto = r.xForwardedProto[:0]
+	r.xRealIP = r.xRealIP[:0]
+
+	r.tls = nil
+	r.remoteAddr = nil
+	r.localAddr = nil
+	r.session = nil
+	r.csrfToken = ""
 
 	r.Body = nil
 
 	r.Cookies.clean()
 }
 
-// Host will return the host
+// Host will return the host, including any non-default port (IE: "example.com" or "example.com:8080")
 func (r *Request) Host() string {
 	return string(r.host)
 }
 
+// Hostname will return the host, without any port (IE: "example.com")
+func (r *Request) Hostname() string {
+	name, _, _ := splitHost(r.host)
+	return string(bytes.TrimSuffix(bytes.TrimPrefix(name, []byte{'['}), []byte{']'}))
+}
+
+// Session will return the session of the request, nil is returned if the handler has not been wrapped by Sessions.Wrap
+func (r *Request) Session() *Session {
+	return r.session
+}
+
 // Method will return the method
 func (r *Request) Method() string {
 	return string(r.method)
@@ -98,6 +191,199 @@ func (r *Request) ContentType() string {
 	return string(r.contentType)
 }
 
+// Expect will return the expect
+func (r *Request) Expect() string {
+	return string(r.expect)
+}
+
+// Origin will return the origin
+func (r *Request) Origin() string {
+	return string(r.origin)
+}
+
+// Referer will return the referer
+func (r *Request) Referer() string {
+	return string(r.referer)
+}
+
+// CSRFToken will return the CSRF token to include within forms (or the X-CSRF-Token header), empty if the handler has not been wrapped by CSRF.Wrap
+func (r *Request) CSRFToken() string {
+	return r.csrfToken
+}
+
+// Header will return the value of the first header matching the provided key (case-insensitive)
+func (r *Request) Header(key string) string {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+	for _, rh := range r.hdrs {
+		if string(r.hsrc[rh.key[0]:rh.key[1]]) == key {
+			return string(r.hsrc[rh.val[0]:rh.val[1]])
+		}
+	}
+
+	return ""
+}
+
+// Upgrade will return the upgrade
+func (r *Request) Upgrade() string {
+	return string(r.upgrade)
+}
+
+// RemoteAddr will return the address of the client (IE: "192.0.2.1:56324")
+// Note: When the PROXY protocol is enabled, this is the original client address provided by the load balancer
+func (r *Request) RemoteAddr() string {
+	if r.remoteAddr == nil {
+		return ""
+	}
+
+	return r.remoteAddr.String()
+}
+
+// LocalAddr will return the address the client connected to (IE: "192.0.2.2:443")
+func (r *Request) LocalAddr() string {
+	if r.localAddr == nil {
+		return ""
+	}
+
+	return r.localAddr.String()
+}
+
+// TLS will return the TLS connection state, nil is returned for plaintext connections
+// Verified client certificate chains are available via VerifiedChains when client auth is enabled
+// Note: The returned state is only valid until the handler returns
+func (r *Request) TLS() *tls.ConnectionState {
+	return r.tls
+}
+
+// PeerCertificate will return the leaf certificate provided by the client, nil is returned if none was provided
+func (r *Request) PeerCertificate() *x509.Certificate {
+	if r.tls == nil || len(r.tls.PeerCertificates) == 0 {
+		return nil
+	}
+
+	return r.tls.PeerCertificates[0]
+}
+
+// setHeader will copy and set a provided header (IE: HTTP/2 header fields, which are not within a read buffer)
+// Note: Header keys are case-insensitive, so keys are matched in their canonical form (IE: "content-type" as "Content-Type")
+func (r *Request) setHeader(key, val []byte) error {
+	rh := rawHeader{key: [2]int{len(r.hbuf), len(r.hbuf) + len(key)}}
+	r.hbuf = append(r.hbuf, key...)
+	canonicalizeKey(r.hbuf[rh.key[0]:rh.key[1]])
+	rh.val = [2]int{len(r.hbuf), len(r.hbuf) + len(val)}
+	r.hbuf = append(r.hbuf, val...)
+
+	r.hsrc = r.hbuf
+	return r.addHeader(rh)
+}
+
+// setRawHeader will set a header referenced within our read buffer (hsrc), canonicalizing its key in place
+func (r *Request) setRawHeader(rh rawHeader) error {
+	canonicalizeKey(r.hsrc[rh.key[0]:rh.key[1]])
+	return r.addHeader(rh)
+}
+
+// addHeader will add a header referenced within hsrc, retaining the values of known headers
+// ErrInvalidHeaderStatus is returned for an invalid or conflicting Content-Length, as our body length would be ambiguous
+func (r *Request) addHeader(rh rawHeader) (err error) {
+	r.hdrs = append(r.hdrs, rh)
+	val := r.hsrc[rh.val[0]:rh.val[1]]
+
+	switch string(r.hsrc[rh.key[0]:rh.key[1]]) {
+	case "Host":
+		if r.hosts++; !r.absolute {
+			r.host = append(r.host[:0], val...)
+		}
+	case "Connection":
+		r.connection = append(r.connection, val...)
+	case "User-Agent":
+		r.userAgent = append(r.userAgent, val...)
+	case "Accept":
+		r.accept = append(r.accept, val...)
+	case "Accept-Encoding":
+		r.acceptEncoding = append(r.acceptEncoding, val...)
+	case "Accept-Language":
+		r.acceptLanguage = append(r.acceptLanguage, val...)
+	case "Content-Length":
+		var n int
+		if n, err = parseContentLength(val); err != nil {
+			return
+		}
+
+		if r.hasContentLength && n != r.contentLength {
+			// Duplicated Content-Length headers must agree
+			return ErrInvalidHeaderStatus
+		}
+
+		r.contentLength = n
+		r.hasContentLength = true
+	case "Content-Type":
+		r.contentType = append(r.contentType, val...)
+	case "Expect":
+		r.expect = append(r.expect, val...)
+	case "Origin":
+		r.origin = append(r.origin, val...)
+	case "Referer":
+		r.referer = append(r.referer[:0], val...)
+	case "Upgrade":
+		r.upgrade = append(r.upgrade, val...)
+
+	case "Sec-Websocket-Key":
+		r.wsKey = append(r.wsKey, val...)
+	case "Sec-Websocket-Version":
+		r.wsVersion = append(r.wsVersion, val...)
+	case "Sec-Websocket-Protocol":
+		r.wsProtocol = appendList(r.wsProtocol, val)
+	case "Sec-Websocket-Extensions":
+		r.wsExtensions = appendList(r.wsExtensions, val)
+
+	case "Http2-Settings":
+		r.h2Settings = append(r.h2Settings, val...)
+
+	case "X-Csrf-Token":
+		r.csrfHeader = append(r.csrfHeader[:0], val...)
+
+	case "Forwarded":
+		r.forwarded = appendList(r.forwarded, val)
+	case "X-Forwarded-For":
+		r.xForwardedFor = appendList(r.xForwardedFor, val)
+	case "X-Forwarded-Proto":
+		r.xForwardedProto = appendList(r.xForwardedProto, val)
+	case "X-Real-Ip":
+		r.xRealIP = append(r.xRealIP[:0], val...)
+
+	case "Cookie":
+		r.Cookies.set(val)
+	}
+
+	return
+}
+
+// maxInt is the largest value of an int
+const maxInt = int(^uint(0) >> 1)
+
+// parseContentLength will parse a Content-Length value, which must consist of digits only
+func parseContentLength(val []byte) (n int, err error) {
+	if len(val) == 0 {
+		return 0, ErrInvalidHeaderStatus
+	}
+
+	for _, b := range val {
+		if b < '0' || b > '9' {
+			// Signs, whitespace and lists are not valid lengths
+			return 0, ErrInvalidHeaderStatus
+		}
+
+		if n > (maxInt-int(b-'0'))/10 {
+			// Length overflows an int
+			return 0, ErrInvalidHeaderStatus
+		}
+
+		n = n*10 + int(b-'0')
+	}
+
+	return
+}
+
 func (r *Request) processStatus(bs []byte) (n int, err error) {
 	var (
 		status []byte
@@ -110,7 +396,12 @@ func (r *Request) processStatus(bs []byte) (n int, err error) {
 			continue
 		}
 
-		if spl = bytes.Split(status, []byte{' '}); len(spl) < 3 {
+		if spl = bytes.Split(trimSuffix(status), []byte{' '}); len(spl) != 3 || len(spl[1]) == 0 {
+			err = ErrInvalidHeaderStatus
+			return
+		}
+
+		if !isToken(string(spl[0])) || !isHTTPVersion(spl[2]) {
 			err = ErrInvalidHeaderStatus
 			return
 		}
@@ -119,70 +410,80 @@ func (r *Request) processStatus(bs []byte) (n int, err error) {
 		r.method = append(r.method, spl[0]...)
 		r.path = append(r.path, spl[1]...)
 		r.httpType = append(r.httpType, spl[2]...)
-		break
+		r.processTarget()
+
+		if !bytes.Equal(r.httpType, httpType) && !bytes.Equal(r.httpType, httpType10) {
+			err = ErrUnsupportedHTTPVersion
+		}
+
+		return
 	}
 
+	// We have not reached the end of our request line
+	err = ErrInvalidHeaderStatus
 	return
 }
 
-func (r *Request) processHeader(bs []byte) (n int, err error) {
-	var (
-		s   state
-		key []byte
-		val []byte
-	)
+// isHTTPVersion will return whether or not a request line's version is well-formed (IE: "HTTP/1.1")
+func isHTTPVersion(v []byte) bool {
+	if len(v) != 8 || !bytes.HasPrefix(v, []byte("HTTP/")) || v[6] != '.' {
+		return false
+	}
 
+	return v[5] >= '0' && v[5] <= '9' && v[7] >= '0' && v[7] <= '9'
+}
+
+func (r *Request) processHeader(bs []byte) (n int, err error) {
 	if n, err = r.processStatus(bs); err != nil {
 		return
 	}
 
-	for i, b := range bs[n:] {
-		switch s {
-		case stateKey:
-			if b == ':' {
-				s = stateVal
-				continue
-			}
-
-			if b == '\n' {
-				n = i + 1
-				break
-			}
-
-			key = append(key, b)
-
-		case stateVal:
-			if b == '\n' {
-				val = trimPrefix(val)
-				switch string(key) {
-				case "Connection":
-					r.connection = append(r.connection, val...)
-				case "User-Agent":
-					r.userAgent = append(r.userAgent, val...)
-				case "Accept":
-					r.accept = append(r.accept, val...)
-				case "Accept-Encoding":
-					r.acceptEncoding = append(r.acceptEncoding, val...)
-				case "Accept-Language":
-					r.acceptLanguage = append(r.acceptLanguage, val...)
-				case "Content-Length":
-					r.contentLength, _ = strconv.Atoi(string(val))
-				case "Content-Type":
-					r.contentType = append(r.contentType, val...)
-
-				case "Cookie":
-					r.Cookies.set(val)
-				}
-
-				s = stateKey
-				key = key[:0]
-				val = val[:0]
-				continue
-			}
-
-			val = append(val, b)
+	// Headers are referenced within our read buffer, rather than copied
+	r.hsrc = bs
+	for i := n; i < len(bs); {
+		eol := bytes.IndexByte(bs[i:], '\n')
+		if eol == -1 {
+			// We have not reached the end of our header
+			return
+		}
+
+		line := bs[i : i+eol]
+		if len(line) == 0 || (len(line) == 1 && line[0] == '\r') {
+			// We've reached the empty line which terminates our headers, set n as the start of our body
+			n = i + eol + 1
+			return
 		}
+
+		colon := bytes.IndexByte(line, ':')
+		if colon == -1 || line[0] == ' ' || line[0] == '\t' || !isTokenBytes(line[:colon]) {
+			// Lines without a colon, obsolete line folding, and keys which are not tokens (IE: "Key : value")
+			// are rejected, rather than risk disagreeing with a proxy on where our headers end
+			err = ErrInvalidHeaderStatus
+			return
+		}
+
+		// Values are trimmed of leading and trailing whitespace, as well as their carriage return
+		vs, ve := colon+1, len(line)
+		for vs < ve && (line[vs] == ' ' || line[vs] == '\t') {
+			vs++
+		}
+
+		for ve > vs && (line[ve-1] == ' ' || line[ve-1] == '\t' || line[ve-1] == '\r') {
+			ve--
+		}
+
+		if err = r.setRawHeader(rawHeader{key: [2]int{i, i + colon}, val: [2]int{i + vs, i + ve}}); err != nil {
+			return
+		}
+
+		i += eol + 1
 	}
 
 	return
 }
+
+// rawHeader references the key and value of a request header within Request.hsrc
+type rawHeader struct {
+	key [2]int
+	val [2]int
+}
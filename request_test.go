@@ -0,0 +1,74 @@
+package webWorkers
+
+import (
+	"net/textproto"
+	"testing"
+)
+
+func TestCanonicalizeKey(t *testing.T) {
+	for _, key := range []string{"content-type", "CONTENT-LENGTH", "x-forwarded-for", "Sec-WebSocket-Key", "a", "-x-", "foo bar", "x_y", "1st-header"} {
+		bs := []byte(key)
+		canonicalizeKey(bs)
+		if str, expected := string(bs), textproto.CanonicalMIMEHeaderKey(key); str != expected {
+			t.Errorf("invalid canonical key for %q, expected %q and received %q", key, expected, str)
+		}
+	}
+}
+
+func TestSetHeaderAllocs(t *testing.T) {
+	var req Request
+	key, val := []byte("x-custom-header"), []byte("value")
+	allocs := testing.AllocsPerRun(100, func() {
+		req.hbuf = req.hbuf[:0]
+		req.hdrs = req.hdrs[:0]
+		req.setHeader(key, val)
+	})
+
+	if allocs != 0 {
+		t.Fatalf("expected setting a header not to allocate, received %v allocations", allocs)
+	}
+
+	if str := req.Header("X-CUSTOM-HEADER"); str != "value" {
+		t.Fatalf("expected %q and received %q", "value", str)
+	}
+}
+
+func TestProcessHeader(t *testing.T) {
+	var req Request
+	req.Cookies = newCookies()
+	head := "POST /a HTTP/1.1\r\nhost: localhost\r\ncontent-LENGTH:\t4 \r\nX-Empty:\r\nx-custom:  a b\r\n\r\n"
+	bs := []byte(head + "body")
+
+	n, err := req.processHeader(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != len(head) {
+		t.Fatalf("expected our body to begin at %d, received %d", len(head), n)
+	}
+
+	if req.ContentLength() != 4 || req.Header("Host") != "localhost" || req.Header("X-Custom") != "a b" || req.Header("X-Empty") != "" {
+		t.Fatalf("invalid headers: %d, %q, %q", req.ContentLength(), req.Header("Host"), req.Header("X-Custom"))
+	}
+
+	if len(req.hdrs) != 4 {
+		t.Fatalf("expected 4 headers, received %d", len(req.hdrs))
+	}
+
+	// Headers are referenced within our read buffer, rather than copied
+	if &req.hsrc[0] != &bs[0] {
+		t.Fatal("expected our headers to be referenced within our read buffer")
+	}
+
+	rh := rawHeader{key: [2]int{len("POST /a HTTP/1.1\r\n"), len("POST /a HTTP/1.1\r\nhost")}}
+	rh.val = [2]int{rh.key[1] + 2, rh.key[1] + 2 + len("localhost")}
+	allocs := testing.AllocsPerRun(100, func() {
+		req.hdrs = req.hdrs[:0]
+		req.setRawHeader(rh)
+	})
+
+	if allocs != 0 {
+		t.Fatalf("expected setting a raw header not to allocate, received %v allocations", allocs)
+	}
+}
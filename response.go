@@ -1,15 +1,25 @@
 package webWorkers
 
 import (
+	"bytes"
+	"io"
+	"log"
 	"net"
+	"strings"
 	"time"
 )
 
 // Response is an http response
 type Response struct {
 	headersSent bool
+	detached    bool
 	conn        net.Conn
 
+	// Request associated with this response
+	req *Request
+	// Bytes read from the conn by the worker which have not been consumed
+	rbuf *bytes.Buffer
+
 	statusCode    []byte
 	contentType   []byte
 	connection    []byte
@@ -17,35 +27,67 @@ type Response struct {
 	server        []byte
 	lastModified  []byte
 	contentLength int
+	headers       []header
+
+	// Funcs called before our headers are sent
+	beforeHeaders []func(*Response)
+
+	// Logger of our worker, used by handlers which outlive the request (IE: WebSocket handlers)
+	l *log.Logger
 
 	Cookies *Cookies
 }
 
+// header is an additional response header
+type header struct {
+	key string
+	val string
+}
+
 func (r *Response) bytes() (out []byte) {
 	now := time.Now().Format(dateFmt)
 	out = make([]byte, 0, 256)
 
 	out = append(out, httpType...)
 	out = append(out, ' ')
-	out = append(out, r.statusCode...)
+	if len(r.statusCode) > 0 {
+		out = append(out, r.statusCode...)
+	} else {
+		// Status code has not been set, default to 200
+		out = append(out, statusOK...)
+	}
+
+	out = append(out, "\r\n"...)
 	out = append(out, server...)
-	out = append(out, "Content-Type: "+string(r.contentType)+"\n"...)
-	out = append(out, "Connection: close\n"...)
-	out = append(out, "Date: "+now+"\n"...)
-	out = append(out, "Last-Modified: "+now+"\n"...)
+	if len(r.contentType) > 0 {
+		out = append(out, "Content-Type: "+string(r.contentType)+"\r\n"...)
+	}
 
-	for _, ck := range r.Cookies.cks {
-		out = append(out, "Set-Cookie: "+ck.String()+"\n"...)
+	out = append(out, "Connection: close\r\n"...)
+	out = append(out, "Date: "+now+"\r\n"...)
+	out = append(out, "Last-Modified: "+now+"\r\n"...)
+
+	for _, h := range r.headers {
+		out = append(out, h.key...)
+		out = append(out, ": "...)
+		out = append(out, h.val...)
+		out = append(out, "\r\n"...)
+	}
 
+	for _, ck := range r.Cookies.cks {
+		out = append(out, "Set-Cookie: "+ck.String()+"\r\n"...)
 	}
 
-	out = append(out, '\n')
+	out = append(out, "\r\n"...)
 	return
 }
 
 func (r *Response) clean() {
 	r.headersSent = false
+	r.detached = false
 	r.conn = nil
+	r.req = nil
+	r.rbuf = nil
 
 	r.statusCode = r.statusCode[:0]
 	r.contentType = r.contentType[:0]
@@ -54,13 +96,36 @@ func (r *Response) clean() {
 	r.server = r.server[:0]
 	r.lastModified = r.lastModified[:0]
 	r.contentLength = 0
+	r.headers = r.headers[:0]
+	r.beforeHeaders = r.beforeHeaders[:0]
 
 	r.Cookies.clean()
 }
 
+// writeHeaders will write the headers to the underlying connection
+func (r *Response) writeHeaders() (err error) {
+	for _, fn := range r.beforeHeaders {
+		fn(r)
+	}
+
+	// Funcs are only called once, even if writing our headers fails
+	r.beforeHeaders = r.beforeHeaders[:0]
+
+	if s, ok := r.conn.(*h2Stream); ok {
+		// HTTP/2 headers are HPACK-encoded and sent within a HEADERS frame
+		return s.writeHeaders(r.h2Headers())
+	}
+
+	_, err = r.conn.Write(r.bytes())
+	return
+}
+
 func (r *Response) Write(b []byte) (err error) {
 	if !r.headersSent {
-		r.conn.Write(r.bytes())
+		if err = r.writeHeaders(); err != nil {
+			return
+		}
+
 		r.headersSent = true
 	}
 
@@ -100,3 +165,44 @@ func (r *Response) ContentType(ct string) (err error) {
 	r.contentType = append(r.contentType, ct...)
 	return
 }
+
+// Header will add an additional header to the response
+func (r *Response) Header(key, val string) (err error) {
+	if r.headersSent {
+		return ErrHeadersSent
+	}
+
+	if key == "" || strings.IndexByte(key, ':') > -1 || !isValidHeader(key) || !isValidHeader(val) {
+		return ErrInvalidHeader
+	}
+
+	r.headers = append(r.headers, header{key: key, val: val})
+	return
+}
+
+// BeforeHeaders will add a func which is called before our headers are sent, so it may still modify the headers and cookies
+// Note: Funcs are called in the order they were added
+func (r *Response) BeforeHeaders(fn func(*Response)) (err error) {
+	if r.headersSent {
+		return ErrHeadersSent
+	}
+
+	r.beforeHeaders = append(r.beforeHeaders, fn)
+	return
+}
+
+// detach will take ownership of the underlying net.Conn away from the worker
+// Note: The worker will not close a detached net.Conn, the caller is responsible for closing it
+func (r *Response) detach() (c net.Conn) {
+	var rest []byte
+	if r.rbuf != nil {
+		// Copy any unread bytes, the worker will reuse its buffer for the next request
+		rest = append(rest, r.rbuf.Bytes()...)
+	}
+
+	r.detached = true
+	return &detachedConn{
+		Conn: r.conn,
+		r:    io.MultiReader(bytes.NewReader(rest), r.conn),
+	}
+}
@@ -0,0 +1,211 @@
+package webWorkers
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// SecureCookieMode represents how secure cookie values are protected
+type SecureCookieMode uint8
+
+const (
+	// SecureCookieSign signs values with HMAC-SHA256, values remain readable by clients
+	SecureCookieSign SecureCookieMode = iota
+	// SecureCookieEncrypt encrypts values with AES-256-GCM, values are neither readable nor modifiable by clients
+	SecureCookieEncrypt
+)
+
+const (
+	// secureCookieKeyLen is the required length of secure cookie keys
+	secureCookieKeyLen = 32
+	// secureCookieTSLen is the length of the timestamp embedded within secure cookie values
+	secureCookieTSLen = 8
+	// secureCookieSkew is how far in the future a secure cookie timestamp may be, to allow for clock skew between servers
+	secureCookieSkew = time.Minute
+)
+
+// secureCookieEncoding is the encoding of secure cookie values, which is safe to use within cookie values without quoting
+var secureCookieEncoding = base64.RawURLEncoding
+
+// NewSecureCookies will return a new SecureCookies using the provided mode, max age, and keyring
+// Values are encoded with the first key and decoded with any key, so keys can be rotated without invalidating existing cookies
+// Note: A max age of zero disables max age validation
+func NewSecureCookies(mode SecureCookieMode, maxAge time.Duration, keys ...[]byte) (sc *SecureCookies, err error) {
+	if mode != SecureCookieSign && mode != SecureCookieEncrypt {
+		return nil, ErrInvalidSecureCookieMode
+	}
+
+	sc = &SecureCookies{
+		mode:   mode,
+		maxAge: maxAge,
+	}
+
+	if err = sc.Rotate(keys...); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// SecureCookies signs or encrypts cookie values
+type SecureCookies struct {
+	mode   SecureCookieMode
+	maxAge time.Duration
+
+	// Current keyring ([]secureKey), swapped atomically on rotation
+	keys atomic.Value
+}
+
+// secureKey is a secure cookie key, prepared for our mode
+type secureKey struct {
+	key  []byte
+	aead cipher.AEAD
+}
+
+// Rotate will replace the keyring, the first key is used for encoding new values
+// Note: Keys must be 32 bytes, keep retired keys after the first key until the cookies encoded with them have expired
+func (sc *SecureCookies) Rotate(keys ...[]byte) (err error) {
+	if len(keys) == 0 {
+		return ErrEmptySecureCookieKeys
+	}
+
+	sks := make([]secureKey, 0, len(keys))
+	for _, key := range keys {
+		if len(key) != secureCookieKeyLen {
+			return ErrInvalidSecureCookieKey
+		}
+
+		sk := secureKey{key: append([]byte(nil), key...)}
+		if sc.mode == SecureCookieEncrypt {
+			var block cipher.Block
+			if block, err = aes.NewCipher(sk.key); err != nil {
+				return
+			}
+
+			if sk.aead, err = cipher.NewGCM(block); err != nil {
+				return
+			}
+		}
+
+		sks = append(sks, sk)
+	}
+
+	sc.keys.Store(sks)
+	return
+}
+
+// Get will return the decoded value of the request cookie matching the provided name
+func (sc *SecureCookies) Get(cks *Cookies, name string) (val string, err error) {
+	raw, ok := cks.lookup(name)
+	if !ok {
+		return "", ErrCookieNotFound
+	}
+
+	return sc.Decode(name, raw)
+}
+
+// Set will encode the value of the provided cookie and set it within the response cookies
+func (sc *SecureCookies) Set(cks *Cookies, ck Cookie) (err error) {
+	if ck.Val, err = sc.Encode(ck.Key, ck.Val); err != nil {
+		return
+	}
+
+	return cks.SetCookie(ck)
+}
+
+// Encode will sign or encrypt a cookie value, the cookie name is bound to the value so it cannot be moved to another cookie
+func (sc *SecureCookies) Encode(name, val string) (string, error) {
+	return sc.encode(name, val, time.Now())
+}
+
+// encode will sign or encrypt a cookie value with the provided timestamp
+func (sc *SecureCookies) encode(name, val string, ts time.Time) (out string, err error) {
+	sk := sc.keys.Load().([]secureKey)[0]
+	payload := make([]byte, secureCookieTSLen, secureCookieTSLen+len(val))
+	binary.BigEndian.PutUint64(payload, uint64(ts.Unix()))
+	payload = append(payload, val...)
+
+	var bs []byte
+	switch sc.mode {
+	case SecureCookieSign:
+		bs = append(payload, signCookie(sk.key, name, payload)...)
+	case SecureCookieEncrypt:
+		bs = make([]byte, sk.aead.NonceSize(), sk.aead.NonceSize()+len(payload)+sk.aead.Overhead())
+		if _, err = io.ReadFull(rand.Reader, bs); err != nil {
+			return
+		}
+
+		bs = sk.aead.Seal(bs, bs, payload, []byte(name))
+	}
+
+	return secureCookieEncoding.EncodeToString(bs), nil
+}
+
+// Decode will verify or decrypt a cookie value, ensuring it has not exceeded our max age
+func (sc *SecureCookies) Decode(name, val string) (out string, err error) {
+	var bs []byte
+	if bs, err = secureCookieEncoding.DecodeString(val); err != nil {
+		return "", ErrInvalidSecureCookie
+	}
+
+	var payload []byte
+	for _, sk := range sc.keys.Load().([]secureKey) {
+		if payload = sc.open(sk, name, bs); payload != nil {
+			break
+		}
+	}
+
+	if len(payload) < secureCookieTSLen {
+		// Value was not encoded by any of our keys
+		return "", ErrInvalidSecureCookie
+	}
+
+	ts := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+	if now := time.Now(); ts.After(now.Add(secureCookieSkew)) || (sc.maxAge > 0 && now.Sub(ts) > sc.maxAge) {
+		return "", ErrSecureCookieExpired
+	}
+
+	return string(payload[secureCookieTSLen:]), nil
+}
+
+// open will return the payload of an encoded value, nil is returned if the value was not encoded by the provided key
+func (sc *SecureCookies) open(sk secureKey, name string, bs []byte) (payload []byte) {
+	switch sc.mode {
+	case SecureCookieSign:
+		if len(bs) < secureCookieTSLen+sha256.Size {
+			return
+		}
+
+		payload = bs[:len(bs)-sha256.Size]
+		if !hmac.Equal(bs[len(bs)-sha256.Size:], signCookie(sk.key, name, payload)) {
+			return nil
+		}
+	case SecureCookieEncrypt:
+		ns := sk.aead.NonceSize()
+		if len(bs) < ns+sk.aead.Overhead() {
+			return
+		}
+
+		payload, _ = sk.aead.Open(nil, bs[:ns], bs[ns:], []byte(name))
+	}
+
+	return
+}
+
+// signCookie will return the HMAC-SHA256 of a cookie name and payload
+func signCookie(key []byte, name string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name))
+	// Separate the name from the payload, so their boundary cannot be shifted
+	mac.Write([]byte{0})
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
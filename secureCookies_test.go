@@ -0,0 +1,77 @@
+package webWorkers
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecureCookies(t *testing.T) {
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+
+	for _, mode := range []SecureCookieMode{SecureCookieSign, SecureCookieEncrypt} {
+		sc, err := NewSecureCookies(mode, time.Hour, oldKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res := newCookies()
+		if err = sc.Set(res, Cookie{Key: "session", Val: "user=1; admin", HttpOnly: true}); err != nil {
+			t.Fatal(err)
+		}
+
+		raw := res.cks[0].Val
+		if mode == SecureCookieEncrypt && strings.Contains(raw, "user") {
+			t.Fatalf("encrypted value is readable: %s", raw)
+		}
+
+		// Keys which have been rotated out of first position must still decode existing values
+		if err = sc.Rotate(newKey, oldKey); err != nil {
+			t.Fatal(err)
+		}
+
+		req := newCookies()
+		req.set([]byte("session=" + raw + "; other=" + raw))
+		if val, err := sc.Get(req, "session"); err != nil || val != "user=1; admin" {
+			t.Fatalf("invalid value, received \"%s\" (%v)", val, err)
+		}
+
+		// Values are bound to their cookie name
+		if _, err = sc.Get(req, "other"); err != ErrInvalidSecureCookie {
+			t.Fatalf("expected ErrInvalidSecureCookie and received %v", err)
+		}
+
+		if _, err = sc.Get(req, "missing"); err != ErrCookieNotFound {
+			t.Fatalf("expected ErrCookieNotFound and received %v", err)
+		}
+
+		tampered := []byte(raw)
+		tampered[len(tampered)/2] ^= 1
+		if _, err = sc.Decode("session", string(tampered)); err != ErrInvalidSecureCookie {
+			t.Fatalf("expected ErrInvalidSecureCookie for a tampered value and received %v", err)
+		}
+
+		expired, _ := sc.encode("session", "value", time.Now().Add(-time.Hour*2))
+		if _, err = sc.Decode("session", expired); err != ErrSecureCookieExpired {
+			t.Fatalf("expected ErrSecureCookieExpired and received %v", err)
+		}
+
+		// Once retired, a key can no longer decode values
+		if err = sc.Rotate(newKey); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = sc.Decode("session", raw); err != ErrInvalidSecureCookie {
+			t.Fatalf("expected ErrInvalidSecureCookie for a retired key and received %v", err)
+		}
+	}
+
+	if _, err := NewSecureCookies(SecureCookieSign, 0); err != ErrEmptySecureCookieKeys {
+		t.Fatalf("expected ErrEmptySecureCookieKeys and received %v", err)
+	}
+
+	if _, err := NewSecureCookies(SecureCookieEncrypt, 0, []byte("short")); err != ErrInvalidSecureCookieKey {
+		t.Fatalf("expected ErrInvalidSecureCookieKey and received %v", err)
+	}
+}
@@ -0,0 +1,309 @@
+package webWorkers
+
+import (
+	"container/list"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// sessionCookiePurpose is the name signed-cookie sessions are bound to, so they cannot be used as other secure cookies
+	sessionCookiePurpose = "webWorkers.session"
+	// maxSessionCookieLen is the largest session cookie value we will set, as browsers drop cookies over 4096 bytes
+	maxSessionCookieLen = 4000
+)
+
+// SessionStore stores sessions, it must be safe for concurrent use
+type SessionStore interface {
+	// Load will return the session data referred to by a session cookie value
+	// ErrSessionNotFound is returned when the session does not exist (or the store has expired it)
+	Load(ref string) (sd SessionData, err error)
+	// Save will store session data for the provided duration (zero does not expire), returning the session cookie value which refers to it
+	Save(sd SessionData, ttl time.Duration) (ref string, err error)
+	// Delete will remove the session data of the provided ID, deleting a session which does not exist is not an error
+	Delete(id string) error
+}
+
+// NewMemoryStore will return a new in-memory SessionStore holding up to the provided number of sessions
+// Note: Once full, the least recently used session is evicted, a capacity of zero is unbounded
+func NewMemoryStore(capacity int) *MemoryStore {
+	return &MemoryStore{
+		capacity: capacity,
+		l:        list.New(),
+		m:        make(map[string]*list.Element, capacity),
+	}
+}
+
+// MemoryStore is an in-memory SessionStore with LRU eviction and per-session expiry
+type MemoryStore struct {
+	mux sync.Mutex
+
+	capacity int
+	// Sessions ordered by use, most recently used first
+	l *list.List
+	m map[string]*list.Element
+}
+
+// memoryEntry is a session stored within a MemoryStore
+type memoryEntry struct {
+	sd      SessionData
+	expires time.Time
+}
+
+// Load will return the session data of the provided ID
+func (m *MemoryStore) Load(id string) (sd SessionData, err error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	el, ok := m.m[id]
+	if !ok {
+		return sd, ErrSessionNotFound
+	}
+
+	me := el.Value.(*memoryEntry)
+	if !me.expires.IsZero() && time.Now().After(me.expires) {
+		m.remove(el)
+		return sd, ErrSessionNotFound
+	}
+
+	m.l.MoveToFront(el)
+	// Return a copy, so the stored session is not modified by the request
+	return me.sd.dup(), nil
+}
+
+// Save will store session data, returning its ID
+func (m *MemoryStore) Save(sd SessionData, ttl time.Duration) (id string, err error) {
+	me := &memoryEntry{sd: sd.dup()}
+	if ttl > 0 {
+		me.expires = time.Now().Add(ttl)
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if el, ok := m.m[sd.ID]; ok {
+		el.Value = me
+		m.l.MoveToFront(el)
+		return sd.ID, nil
+	}
+
+	m.m[sd.ID] = m.l.PushFront(me)
+	for m.capacity > 0 && m.l.Len() > m.capacity {
+		// Evict our least recently used session
+		m.remove(m.l.Back())
+	}
+
+	return sd.ID, nil
+}
+
+// Delete will remove the session data of the provided ID
+func (m *MemoryStore) Delete(id string) (err error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if el, ok := m.m[id]; ok {
+		m.remove(el)
+	}
+
+	return
+}
+
+// Len will return the number of stored sessions, including expired sessions which have not yet been evicted
+func (m *MemoryStore) Len() int {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.l.Len()
+}
+
+// remove will remove an element from our list and map
+// Note: The caller must hold our mutex
+func (m *MemoryStore) remove(el *list.Element) {
+	m.l.Remove(el)
+	delete(m.m, el.Value.(*memoryEntry).sd.ID)
+}
+
+// NewCookieStore will return a new SessionStore which stores sessions within their cookie, using the provided SecureCookies
+// Note: Cookie sessions cannot be revoked before they expire, use a SecureCookies max age as an upper bound and encryption if values are sensitive
+func NewCookieStore(sc *SecureCookies) *CookieStore {
+	return &CookieStore{sc: sc}
+}
+
+// CookieStore is a SessionStore which stores sessions within their (signed or encrypted) cookie
+type CookieStore struct {
+	sc *SecureCookies
+}
+
+// Load will decode the session data within a session cookie value
+func (c *CookieStore) Load(ref string) (sd SessionData, err error) {
+	var val string
+	if val, err = c.sc.Decode(sessionCookiePurpose, ref); err != nil {
+		// Invalid and expired cookies no longer refer to a session
+		return sd, ErrSessionNotFound
+	}
+
+	if err = json.Unmarshal([]byte(val), &sd); err != nil {
+		return sd, ErrSessionNotFound
+	}
+
+	return
+}
+
+// Save will encode session data as a session cookie value
+func (c *CookieStore) Save(sd SessionData, ttl time.Duration) (ref string, err error) {
+	var b []byte
+	if b, err = json.Marshal(sd); err != nil {
+		return
+	}
+
+	if ref, err = c.sc.Encode(sessionCookiePurpose, string(b)); err != nil {
+		return
+	}
+
+	if len(ref) > maxSessionCookieLen {
+		return "", ErrSessionTooLarge
+	}
+
+	return
+}
+
+// Delete is a no-op, cookie sessions are deleted by deleting their cookie
+func (c *CookieStore) Delete(id string) (err error) {
+	return
+}
+
+// NewFileStore will return a new SessionStore which stores each session as a file within the provided directory
+// Note: Expired sessions are removed when loaded, call Purge periodically to remove sessions which are never loaded again
+func NewFileStore(dir string) (fs *FileStore, err error) {
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+// FileStore is a file-backed SessionStore
+type FileStore struct {
+	dir string
+}
+
+// fileEntry is a session stored within a FileStore
+type fileEntry struct {
+	Session SessionData `json:"session"`
+	Expires time.Time   `json:"expires"`
+}
+
+// Load will return the session data of the provided ID
+func (f *FileStore) Load(id string) (sd SessionData, err error) {
+	if !isSessionID(id) {
+		// Refuse IDs which could be used to read outside of our directory
+		return sd, ErrSessionNotFound
+	}
+
+	var fe fileEntry
+	if fe, err = f.read(filepath.Join(f.dir, id)); os.IsNotExist(err) {
+		return sd, ErrSessionNotFound
+	} else if err != nil {
+		return
+	}
+
+	if !fe.Expires.IsZero() && time.Now().After(fe.Expires) {
+		os.Remove(filepath.Join(f.dir, id))
+		return sd, ErrSessionNotFound
+	}
+
+	return fe.Session, nil
+}
+
+// Save will store session data, returning its ID
+// Note: Sessions are written to a temporary file and renamed, so concurrent loads never read a partial session
+func (f *FileStore) Save(sd SessionData, ttl time.Duration) (id string, err error) {
+	if !isSessionID(sd.ID) {
+		return "", ErrInvalidSessionID
+	}
+
+	fe := fileEntry{Session: sd}
+	if ttl > 0 {
+		fe.Expires = time.Now().Add(ttl)
+	}
+
+	var b []byte
+	if b, err = json.Marshal(fe); err != nil {
+		return
+	}
+
+	var tmp *os.File
+	if tmp, err = ioutil.TempFile(f.dir, ".tmp-"); err != nil {
+		return
+	}
+
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return
+	}
+
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+
+	if err = os.Rename(tmp.Name(), filepath.Join(f.dir, sd.ID)); err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+
+	return sd.ID, nil
+}
+
+// Delete will remove the session data of the provided ID
+func (f *FileStore) Delete(id string) (err error) {
+	if !isSessionID(id) {
+		return
+	}
+
+	if err = os.Remove(filepath.Join(f.dir, id)); os.IsNotExist(err) {
+		err = nil
+	}
+
+	return
+}
+
+// Purge will remove every expired session
+func (f *FileStore) Purge() (err error) {
+	var fis []os.FileInfo
+	if fis, err = ioutil.ReadDir(f.dir); err != nil {
+		return
+	}
+
+	now := time.Now()
+	for _, fi := range fis {
+		if !isSessionID(fi.Name()) {
+			continue
+		}
+
+		fe, rerr := f.read(filepath.Join(f.dir, fi.Name()))
+		if rerr != nil || fe.Expires.IsZero() || now.Before(fe.Expires) {
+			// Unreadable sessions are left in place, they may be mid-write
+			continue
+		}
+
+		os.Remove(filepath.Join(f.dir, fi.Name()))
+	}
+
+	return
+}
+
+// read will read a stored session file
+func (f *FileStore) read(path string) (fe fileEntry, err error) {
+	var b []byte
+	if b, err = ioutil.ReadFile(path); err != nil {
+		return
+	}
+
+	err = json.Unmarshal(b, &fe)
+	return
+}
@@ -0,0 +1,351 @@
+package webWorkers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	// defaultSessionCookie is the default name of session cookies
+	defaultSessionCookie = "session"
+	// sessionIDLen is the number of random bytes within a session ID
+	sessionIDLen = 32
+)
+
+// NewSessions will return a new Sessions using the provided store and options
+func NewSessions(store SessionStore, o SessionOpts) *Sessions {
+	if o.CookieName == "" {
+		o.CookieName = defaultSessionCookie
+	}
+
+	if o.Path == "" {
+		o.Path = "/"
+	}
+
+	if o.SameSite == SameSiteDefault {
+		o.SameSite = SameSiteLax
+	}
+
+	if o.ErrorOutput == nil {
+		o.ErrorOutput = os.Stderr
+	}
+
+	return &Sessions{
+		store: store,
+		o:     o,
+		l:     log.New(o.ErrorOutput, "webWorkers (sessions): ", log.Ldate|log.Ltime),
+	}
+}
+
+// SessionOpts are the options for Sessions
+type SessionOpts struct {
+	// Name of the session cookie, defaults to "session"
+	CookieName string
+	// Path of the session cookie, defaults to "/"
+	Path string
+	// Domain of the session cookie (optional)
+	Domain string
+	// Whether or not the session cookie is only sent over HTTPS
+	Secure bool
+	// SameSite policy of the session cookie, defaults to Lax
+	SameSite SameSite
+
+	// Sessions expire once they have not been used for this long (optional)
+	IdleTimeout time.Duration
+	// Sessions expire this long after they were created (or regenerated), regardless of use (optional)
+	AbsoluteTimeout time.Duration
+
+	// Output for errors returned by our store, defaults to os.Stderr
+	ErrorOutput io.Writer
+}
+
+// Sessions loads and saves a session for each request
+type Sessions struct {
+	store SessionStore
+	o     SessionOpts
+	l     *log.Logger
+}
+
+// Wrap will return a Handler which provides a session to the provided Handler, see Request.Session
+// Note: Sessions are saved before the response headers are sent, changes made after writing to the response are saved but their cookie is not sent
+func (s *Sessions) Wrap(fn Handler) Handler {
+	return func(res *Response, req *Request) {
+		ss := s.load(req)
+		req.session = ss
+		res.BeforeHeaders(func(res *Response) {
+			s.save(res, ss)
+		})
+
+		fn(res, req)
+
+		// Handlers which do not write a response still have their session saved
+		s.save(res, ss)
+	}
+}
+
+// load will return the session of a request, a new session is returned if the request does not have a valid session
+func (s *Sessions) load(req *Request) (ss *Session) {
+	now := time.Now()
+	ss = &Session{}
+
+	ref, ok := req.Cookies.lookup(s.o.CookieName)
+	if !ok {
+		ss.SessionData = newSessionData(now)
+		return
+	}
+
+	sd, err := s.store.Load(ref)
+	switch {
+	case err == ErrSessionNotFound:
+	case err != nil:
+		s.l.Println(err)
+	case s.isExpired(&sd, now):
+		if err = s.store.Delete(sd.ID); err != nil {
+			s.l.Println(err)
+		}
+	default:
+		if sd.Values == nil {
+			sd.Values = make(map[string]string)
+		}
+
+		ss.SessionData = sd
+		ss.existing = true
+		return
+	}
+
+	// Our cookie no longer refers to a session, clear it unless we save a new session
+	ss.SessionData = newSessionData(now)
+	ss.stale = true
+	return
+}
+
+// isExpired will return whether or not session data has exceeded our idle or absolute timeout
+func (s *Sessions) isExpired(sd *SessionData, now time.Time) bool {
+	if s.o.IdleTimeout > 0 && now.Sub(sd.Accessed) > s.o.IdleTimeout {
+		return true
+	}
+
+	return s.o.AbsoluteTimeout > 0 && now.Sub(sd.Created) > s.o.AbsoluteTimeout
+}
+
+// ttl will return how long session data may be stored for, zero is returned if it does not expire
+func (s *Sessions) ttl(sd *SessionData, now time.Time) (ttl time.Duration) {
+	ttl = s.o.IdleTimeout
+	if s.o.AbsoluteTimeout > 0 {
+		if rem := sd.Created.Add(s.o.AbsoluteTimeout).Sub(now); ttl == 0 || rem < ttl {
+			ttl = rem
+		}
+	}
+
+	return
+}
+
+// save will save a session and set (or delete) its cookie
+// Note: Sessions are only saved once, additional calls are ignored
+func (s *Sessions) save(res *Response, ss *Session) {
+	if ss.saved {
+		return
+	}
+
+	ss.saved = true
+	if ss.oldID != "" {
+		// Session has been regenerated, its previous ID must no longer be usable
+		if err := s.store.Delete(ss.oldID); err != nil {
+			s.l.Println(err)
+		}
+	}
+
+	if ss.destroyed {
+		if ss.existing && ss.ID != "" {
+			if err := s.store.Delete(ss.ID); err != nil {
+				s.l.Println(err)
+			}
+		}
+
+		s.deleteCookie(res)
+		return
+	}
+
+	if !ss.changed && !(ss.existing && s.o.IdleTimeout > 0) {
+		// Nothing to save, existing sessions are only saved when changed or when their idle timeout must be extended
+		if ss.stale {
+			s.deleteCookie(res)
+		}
+
+		return
+	}
+
+	now := time.Now()
+	if ss.ID == "" {
+		var err error
+		if ss.ID, err = newSessionID(); err != nil {
+			s.l.Println(err)
+			return
+		}
+	}
+
+	ss.Accessed = now
+	ref, err := s.store.Save(ss.SessionData, s.ttl(&ss.SessionData, now))
+	if err != nil {
+		s.l.Println(err)
+		return
+	}
+
+	ck := s.cookie(ref)
+	if s.o.AbsoluteTimeout > 0 {
+		// Persist the cookie until the session's absolute expiry
+		ck.MaxAge = int(s.ttl(&ss.SessionData, now) / time.Second)
+	}
+
+	if err = res.Cookies.SetCookie(ck); err != nil {
+		s.l.Println(err)
+	}
+}
+
+// cookie will return a session cookie with the provided value
+func (s *Sessions) cookie(val string) Cookie {
+	return Cookie{
+		Key:      s.o.CookieName,
+		Val:      val,
+		Path:     s.o.Path,
+		Domain:   s.o.Domain,
+		Secure:   s.o.Secure,
+		HttpOnly: true,
+		SameSite: s.o.SameSite,
+	}
+}
+
+// deleteCookie will delete the session cookie
+func (s *Sessions) deleteCookie(res *Response) {
+	if err := res.Cookies.Delete(s.o.CookieName, s.o.Path, s.o.Domain); err != nil {
+		s.l.Println(err)
+	}
+}
+
+// newSessionID will return a new random session ID
+func newSessionID() (id string, err error) {
+	b := make([]byte, sessionIDLen)
+	if _, err = io.ReadFull(rand.Reader, b); err != nil {
+		return
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// isSessionID will return whether or not a string is a well-formed session ID
+func isSessionID(id string) bool {
+	if len(id) != base64.RawURLEncoding.EncodedLen(sessionIDLen) {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if b := id[i]; !(b >= 'a' && b <= 'z') && !(b >= 'A' && b <= 'Z') && !(b >= '0' && b <= '9') && b != '-' && b != '_' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newSessionData will return empty session data created at the provided time
+func newSessionData(now time.Time) SessionData {
+	return SessionData{
+		Values:   make(map[string]string),
+		Created:  now,
+		Accessed: now,
+	}
+}
+
+// SessionData is the stored state of a session
+type SessionData struct {
+	ID       string
+	Values   map[string]string
+	Flashes  []string
+	Created  time.Time
+	Accessed time.Time
+}
+
+// dup will return a deep copy of the session data
+func (sd SessionData) dup() SessionData {
+	values := make(map[string]string, len(sd.Values))
+	for k, v := range sd.Values {
+		values[k] = v
+	}
+
+	sd.Values = values
+	sd.Flashes = append([]string(nil), sd.Flashes...)
+	return sd
+}
+
+// Session is the session of a single request
+// Note: A Session is not safe for concurrent use
+type Session struct {
+	SessionData
+
+	// Previous ID, set once the session has been regenerated
+	oldID string
+
+	existing  bool // Session was loaded from our store
+	stale     bool // Request's session cookie did not refer to a valid session
+	changed   bool
+	destroyed bool
+	saved     bool
+}
+
+// Get will return the value matching the provided key
+func (s *Session) Get(key string) string {
+	return s.Values[key]
+}
+
+// Set will set the value of the provided key
+func (s *Session) Set(key, val string) {
+	s.Values[key] = val
+	s.changed = true
+}
+
+// Delete will remove the value of the provided key
+func (s *Session) Delete(key string) {
+	if _, ok := s.Values[key]; ok {
+		delete(s.Values, key)
+		s.changed = true
+	}
+}
+
+// Flash will add a message which is returned by PopFlashes, within this or a following request
+func (s *Session) Flash(msg string) {
+	s.Flashes = append(s.Flashes, msg)
+	s.changed = true
+}
+
+// PopFlashes will return and clear the flash messages
+func (s *Session) PopFlashes() (msgs []string) {
+	if msgs = s.Flashes; len(msgs) > 0 {
+		s.Flashes = nil
+		s.changed = true
+	}
+
+	return
+}
+
+// Regenerate will assign the session a new ID, keeping its values, and restart its absolute timeout
+// Note: Sessions should be regenerated whenever a user logs in (or their privileges change) to prevent session fixation
+func (s *Session) Regenerate() {
+	if s.existing && s.oldID == "" {
+		s.oldID = s.ID
+	}
+
+	s.ID = ""
+	s.Created = time.Now()
+	s.changed = true
+}
+
+// Destroy will delete the session and its cookie (IE: on log out)
+func (s *Session) Destroy() {
+	s.Values = make(map[string]string)
+	s.Flashes = nil
+	s.destroyed = true
+}
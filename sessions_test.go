@@ -0,0 +1,172 @@
+package webWorkers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webWorkers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sc, err := NewSecureCookies(SecureCookieEncrypt, time.Hour, bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stores := map[string]SessionStore{
+		"memory": NewMemoryStore(16),
+		"cookie": NewCookieStore(sc),
+		"file":   fs,
+	}
+
+	for name, store := range stores {
+		var step func(ss *Session)
+		s := NewSessions(store, SessionOpts{Secure: true, AbsoluteTimeout: time.Hour})
+		fn := s.Wrap(func(res *Response, req *Request) {
+			step(req.Session())
+		})
+
+		step = func(ss *Session) {
+			ss.Set("user", "1")
+			ss.Flash("welcome")
+		}
+
+		ck := serveSession(t, fn, "")
+		if ck == nil || !ck.HttpOnly || !ck.Secure || ck.SameSite != SameSiteLax || ck.MaxAge < 3599 {
+			t.Fatalf("%s: invalid session cookie, received %v", name, ck)
+		}
+
+		first := ck.Val
+		step = func(ss *Session) {
+			if ss.Get("user") != "1" {
+				t.Errorf("%s: invalid user, received \"%s\"", name, ss.Get("user"))
+			}
+
+			if msgs := ss.PopFlashes(); len(msgs) != 1 || msgs[0] != "welcome" {
+				t.Errorf("%s: invalid flashes, received %v", name, msgs)
+			}
+
+			ss.Regenerate()
+		}
+
+		if ck = serveSession(t, fn, first); ck == nil || ck.Val == first {
+			t.Fatalf("%s: session was not regenerated, received %v", name, ck)
+		}
+
+		if name != "cookie" {
+			// Regenerated IDs must no longer refer to the session
+			if _, err = store.Load(first); err != ErrSessionNotFound {
+				t.Fatalf("%s: expected ErrSessionNotFound for a regenerated ID and received %v", name, err)
+			}
+		}
+
+		step = func(ss *Session) {
+			if ss.Get("user") != "1" || len(ss.PopFlashes()) != 0 {
+				t.Errorf("%s: invalid session after regeneration, received %v", name, ss.SessionData)
+			}
+
+			ss.Destroy()
+		}
+
+		if ck = serveSession(t, fn, ck.Val); ck == nil || ck.MaxAge != -1 {
+			t.Fatalf("%s: session cookie was not deleted, received %v", name, ck)
+		}
+	}
+}
+
+func TestSessionIdleTimeout(t *testing.T) {
+	s := NewSessions(NewMemoryStore(16), SessionOpts{IdleTimeout: time.Millisecond * 50})
+	var user string
+	fn := s.Wrap(func(res *Response, req *Request) {
+		if user = req.Session().Get("user"); user == "" {
+			req.Session().Set("user", "1")
+		}
+	})
+
+	ck := serveSession(t, fn, "")
+	if ck = serveSession(t, fn, ck.Val); user != "1" {
+		t.Fatalf("invalid user, received \"%s\"", user)
+	}
+
+	time.Sleep(time.Millisecond * 100)
+	if serveSession(t, fn, ck.Val); user != "" {
+		t.Fatalf("session did not expire, received user \"%s\"", user)
+	}
+}
+
+func TestMemoryStoreEviction(t *testing.T) {
+	ms := NewMemoryStore(2)
+	for _, id := range []string{"a", "b", "c"} {
+		ms.Save(SessionData{ID: id}, 0)
+		if id == "b" {
+			// Use "a", so "b" is our least recently used session
+			ms.Load("a")
+		}
+	}
+
+	if _, err := ms.Load("b"); err != ErrSessionNotFound || ms.Len() != 2 {
+		t.Fatalf("least recently used session was not evicted (%v)", err)
+	}
+
+	ms.Save(SessionData{ID: "d"}, time.Millisecond)
+	time.Sleep(time.Millisecond * 5)
+	if _, err := ms.Load("d"); err != ErrSessionNotFound {
+		t.Fatalf("expected ErrSessionNotFound for an expired session and received %v", err)
+	}
+}
+
+func TestBeforeHeaders(t *testing.T) {
+	s := NewSessions(NewMemoryStore(16), SessionOpts{})
+	fn := s.Wrap(func(res *Response, req *Request) {
+		req.Session().Set("user", "1")
+		res.Write([]byte("hello"))
+	})
+
+	c, sc := net.Pipe()
+	done := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(c)
+		done <- b
+	}()
+
+	res := Response{conn: sc, Cookies: newCookies()}
+	fn(&res, &Request{Cookies: newCookies()})
+	sc.Close()
+
+	if b := <-done; !strings.Contains(string(b), "Set-Cookie: session=") {
+		t.Fatalf("session cookie was not sent, received \"%s\"", b)
+	}
+}
+
+// serveSession will call a handler with the provided session cookie, returning the session cookie of the response
+func serveSession(t *testing.T, fn Handler, val string) *Cookie {
+	req := Request{Cookies: newCookies()}
+	if val != "" {
+		req.Cookies.set([]byte(defaultSessionCookie + "=" + val))
+	}
+
+	res := Response{Cookies: newCookies()}
+	fn(&res, &req)
+
+	for _, ck := range res.Cookies.cks {
+		if ck.Key == defaultSessionCookie {
+			return ck
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,404 @@
+package webWorkers
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ContentTypeEventStream is the server-sent events content type
+	ContentTypeEventStream = "text/event-stream"
+
+	// sseWriteTimeout is how long a detached client may block a write before being dropped
+	sseWriteTimeout = time.Second * 5
+	// sseBacklog is the number of published events which may be queued before Publish blocks
+	sseBacklog = 32
+	// sseClientBacklog is the number of events which may be queued for a detached client before it is dropped
+	sseClientBacklog = 16
+)
+
+var (
+	heartbeatBytes = []byte(": heartbeat\n\n")
+)
+
+// Event is a server-sent event
+type Event struct {
+	// ID of the event, used by clients to resume via Last-Event-ID
+	ID string
+	// Name of the event, clients default to "message" when empty
+	Event string
+	// Data of the event, multi-line data is split into multiple data fields
+	Data string
+	// Reconnection time clients should use, zero will omit the field
+	Retry time.Duration
+}
+
+// bytes will return the wire representation of the event
+func (e *Event) bytes() (bs []byte) {
+	bs = make([]byte, 0, len(e.Data)+64)
+
+	if e.ID != "" {
+		bs = appendField(bs, "id", sanitizeField(e.ID))
+	}
+
+	if e.Event != "" {
+		bs = appendField(bs, "event", sanitizeField(e.Event))
+	}
+
+	if e.Retry > 0 {
+		bs = appendField(bs, "retry", strconv.FormatInt(int64(e.Retry/time.Millisecond), 10))
+	}
+
+	// Normalize line endings so every line becomes its own data field
+	data := strings.Replace(strings.Replace(e.Data, "\r\n", "\n", -1), "\r", "\n", -1)
+	for _, line := range strings.Split(data, "\n") {
+		bs = appendField(bs, "data", line)
+	}
+
+	return append(bs, '\n')
+}
+
+// appendField will append a single "name: value" line
+func appendField(bs []byte, name, val string) []byte {
+	bs = append(bs, name...)
+	bs = append(bs, ": "...)
+	bs = append(bs, val...)
+	return append(bs, '\n')
+}
+
+// sanitizeField will remove characters which would terminate a single-line field
+func sanitizeField(str string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == 0 {
+			return -1
+		}
+
+		return r
+	}, str)
+}
+
+// sseHeaders will write the headers of an event stream
+func (r *Response) sseHeaders() (err error) {
+	if r.headersSent {
+		return ErrHeadersSent
+	}
+
+	if len(r.statusCode) == 0 {
+		if err = r.StatusCode(StatusOK); err != nil {
+			return
+		}
+	}
+
+	if err = r.ContentType(ContentTypeEventStream); err != nil {
+		return
+	}
+
+	if err = r.Header("Cache-Control", "no-cache"); err != nil {
+		return
+	}
+
+	// Ask any intermediary proxies not to buffer our stream
+	if err = r.Header("X-Accel-Buffering", "no"); err != nil {
+		return
+	}
+
+	return r.Write(nil)
+}
+
+// SSE will send the event stream headers and return a server-sent events writer
+// Note: The writer is only valid until the handler returns, see DetachSSE for long-lived streams
+func (r *Response) SSE(heartbeat time.Duration) (s *SSE, err error) {
+	if err = r.sseHeaders(); err != nil {
+		return
+	}
+
+	s = &SSE{
+		conn: r.conn,
+		done: make(chan struct{}),
+	}
+
+	go s.watch()
+
+	if heartbeat > 0 {
+		go s.heartbeat(heartbeat)
+	}
+
+	return
+}
+
+// DetachSSE will send the event stream headers and hand the connection over to the provided broadcaster
+// Note: The worker is freed as soon as the handler returns
+func (r *Response) DetachSSE(b *Broadcaster) (err error) {
+	if err = r.sseHeaders(); err != nil {
+		return
+	}
+
+	return b.add(r.detach())
+}
+
+// SSE is a server-sent events writer
+type SSE struct {
+	mux  sync.Mutex
+	conn net.Conn
+
+	once sync.Once
+	done chan struct{}
+}
+
+// Send will write an event to the client
+func (s *SSE) Send(e Event) error {
+	return s.write(e.bytes())
+}
+
+// Comment will write a comment to the client, comments are ignored by clients
+func (s *SSE) Comment(str string) error {
+	return s.write([]byte(": " + sanitizeField(str) + "\n\n"))
+}
+
+// Done will return a channel which is closed once the client has disconnected
+func (s *SSE) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close will stop any heartbeats, the connection is closed by the worker once the handler returns
+func (s *SSE) Close() {
+	s.once.Do(func() { close(s.done) })
+}
+
+// write will write the provided bytes, closing the writer on failure
+func (s *SSE) write(b []byte) (err error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	select {
+	case <-s.done:
+		return ErrIsClosed
+	default:
+	}
+
+	if _, err = s.conn.Write(b); err != nil {
+		s.Close()
+	}
+
+	return
+}
+
+// watch will close the writer once the client has disconnected
+func (s *SSE) watch() {
+	waitForDisconnect(s.conn, s.done)
+	s.Close()
+}
+
+// waitForDisconnect will block until the client of an event stream has disconnected, or until done is closed
+// Note: Event stream clients do not send anything after their request, so any read result indicates a disconnect
+func waitForDisconnect(c net.Conn, done <-chan struct{}) {
+	st, ok := c.(*h2Stream)
+	if dc, detached := c.(*detachedConn); detached {
+		st, ok = dc.Conn.(*h2Stream)
+	}
+
+	if ok {
+		// HTTP/2 streams are finished once reset by the client or once the connection closes
+		select {
+		case <-st.done:
+		case <-done:
+		}
+
+		return
+	}
+
+	// Reads return once the client disconnects, or once we close the connection
+	var buf [1]byte
+	c.Read(buf[:])
+}
+
+// heartbeat will write a comment on the provided interval to keep intermediaries from timing out the stream
+func (s *SSE) heartbeat(interval time.Duration) {
+	tkr := time.NewTicker(interval)
+	defer tkr.Stop()
+
+	for {
+		select {
+		case <-tkr.C:
+			if s.write(heartbeatBytes) != nil {
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// NewBroadcaster will return a new Broadcaster
+// Note: Each Broadcaster maintains a running goroutine, along with writer and disconnect watching goroutines for each client
+func NewBroadcaster(heartbeat time.Duration) (b *Broadcaster) {
+	b = &Broadcaster{
+		cs:   make(map[*sseClient]struct{}),
+		evts: make(chan []byte, sseBacklog),
+		done: make(chan struct{}),
+	}
+
+	go b.run(heartbeat)
+	return
+}
+
+// Broadcaster publishes server-sent events to detached event stream connections
+// Note: Clients which fall more than sseClientBacklog events behind are dropped, so slow clients cannot stall the others
+type Broadcaster struct {
+	mux sync.Mutex
+	cs  map[*sseClient]struct{}
+
+	evts chan []byte
+	once sync.Once
+	done chan struct{}
+}
+
+// add will add a detached connection to the broadcaster
+func (b *Broadcaster) add(c net.Conn) (err error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	select {
+	case <-b.done:
+		c.Close()
+		return ErrIsClosed
+	default:
+	}
+
+	cl := &sseClient{
+		conn: c,
+		evts: make(chan []byte, sseClientBacklog),
+		done: make(chan struct{}),
+	}
+
+	b.cs[cl] = struct{}{}
+	go b.write(cl)
+	go b.watch(cl)
+	return
+}
+
+// watch will remove a client once it has disconnected, without waiting for a write to fail
+func (b *Broadcaster) watch(cl *sseClient) {
+	waitForDisconnect(cl.conn, cl.done)
+	b.remove(cl)
+}
+
+// remove will remove a client from the broadcaster and close its connection
+func (b *Broadcaster) remove(cl *sseClient) {
+	b.mux.Lock()
+	delete(b.cs, cl)
+	b.mux.Unlock()
+	cl.close()
+}
+
+// Publish will send an event to every connected client
+func (b *Broadcaster) Publish(e Event) (err error) {
+	select {
+	case b.evts <- e.bytes():
+	case <-b.done:
+		err = ErrIsClosed
+	}
+
+	return
+}
+
+// Len will return the number of connected clients
+func (b *Broadcaster) Len() (n int) {
+	b.mux.Lock()
+	n = len(b.cs)
+	b.mux.Unlock()
+	return
+}
+
+// Close will close the broadcaster and all of its connections
+func (b *Broadcaster) Close() (err error) {
+	err = ErrIsClosed
+	b.once.Do(func() {
+		close(b.done)
+		err = nil
+	})
+
+	return
+}
+
+// run will queue published events and heartbeats until the broadcaster is closed
+func (b *Broadcaster) run(heartbeat time.Duration) {
+	var tick <-chan time.Time
+	if heartbeat > 0 {
+		tkr := time.NewTicker(heartbeat)
+		defer tkr.Stop()
+		tick = tkr.C
+	}
+
+	for {
+		select {
+		case evt := <-b.evts:
+			b.broadcast(evt)
+		case <-tick:
+			// Heartbeats double as our disconnect detection, as failed writes drop the client
+			b.broadcast(heartbeatBytes)
+		case <-b.done:
+			b.mux.Lock()
+			for cl := range b.cs {
+				cl.close()
+				delete(b.cs, cl)
+			}
+			b.mux.Unlock()
+			return
+		}
+	}
+}
+
+// broadcast will queue the provided bytes for every client, dropping any clients which have fallen behind
+// Note: Connections are only written to by their client's writer goroutine, so a slow client cannot block our lock
+func (b *Broadcaster) broadcast(evt []byte) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	for cl := range b.cs {
+		select {
+		case cl.evts <- evt:
+		default:
+			// Client's backlog is full, drop it rather than block the others
+			cl.close()
+			delete(b.cs, cl)
+		}
+	}
+}
+
+// write will write queued events to a client until it fails or is closed
+func (b *Broadcaster) write(cl *sseClient) {
+	for {
+		select {
+		case evt := <-cl.evts:
+			cl.conn.SetWriteDeadline(time.Now().Add(sseWriteTimeout))
+			if _, err := cl.conn.Write(evt); err != nil {
+				b.remove(cl)
+				return
+			}
+		case <-cl.done:
+			return
+		}
+	}
+}
+
+// sseClient is a detached event stream connection of a Broadcaster
+type sseClient struct {
+	conn net.Conn
+	// Events queued for our writer goroutine
+	evts chan []byte
+
+	once sync.Once
+	done chan struct{}
+}
+
+// close will stop the client's writer and close its connection
+func (s *sseClient) close() {
+	s.once.Do(func() {
+		close(s.done)
+		s.conn.Close()
+	})
+}
@@ -0,0 +1,215 @@
+package webWorkers
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventBytes(t *testing.T) {
+	e := Event{
+		ID:    "42\n",
+		Event: "update",
+		Data:  "first\r\nsecond\nthird",
+		Retry: time.Second * 3,
+	}
+
+	expected := "id: 42\nevent: update\nretry: 3000\ndata: first\ndata: second\ndata: third\n\n"
+	if str := string(e.bytes()); str != expected {
+		t.Fatalf("invalid event, expected \"%s\" and received \"%s\"", expected, str)
+	}
+}
+
+func TestSSE(t *testing.T) {
+	b := NewBroadcaster(time.Millisecond * 20)
+	defer b.Close()
+
+	disconnected := make(chan struct{})
+	ww, err := New(Opts{WorkerCap: 2, QueueLen: 16, Address: ":11133"}, func(res *Response, req *Request) {
+		if req.Path() == "/broadcast" {
+			if err := res.DetachSSE(b); err != nil {
+				t.Error(err)
+			}
+
+			return
+		}
+
+		s, err := res.SSE(time.Millisecond * 20)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		s.Send(Event{Data: "hello"})
+		select {
+		case <-s.Done():
+			close(disconnected)
+		case <-time.After(time.Second * 5):
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ww.Close()
+
+	go ww.Listen()
+	time.Sleep(time.Millisecond * 100)
+
+	c, br := sseRequest(t, "/")
+	if str, expected := sseRead(t, br), "data: hello\n\n"; str != expected {
+		t.Fatalf("expected %q and received %q", expected, str)
+	}
+
+	if str := sseRead(t, br); str != string(heartbeatBytes) {
+		t.Fatalf("expected a heartbeat and received %q", str)
+	}
+
+	c.Close()
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("client disconnect was not detected")
+	}
+
+	c1, br1 := sseRequest(t, "/broadcast")
+	defer c1.Close()
+	c2, br2 := sseRequest(t, "/broadcast")
+	waitForLen(t, b, 2)
+
+	if err = b.Publish(Event{Event: "update", Data: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, br := range []*bufio.Reader{br1, br2} {
+		// Heartbeats may arrive before our event
+		str := sseRead(t, br)
+		if str == string(heartbeatBytes) {
+			str = sseRead(t, br)
+		}
+
+		if expected := "event: update\ndata: a\n\n"; str != expected {
+			t.Fatalf("expected %q and received %q", expected, str)
+		}
+	}
+
+	// Disconnected clients are dropped
+	c2.Close()
+	waitForLen(t, b, 1)
+
+	b.Close()
+	c1.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = ioutil.ReadAll(br1); err != nil {
+		t.Fatalf("expected our stream to be closed and received %v", err)
+	}
+}
+
+func TestBroadcasterDisconnect(t *testing.T) {
+	// Without heartbeats, disconnects must be detected without a failed write
+	b := NewBroadcaster(0)
+	defer b.Close()
+
+	c, peer := net.Pipe()
+	if err := b.add(c); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForLen(t, b, 1)
+	peer.Close()
+	waitForLen(t, b, 0)
+}
+
+func TestBroadcasterSlowClient(t *testing.T) {
+	b := NewBroadcaster(0)
+	defer b.Close()
+
+	// Pipes block writes until read, so our client never keeps up
+	c, peer := net.Pipe()
+	defer peer.Close()
+
+	if err := b.add(c); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < sseClientBacklog+2; i++ {
+			b.broadcast(heartbeatBytes)
+		}
+
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("broadcast blocked on a slow client")
+	}
+
+	if n := b.Len(); n != 0 {
+		t.Fatalf("expected our slow client to be dropped, %d clients remain", n)
+	}
+}
+
+// sseRequest will request an event stream from our test server, returning once the response header has been read
+func sseRequest(t *testing.T, path string) (c net.Conn, br *bufio.Reader) {
+	var err error
+	if c, err = net.Dial("tcp4", "localhost:11133"); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	br = bufio.NewReader(c)
+
+	var hdr string
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("invalid response header %q: %v", hdr, err)
+		}
+
+		if hdr += line; line == "\r\n" {
+			break
+		}
+	}
+
+	if !strings.HasPrefix(hdr, "HTTP/1.1 200 OK\r\n") {
+		t.Fatalf("expected 200 and received %q", hdr)
+	}
+
+	for _, h := range []string{"Content-Type: " + ContentTypeEventStream, "Cache-Control: no-cache", "X-Accel-Buffering: no"} {
+		if !strings.Contains(hdr, "\r\n"+h+"\r\n") {
+			t.Fatalf("expected %q within %q", h, hdr)
+		}
+	}
+
+	return
+}
+
+// sseRead will read a single event (or comment) from an event stream
+func sseRead(t *testing.T, br *bufio.Reader) (str string) {
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("error reading event %q: %v", str, err)
+		}
+
+		if str += line; line == "\n" {
+			return
+		}
+	}
+}
+
+// waitForLen will wait for a broadcaster to have the provided number of clients
+func waitForLen(t *testing.T, b *Broadcaster, n int) {
+	for i := 0; b.Len() != n; i++ {
+		if i == 100 {
+			t.Fatalf("expected %d clients, %d are connected", n, b.Len())
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+}
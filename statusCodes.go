@@ -2,6 +2,20 @@ package webWorkers
 
 // For the official w3 status code definitions, see https://www.w3.org/Protocols/rfc2616/rfc2616-sec10.html
 
+// Informational 1xx
+// This class of status code indicates a provisional response, consisting only of the Status-Line and optional headers.
+const (
+	// StatusContinue represents the "Continue" status
+	StatusContinue = 100
+	// StatusSwitchingProtocols represents the "Switching Protocols" status
+	StatusSwitchingProtocols = 101
+)
+
+var (
+	statusContinue           = []byte("100 Continue")
+	statusSwitchingProtocols = []byte("101 Switching Protocols")
+)
+
 // Successful 2xx
 // This class of status code indicates that the client's request was successfully received, understood, and accepted.
 const (
@@ -45,16 +59,19 @@ const (
 	StatusUseProxy = 306
 	// StatusMovedTemporarily represents the "Moved Temporarily" status
 	StatusMovedTemporarily = 307
+	// StatusPermanentRedirect represents the "Permanent Redirect" status
+	StatusPermanentRedirect = 308
 )
 
 var (
-	statusMultipleChoices  = []byte("300 Multiple Choices")
-	statusMovedPerminantly = []byte("301 Moved Perminantly")
-	statusFound            = []byte("302 Found")
-	statusSeeOther         = []byte("303 See Other")
-	statusNotModified      = []byte("304 Not Modified")
-	statusUseProxy         = []byte("306 Use Proxy")
-	statusMovedTemporarily = []byte("307 Moved Temporarily")
+	statusMultipleChoices   = []byte("300 Multiple Choices")
+	statusMovedPerminantly  = []byte("301 Moved Perminantly")
+	statusFound             = []byte("302 Found")
+	statusSeeOther          = []byte("303 See Other")
+	statusNotModified       = []byte("304 Not Modified")
+	statusUseProxy          = []byte("306 Use Proxy")
+	statusMovedTemporarily  = []byte("307 Moved Temporarily")
+	statusPermanentRedirect = []byte("308 Permanent Redirect")
 )
 
 // Client Error 4xx
@@ -92,25 +109,31 @@ const (
 	StatusExpectationFailed = 417
 	// StatusTeapot represents when a server is a tea pot.. short and stout.
 	StatusTeapot = 418
+	// StatusTooManyRequests represents the "Too Many Requests" status
+	StatusTooManyRequests = 429
+	// StatusRequestHeaderFieldsTooLarge represents the "Request Header Fields Too Large" status
+	StatusRequestHeaderFieldsTooLarge = 431
 )
 
 var (
-	statusBadRequest            = []byte("400 Moved")
-	statusUnauthorized          = []byte("401 Unauthorized")
-	statusPaymentRequired       = []byte("402 Payment Required")
-	statusForbidden             = []byte("403 Forbidden")
-	statusNotFound              = []byte("404 Not Found")
-	statusMethodNotAllowed      = []byte("405 Method Not Allowed")
-	statusNotAcceptable         = []byte("406 Not Acceptable")
-	statusProxyAuthRequired     = []byte("407 Proxy Authorization Required")
-	statusRequestTimeout        = []byte("408 Request Timeout")
-	statusConflict              = []byte("409 Conflict")
-	statusLengthRequired        = []byte("411 Length Required")
-	statusRequestEntityTooLarge = []byte("413 Request Entity Too Large")
-	statusRequestURITooLong     = []byte("414 Request-URI Too Long")
-	statusUnsupportedMediaType  = []byte("415 Unsupported Media Type")
-	statusExpectationFailed     = []byte("417 Expectation Failed")
-	statusTeapot                = []byte("418 Teapot")
+	statusBadRequest                  = []byte("400 Bad Request")
+	statusUnauthorized                = []byte("401 Unauthorized")
+	statusPaymentRequired             = []byte("402 Payment Required")
+	statusForbidden                   = []byte("403 Forbidden")
+	statusNotFound                    = []byte("404 Not Found")
+	statusMethodNotAllowed            = []byte("405 Method Not Allowed")
+	statusNotAcceptable               = []byte("406 Not Acceptable")
+	statusProxyAuthRequired           = []byte("407 Proxy Authorization Required")
+	statusRequestTimeout              = []byte("408 Request Timeout")
+	statusConflict                    = []byte("409 Conflict")
+	statusLengthRequired              = []byte("411 Length Required")
+	statusRequestEntityTooLarge       = []byte("413 Request Entity Too Large")
+	statusRequestURITooLong           = []byte("414 Request-URI Too Long")
+	statusUnsupportedMediaType        = []byte("415 Unsupported Media Type")
+	statusExpectationFailed           = []byte("417 Expectation Failed")
+	statusTeapot                      = []byte("418 Teapot")
+	statusTooManyRequests             = []byte("429 Too Many Requests")
+	statusRequestHeaderFieldsTooLarge = []byte("431 Request Header Fields Too Large")
 )
 
 // Server Error 5xx
@@ -141,6 +164,11 @@ var (
 
 func getStatusBytes(sc int) (b []byte, err error) {
 	switch sc {
+	case StatusContinue:
+		b = statusContinue
+	case StatusSwitchingProtocols:
+		b = statusSwitchingProtocols
+
 	case StatusOK:
 		b = statusOK
 	case StatusCreated:
@@ -168,6 +196,8 @@ func getStatusBytes(sc int) (b []byte, err error) {
 		b = statusUseProxy
 	case StatusMovedTemporarily:
 		b = statusMovedTemporarily
+	case StatusPermanentRedirect:
+		b = statusPermanentRedirect
 
 	case StatusBadRequest:
 		b = statusBadRequest
@@ -201,6 +231,10 @@ func getStatusBytes(sc int) (b []byte, err error) {
 		b = statusExpectationFailed
 	case StatusTeapot:
 		b = statusTeapot
+	case StatusTooManyRequests:
+		b = statusTooManyRequests
+	case StatusRequestHeaderFieldsTooLarge:
+		b = statusRequestHeaderFieldsTooLarge
 
 	case StatusInternalServerError:
 		b = statusInternalServerError
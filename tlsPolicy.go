@@ -0,0 +1,210 @@
+package webWorkers
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"io/ioutil"
+	"time"
+
+	"github.com/missionMeteora/toolkit/errors"
+)
+
+const (
+	// ticketKeyLen is the length of a session ticket key
+	ticketKeyLen = 32
+	// maxALPNLen is the maximum length of an ALPN protocol name
+	maxALPNLen = 255
+)
+
+var (
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+
+	tlsCurves = map[string]tls.CurveID{
+		"X25519": tls.X25519,
+		"P256":   tls.CurveP256,
+		"P384":   tls.CurveP384,
+		"P521":   tls.CurveP521,
+	}
+)
+
+// parseTLSVersion will return the TLS version matching the provided string (IE: "1.2")
+// Note: An empty string returns zero, which leaves the version to the crypto/tls defaults
+func parseTLSVersion(str string) (v uint16, err error) {
+	if str == "" {
+		return
+	}
+
+	var ok bool
+	if v, ok = tlsVersions[str]; !ok {
+		err = ErrInvalidTLSVersion
+	}
+
+	return
+}
+
+// parseCipherSuites will return the cipher suite IDs matching the provided names (IE: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256")
+// Note: Only suites considered secure by crypto/tls are accepted, TLS 1.3 suites are not configurable and are always enabled
+func parseCipherSuites(names []string) (ids []uint16, err error) {
+	suites := tls.CipherSuites()
+	for _, name := range names {
+		var found bool
+		for _, cs := range suites {
+			if cs.Name != name {
+				continue
+			}
+
+			ids = append(ids, cs.ID)
+			found = true
+			break
+		}
+
+		if !found {
+			return nil, ErrInvalidCipherSuite
+		}
+	}
+
+	return
+}
+
+// parseCurves will return the curve IDs matching the provided names (IE: "X25519")
+func parseCurves(names []string) (ids []tls.CurveID, err error) {
+	for _, name := range names {
+		id, ok := tlsCurves[name]
+		if !ok {
+			return nil, ErrInvalidCurve
+		}
+
+		ids = append(ids, id)
+	}
+
+	return
+}
+
+// validateTLSPolicy will append any errors with the TLS policy of the provided Opts
+func validateTLSPolicy(o *Opts, errs *errors.ErrorList) {
+	var (
+		minV, maxV uint16
+		err        error
+	)
+
+	if minV, err = parseTLSVersion(o.TLSMinVersion); err != nil {
+		errs.Append(err)
+	}
+
+	if maxV, err = parseTLSVersion(o.TLSMaxVersion); err != nil {
+		errs.Append(err)
+	}
+
+	if minV > 0 && maxV > 0 && minV > maxV {
+		// Minimum version is greater than our maximum version, append ErrInvalidTLSVersionRange
+		errs.Append(ErrInvalidTLSVersionRange)
+	}
+
+	if _, err = parseCipherSuites(o.TLSCiphers); err != nil {
+		errs.Append(err)
+	}
+
+	if _, err = parseCurves(o.TLSCurves); err != nil {
+		errs.Append(err)
+	}
+
+	if o.DisableSessionTickets && len(o.TicketKeys) > 0 {
+		// Ticket keys have no use when session tickets are disabled, append ErrTicketKeysDisabled
+		errs.Append(ErrTicketKeysDisabled)
+	}
+
+	for _, proto := range o.ALPN {
+		if len(proto) == 0 || len(proto) > maxALPNLen {
+			// ALPN protocol names must be between 1 and 255 bytes, append ErrInvalidALPN
+			errs.Append(ErrInvalidALPN)
+		} else if proto == h2Proto && !o.HTTP2 {
+			// Advertising HTTP/2 without serving it would break clients, append ErrALPNRequiresHTTP2
+			errs.Append(ErrALPNRequiresHTTP2)
+		}
+	}
+}
+
+// applyTLSPolicy will apply the TLS policy of the provided Opts to a TLS configuration
+// Note: Opts are expected to have been validated
+func applyTLSPolicy(o *Opts, tc *tls.Config) {
+	tc.MinVersion, _ = parseTLSVersion(o.TLSMinVersion)
+	tc.MaxVersion, _ = parseTLSVersion(o.TLSMaxVersion)
+	tc.CipherSuites, _ = parseCipherSuites(o.TLSCiphers)
+	tc.CurvePreferences, _ = parseCurves(o.TLSCurves)
+	tc.SessionTicketsDisabled = o.DisableSessionTickets
+
+	switch {
+	case len(o.ALPN) > 0:
+		tc.NextProtos = o.ALPN
+	case o.HTTP2:
+		// Advertise HTTP/2 via ALPN, clients which do not support it will fall back to HTTP/1.1
+		tc.NextProtos = []string{h2Proto, "http/1.1"}
+	}
+}
+
+// loadTicketKeys will load the session ticket keys from the provided files
+// Each file must contain a 32 byte key, either raw or base64 encoded (IE: "openssl rand -base64 32")
+func loadTicketKeys(files []string) (keys [][ticketKeyLen]byte, err error) {
+	var b []byte
+	keys = make([][ticketKeyLen]byte, len(files))
+	for i, file := range files {
+		if b, err = ioutil.ReadFile(file); err != nil {
+			return
+		}
+
+		if len(b) != ticketKeyLen {
+			if b, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(b))); err != nil || len(b) != ticketKeyLen {
+				return nil, ErrInvalidTicketKey
+			}
+		}
+
+		copy(keys[i][:], b)
+	}
+
+	return
+}
+
+// ReloadTicketKeys will reload the session ticket keys from their files
+// The first key is used to encrypt new tickets, the remaining keys are only used to decrypt existing tickets
+// Note: To rotate keys without invalidating existing sessions, prepend the new key file and keep the previous files listed
+func (ww *Webworkers) ReloadTicketKeys() (err error) {
+	if ww.tc == nil {
+		return ErrTLSDisabled
+	}
+
+	if len(ww.o.TicketKeys) == 0 {
+		// No ticket keys are configured, crypto/tls manages and rotates its own keys
+		return
+	}
+
+	var keys [][ticketKeyLen]byte
+	if keys, err = loadTicketKeys(ww.o.TicketKeys); err != nil {
+		// Keep our existing keys
+		return
+	}
+
+	ww.tc.SetSessionTicketKeys(keys)
+	return
+}
+
+// watchTicketKeys will reload the session ticket keys on the provided interval until the instance is closed
+func (ww *Webworkers) watchTicketKeys(interval time.Duration) {
+	tkr := time.NewTicker(interval)
+	defer tkr.Stop()
+
+	for range tkr.C {
+		if ww.isClosed() {
+			return
+		}
+
+		if err := ww.ReloadTicketKeys(); err != nil {
+			ww.l.Println(err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package webWorkers
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTLSPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webWorkers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tp := writeTestCert(t, dir, "localhost")
+	key := make([]byte, ticketKeyLen)
+	rand.Read(key)
+
+	keyFile := filepath.Join(dir, "ticket.key")
+	if err = ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := `workerCap = 1
+queueLen = 16
+address = ":11117"
+tls = true
+tlsMinVersion = "1.2"
+tlsMaxVersion = "1.2"
+tlsCiphers = TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256
+tlsCurves = X25519, P256
+ticketKeys = ` + keyFile + `
+alpn = http/1.1
+
+[certification]
+crt = "` + tp.CRT + `"
+key = "` + tp.Key + `"
+`
+
+	opts, err := NewOpts([]byte(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(opts.TLSCiphers) != 2 || len(opts.TLSCurves) != 2 || len(opts.TicketKeys) != 1 || len(opts.ALPN) != 1 {
+		t.Fatalf("invalid options: %+v", opts)
+	}
+
+	ww, err := New(opts, func(res *Response, req *Request) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go ww.Listen()
+	time.Sleep(time.Millisecond * 100)
+
+	c, err := tls.Dial("tcp", "localhost:11117", &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := c.ConnectionState()
+	c.Close()
+
+	if state.Version != tls.VersionTLS12 || state.CipherSuite != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Fatalf("invalid connection state, received version %x and cipher suite %x", state.Version, state.CipherSuite)
+	}
+
+	if c, err = tls.Dial("tcp", "localhost:11117", &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS13}); err == nil {
+		c.Close()
+		t.Fatal("expected TLS 1.3 handshake to fail")
+	}
+}
+
+func TestTLSPolicyValidation(t *testing.T) {
+	tests := map[string]Opts{
+		"version": {TLSMinVersion: "1.4"},
+		"range":   {TLSMinVersion: "1.3", TLSMaxVersion: "1.2"},
+		"cipher":  {TLSCiphers: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		"curve":   {TLSCurves: []string{"P192"}},
+		"tickets": {DisableSessionTickets: true, TicketKeys: []string{"ticket.key"}},
+		"alpn":    {ALPN: []string{""}},
+		"alpn h2": {ALPN: []string{"h2", "http/1.1"}},
+	}
+
+	for name, o := range tests {
+		o.WorkerCap = 1
+		o.QueueLen = 1
+		o.Address = ":0"
+		o.TLS = true
+		if err := o.validate(); err == nil {
+			t.Errorf("expected an error for invalid %s", name)
+		}
+	}
+}
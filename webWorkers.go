@@ -2,7 +2,6 @@ package webWorkers
 
 import (
 	"crypto/tls"
-	"crypto/x509"
 	"log"
 	"net"
 	"sync"
@@ -38,6 +37,180 @@ const (
 
 	// ErrInvalidStatusCode is returned when an invalid status code is provided
 	ErrInvalidStatusCode = errors.Error("invalid status code")
+
+	// ErrTLSDisabled is returned when a TLS action is attempted on an instance without TLS enabled
+	ErrTLSDisabled = errors.Error("tls is not enabled")
+
+	// ErrInvalidClientAuth is returned when an unsupported client auth policy is provided
+	ErrInvalidClientAuth = errors.Error("invalid client auth policy")
+
+	// ErrEmptyClientCAs is returned when client certificates are verified without any client CAs
+	ErrEmptyClientCAs = errors.Error("client CAs must be provided to verify client certificates")
+
+	// ErrInvalidClientCA is returned when a client CA file does not contain any PEM certificates
+	ErrInvalidClientCA = errors.Error("client CA file does not contain any certificates")
+
+	// ErrInvalidTLSVersion is returned when an unsupported TLS version is provided
+	ErrInvalidTLSVersion = errors.Error("invalid tls version, expected one of 1.0, 1.1, 1.2 or 1.3")
+
+	// ErrInvalidTLSVersionRange is returned when the minimum TLS version is greater than the maximum TLS version
+	ErrInvalidTLSVersionRange = errors.Error("minimum tls version cannot be greater than maximum tls version")
+
+	// ErrInvalidCipherSuite is returned when an unknown or insecure cipher suite is provided
+	ErrInvalidCipherSuite = errors.Error("invalid cipher suite")
+
+	// ErrInvalidCurve is returned when an unsupported curve is provided
+	ErrInvalidCurve = errors.Error("invalid curve, expected one of X25519, P256, P384 or P521")
+
+	// ErrTicketKeysDisabled is returned when ticket keys are provided while session tickets are disabled
+	ErrTicketKeysDisabled = errors.Error("ticket keys cannot be used when session tickets are disabled")
+
+	// ErrInvalidTicketKey is returned when a ticket key file does not contain a 32 byte key
+	ErrInvalidTicketKey = errors.Error("ticket key must be 32 bytes, raw or base64 encoded")
+
+	// ErrInvalidALPN is returned when an ALPN protocol is empty or longer than 255 bytes
+	ErrInvalidALPN = errors.Error("alpn protocols must be between 1 and 255 bytes")
+
+	// ErrInvalidHTTP2IdleTimeout is returned when a negative HTTP/2 idle timeout is provided
+	ErrInvalidHTTP2IdleTimeout = errors.Error("HTTP/2 idle timeout cannot be negative")
+
+	// ErrALPNRequiresHTTP2 is returned when "h2" is advertised via ALPN while HTTP/2 is disabled
+	ErrALPNRequiresHTTP2 = errors.Error("alpn cannot include h2 unless http2 is enabled")
+
+	// ErrOCSPNoIssuer is returned when an OCSP response cannot be validated as a certificate chain does not include its issuer
+	ErrOCSPNoIssuer = errors.Error("certificate chain must include its issuer to staple OCSP responses")
+
+	// ErrOCSPNotGood is returned when an OCSP response does not report a good status
+	ErrOCSPNotGood = errors.Error("ocsp response status is not good")
+
+	// ErrOCSPExpired is returned when an OCSP response is not currently valid
+	ErrOCSPExpired = errors.Error("ocsp response is expired or not yet valid")
+
+	// ErrInvalidDevCA is returned when a development CA within DevCertDir cannot be parsed
+	ErrInvalidDevCA = errors.Error("invalid development CA")
+
+	// ErrInvalidCIDR is returned when an invalid CIDR (or IP) is provided
+	ErrInvalidCIDR = errors.Error("invalid CIDR")
+
+	// ErrEmptyProxyTrusted is returned when the PROXY protocol is enabled without any trusted sources
+	ErrEmptyProxyTrusted = errors.Error("trusted sources must be provided to accept PROXY protocol headers")
+
+	// ErrInvalidProxyHeader is returned when a trusted source does not send a valid PROXY protocol header
+	ErrInvalidProxyHeader = errors.Error("invalid PROXY protocol header")
+
+	// ErrInvalidHostPattern is returned when an invalid virtual host pattern (or a nil handler) is provided
+	ErrInvalidHostPattern = errors.Error("invalid host pattern")
+
+	// ErrInvalidListener is returned when an invalid listener address is provided
+	ErrInvalidListener = errors.Error("invalid listener address")
+
+	// ErrInvalidSocketMode is returned when an invalid unix socket mode is provided
+	ErrInvalidSocketMode = errors.Error("invalid unix socket mode, expected an octal mode (IE: 0660)")
+
+	// ErrNotSocket is returned when a unix socket path exists and is not a socket
+	ErrNotSocket = errors.Error("unix socket path exists and is not a socket")
+
+	// ErrSocketInUse is returned when a unix socket is still accepting connections from another process
+	ErrSocketInUse = errors.Error("unix socket is in use")
+
+	// ErrInvalidRedirectStatus is returned when a redirect status other than 301 or 308 is provided
+	ErrInvalidRedirectStatus = errors.Error("invalid redirect status, expected 301 or 308")
+
+	// ErrInvalidRedirectPort is returned when a redirect port is out of range
+	ErrInvalidRedirectPort = errors.Error("invalid redirect port")
+
+	// ErrInvalidCookieName is returned when a cookie name is empty or contains characters outside of an RFC 6265 token
+	ErrInvalidCookieName = errors.Error("invalid cookie name")
+
+	// ErrInvalidCookieValue is returned when a cookie value contains characters outside of an RFC 6265 cookie-octet
+	ErrInvalidCookieValue = errors.Error("invalid cookie value")
+
+	// ErrInvalidCookieAttr is returned when a cookie path or domain contains invalid characters
+	ErrInvalidCookieAttr = errors.Error("invalid cookie attribute")
+
+	// ErrInvalidSameSite is returned when an unsupported SameSite policy is provided
+	ErrInvalidSameSite = errors.Error("invalid SameSite policy, expected one of Lax, Strict or None")
+
+	// ErrInsecureCookie is returned when a SameSite=None or Partitioned cookie is not Secure
+	ErrInsecureCookie = errors.Error("SameSite=None and Partitioned cookies must be Secure")
+
+	// ErrCookieNotFound is returned when a requested cookie does not exist
+	ErrCookieNotFound = errors.Error("cookie not found")
+
+	// ErrInvalidSecureCookieMode is returned when an unsupported secure cookie mode is provided
+	ErrInvalidSecureCookieMode = errors.Error("invalid secure cookie mode")
+
+	// ErrEmptySecureCookieKeys is returned when a secure cookie keyring is empty
+	ErrEmptySecureCookieKeys = errors.Error("secure cookies require at least one key")
+
+	// ErrInvalidSecureCookieKey is returned when a secure cookie key is not 32 bytes
+	ErrInvalidSecureCookieKey = errors.Error("secure cookie keys must be 32 bytes")
+
+	// ErrInvalidSecureCookie is returned when a secure cookie value is malformed, tampered with, or encoded by an unknown key
+	ErrInvalidSecureCookie = errors.Error("invalid secure cookie")
+
+	// ErrSecureCookieExpired is returned when a secure cookie value has exceeded its max age
+	ErrSecureCookieExpired = errors.Error("secure cookie has expired")
+
+	// ErrSessionNotFound is returned when a session does not exist or has expired
+	ErrSessionNotFound = errors.Error("session not found")
+
+	// ErrInvalidSessionID is returned when a session ID is malformed
+	ErrInvalidSessionID = errors.Error("invalid session ID")
+
+	// ErrSessionTooLarge is returned when a session is too large to be stored within a cookie
+	ErrSessionTooLarge = errors.Error("session is too large to be stored within a cookie")
+
+	// ErrInvalidCSRFMode is returned when an unsupported CSRF mode is provided
+	ErrInvalidCSRFMode = errors.Error("invalid CSRF mode")
+
+	// ErrCSRFNoSession is returned when synchronizer CSRF tokens are used without a session
+	ErrCSRFNoSession = errors.Error("synchronizer CSRF tokens require the handler to be wrapped by Sessions.Wrap")
+
+	// ErrInvalidRateLimit is returned when a rate limit does not have a positive limit and window, or has an unsupported algorithm
+	ErrInvalidRateLimit = errors.Error("invalid rate limit")
+
+	// ErrInvalidMaxConns is returned when a negative maximum number of connections per IP is provided
+	ErrInvalidMaxConns = errors.Error("maximum connections per IP cannot be negative")
+
+	// ErrConnRejected is returned when reading from (or writing to) a connection rejected by our access lists or connection limits
+	ErrConnRejected = errors.Error("connection rejected")
+
+	// ErrInvalidRequestLimit is returned when a negative request limit is provided
+	ErrInvalidRequestLimit = errors.Error("request limits cannot be negative")
+
+	// ErrHeaderTooLarge is returned when a request header exceeds the maximum number of header bytes
+	ErrHeaderTooLarge = errors.Error("request header too large")
+
+	// ErrHeaderTimeout is returned when a request header is not received within the read header timeout
+	ErrHeaderTimeout = errors.Error("timed out reading request header")
+
+	// ErrURITooLong is returned when a request URI exceeds the maximum URI length
+	ErrURITooLong = errors.Error("request URI too long")
+
+	// ErrBodyTooLarge is returned when reading a request body beyond the maximum number of body bytes
+	ErrBodyTooLarge = errors.Error("request body too large")
+
+	// ErrTooManyHeaders is returned when a request exceeds the maximum number of headers
+	ErrTooManyHeaders = errors.Error("too many request headers")
+
+	// ErrUnsupportedHTTPVersion is returned when a request uses an HTTP version other than HTTP/1.0 or HTTP/1.1
+	ErrUnsupportedHTTPVersion = errors.Error("unsupported HTTP version")
+
+	// ErrUnknownMethod is returned when a request uses a method which is not within our allowed methods
+	ErrUnknownMethod = errors.Error("unknown method")
+
+	// ErrInvalidAllowedMethod is returned when an allowed method is not a valid method token
+	ErrInvalidAllowedMethod = errors.Error("allowed methods must be valid tokens")
+
+	// ErrInvalidHost is returned when a request's host is missing, duplicated, or invalid
+	ErrInvalidHost = errors.Error("missing, duplicated or invalid host")
+
+	// ErrUnsupportedExpectation is returned when a request's expectation cannot be met
+	ErrUnsupportedExpectation = errors.Error("unsupported expectation")
+
+	// ErrInvalidHeader is returned when a header key or value contains invalid characters
+	ErrInvalidHeader = errors.Error("invalid header")
 )
 
 const (
@@ -55,19 +228,30 @@ func New(o Opts, fn Handler) (ww *Webworkers, err error) {
 	ww = &Webworkers{
 		w: make(workers, o.WorkerCap),
 		q: make(queue, o.QueueLen),
-		l: log.New(o.ErrorOutput, "webWorkers ("+o.Address+"): ", log.Ldate|log.Ltime),
+		l: log.New(o.ErrorOutput, "webWorkers ("+listenerNames(&o)+"): ", log.Ldate|log.Ltime),
+		o: o,
 
-		addr: o.Address,
+		addr:  o.Address,
+		conns: newConnCounts(),
+		done:  make(chan struct{}),
 	}
 
+	// Our access lists have been validated by o.validate
+	al, _ := newAccessList(&o)
+	ww.access.Store(al)
+
 	if o.TLS {
-		if err = ww.initTLS(o.Certs); err != nil {
+		if err = ww.initTLS(&ww.o); err != nil {
 			return
 		}
 	}
 
 	for i := range ww.w {
-		ww.w[i] = newWorker(ww.q, &ww.wg, ww.l, fn)
+		ww.w[i] = newWorker(ww.q, &ww.wg, ww.l, &ww.o, fn)
+	}
+
+	if path, ok := o.src.(string); ok && o.AccessReloadInterval > 0 {
+		go ww.watchAccess(path, o.AccessReloadInterval)
 	}
 
 	return
@@ -80,11 +264,32 @@ type Webworkers struct {
 	w workers
 	q queue
 	l *log.Logger
+	o Opts
 
 	// TLS configuration
 	tc *tls.Config
+	// Current certificates (*certStore), swapped atomically on reload
+	certs atomic.Value
+	// Certificate reload mutex
+	cmux sync.Mutex
+	// Modification times of our certificate files as of the last reload
+	cmtimes mtimes
 	// Listening address
 	addr string
+	// Listeners mutex, guards our listeners
+	lmux sync.Mutex
+	// Open listeners
+	lsts []net.Listener
+	// Connection rate limiter (*RateLimiter), checked before connections are queued
+	connLimiter atomic.Value
+	// Current access lists (*accessList), swapped atomically on reload
+	access atomic.Value
+	// Open connections per client IP
+	conns *connCounts
+	// Queue mutex, our queue is only closed once no connections are being pushed to it
+	qmux sync.RWMutex
+	// Closed once we are closed, releasing connections waiting on a full queue
+	done chan struct{}
 	// Closed state
 	cs int32
 }
@@ -99,69 +304,121 @@ func (ww *Webworkers) isClosed() bool {
 	return atomic.LoadInt32(&ww.cs) == stateClosed
 }
 
-func (ww *Webworkers) initTLS(tps []TLSPair) (err error) {
-	var crt tls.Certificate
+func (ww *Webworkers) initTLS(o *Opts) (err error) {
+	var (
+		cs   *certStore
+		crts []tls.Certificate
+	)
+
 	ww.tc = &tls.Config{
 		InsecureSkipVerify: false,
-		RootCAs:            x509.NewCertPool(),
-		Certificates:       make([]tls.Certificate, 0, 2),
+		ClientAuth:         o.clientAuthType(),
+		// Certificates are selected per-connection using SNI, see getCertificate
+		GetCertificate: ww.getCertificate,
 	}
 
-	for _, tp := range tps {
-		if crt, err = tls.LoadX509KeyPair(tp.CRT, tp.Key); err != nil {
-			ww.l.Println(err)
-			continue
+	applyTLSPolicy(o, ww.tc)
+
+	if o.DevCerts && len(o.Certs) == 0 {
+		if crts, err = ww.loadDevCerts(o); err != nil {
+			return
 		}
 
-		ww.tc.Certificates = append(ww.tc.Certificates, crt)
+		cs, err = newCertStore(crts)
+	} else {
+		cs, err = ww.newPairStore(o.Certs, nil)
+	}
+
+	if err != nil {
+		return
 	}
 
-	if len(ww.tc.Certificates) == 0 {
-		return ErrEmptyCerts
+	if len(o.ClientCAs) > 0 {
+		if ww.tc.ClientCAs, err = loadClientCAs(o.ClientCAs); err != nil {
+			return
+		}
 	}
 
-	ww.tc.BuildNameToCertificate()
-	return nil
-}
+	ww.certs.Store(cs)
+	ww.cmtimes = certMTimes(o.src, o.Certs)
 
-func (ww *Webworkers) newListener() (lst net.Listener, err error) {
-	if ww.tc == nil {
-		return net.Listen("tcp", ww.addr)
+	if len(o.TicketKeys) > 0 {
+		if err = ww.ReloadTicketKeys(); err != nil {
+			return
+		}
+	}
+
+	if o.CertReloadInterval > 0 {
+		go ww.watchCerts(o.CertReloadInterval)
+	}
+
+	if o.TicketKeyReloadInterval > 0 && len(o.TicketKeys) > 0 {
+		go ww.watchTicketKeys(o.TicketKeyReloadInterval)
+	}
+
+	if o.OCSPRefreshInterval > 0 {
+		go ww.watchOCSP(o.OCSPRefreshInterval)
 	}
 
-	return tls.Listen("tcp", ww.addr, ww.tc)
+	return
 }
 
-// Listen will begin the listening loop
+// Listen will begin the listening loop of every listener
+// Note: Listen blocks until the instance is closed
 func (ww *Webworkers) Listen() (err error) {
 	if !atomic.CompareAndSwapInt32(&ww.cs, stateReady, stateListening) {
 		return ErrIsListening
 	}
 
-	var lst net.Listener
-	if lst, err = ww.newListener(); err != nil {
+	var lsts []net.Listener
+	if lsts, err = ww.newListeners(); err != nil {
 		return
 	}
 
-	for {
-		var c net.Conn
-		if c, err = lst.Accept(); err != nil {
-			ww.l.Println(err)
-			goto ITERATIONEND
-		}
+	ww.lmux.Lock()
+	ww.lsts = lsts
+	ww.lmux.Unlock()
 
-		ww.q <- c
+	if ww.isClosed() {
+		// We were closed while opening our listeners
+		ww.closeListeners()
+		return
+	}
 
-	ITERATIONEND:
-		if ww.isClosed() {
-			break
-		}
+	var wg sync.WaitGroup
+	for _, lst := range lsts {
+		wg.Add(1)
+		go func(lst net.Listener) {
+			ww.accept(lst)
+			wg.Done()
+		}(lst)
 	}
 
-	lst.Close()
+	wg.Wait()
 	return
 }
 
+// LimitConns will limit the rate of new connections per client IP, connections exceeding the limit are closed before they are queued
+// Note: Connections are limited by the IP of their peer (or PROXY protocol header), forwarding headers are not considered. A nil RateLimiter removes the limit
+// Unix socket connections do not have an IP, they share a single limit
+func (ww *Webworkers) LimitConns(rl *RateLimiter) {
+	ww.connLimiter.Store(rl)
+}
+
+// closeListeners will close all of our listeners
+func (ww *Webworkers) closeListeners() {
+	ww.lmux.Lock()
+	defer ww.lmux.Unlock()
+
+	for _, lst := range ww.lsts {
+		if err := lst.Close(); err != nil {
+			ww.l.Println(err)
+		}
+	}
+
+	ww.lsts = nil
+}
+
 // Close will close an instance of web workers
 func (ww *Webworkers) Close() (err error) {
 	if atomic.SwapInt32(&ww.cs, stateClosed) == stateClosed {
@@ -169,7 +426,13 @@ func (ww *Webworkers) Close() (err error) {
 		return ErrIsClosed
 	}
 
-	// Close queue channel
+	// Close our listeners, so our accept loops return
+	ww.closeListeners()
+	close(ww.done)
+
+	// Close queue channel, once any in-progress pushes have finished
+	ww.qmux.Lock()
 	close(ww.q)
+	ww.qmux.Unlock()
 	return
 }
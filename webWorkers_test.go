@@ -4,8 +4,11 @@ import (
 	//	"fmt"
 	"errors"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -26,6 +29,7 @@ func TestMain(m *testing.M) {
 	s := &srv{}
 	go initWW(s)
 	go initStdLib(s)
+	go initWebSocket()
 
 	time.Sleep(time.Second)
 	sc := m.Run()
@@ -39,6 +43,94 @@ func TestBasic(t *testing.T) {
 	}
 }
 
+func TestExpectationFailed(t *testing.T) {
+	var (
+		c   net.Conn
+		buf [256]byte
+		n   int
+		err error
+	)
+
+	if c, err = net.Dial("tcp", "localhost:11110"); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err = c.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nExpect: 200-ok\r\nContent-Length: 4\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err = io.ReadAtLeast(c, buf[:], 12); err != nil {
+		t.Fatal(err)
+	}
+
+	if str := string(buf[:n]); !strings.HasPrefix(str, "HTTP/1.1 417 Expectation Failed\r\n") {
+		t.Fatalf("invalid response, expected 417 and received \"%s\"", str)
+	}
+}
+
+func TestExpectContinue(t *testing.T) {
+	proceed := make(chan struct{})
+	ww, err := New(Opts{WorkerCap: 1, QueueLen: 16, Address: ":11135"}, func(res *Response, req *Request) {
+		if req.Path() == "/early" {
+			// Respond without reading the body, the client is not owed a "100 Continue"
+			res.Write([]byte("early"))
+			return
+		}
+
+		<-proceed
+		b := make([]byte, req.ContentLength())
+		io.ReadFull(req.Body, b)
+		res.Write(b)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ww.Close()
+
+	go ww.Listen()
+	time.Sleep(time.Millisecond * 100)
+
+	c, err := net.Dial("tcp", "localhost:11135")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 4\r\n\r\n"))
+	buf := make([]byte, 256)
+
+	// Our handler has not read the body yet, so nothing may be sent
+	c.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
+	if n, _ := c.Read(buf); n > 0 {
+		t.Fatalf("expected \"100 Continue\" to be sent lazily and received \"%s\"", buf[:n])
+	}
+
+	close(proceed)
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := io.ReadFull(c, buf[:len(continueResp)]); err != nil || string(buf[:n]) != string(continueResp) {
+		t.Fatalf("expected \"100 Continue\" on our first body read and received \"%s\" (%v)", buf[:n], err)
+	}
+
+	c.Write([]byte("body"))
+	if n, _ := io.ReadAtLeast(c, buf, 1); !strings.HasPrefix(string(buf[:n]), "HTTP/1.1 200 OK\r\n") || !strings.HasSuffix(string(buf[:n]), "body") {
+		t.Fatalf("invalid response, received \"%s\"", buf[:n])
+	}
+
+	early, err := net.Dial("tcp", "localhost:11135")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer early.Close()
+
+	early.Write([]byte("POST /early HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 4\r\n\r\n"))
+	early.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+	b, _ := ioutil.ReadAll(early)
+	if str := string(b); !strings.HasPrefix(str, "HTTP/1.1 200 OK\r\n") || !strings.HasSuffix(str, "early") || strings.Contains(str, "100 Continue") {
+		t.Fatalf("expected a final response without \"100 Continue\" and received \"%s\"", str)
+	}
+}
+
 func BenchmarkWWBasic(b *testing.B) {
 	var err error
 	for i := 0; i < b.N; i++ {
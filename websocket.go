@@ -0,0 +1,718 @@
+package webWorkers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/missionMeteora/toolkit/errors"
+)
+
+const (
+	// ErrInvalidHandshake is returned when a request is not a valid WebSocket handshake
+	ErrInvalidHandshake = errors.Error("invalid websocket handshake")
+
+	// ErrUnsupportedVersion is returned when a WebSocket handshake requests an unsupported version
+	ErrUnsupportedVersion = errors.Error("unsupported websocket version")
+
+	// ErrProtocolViolation is returned when a WebSocket peer violates RFC 6455
+	ErrProtocolViolation = errors.Error("websocket protocol violation")
+
+	// ErrMessageTooLarge is returned when an inbound WebSocket message exceeds the maximum message size
+	ErrMessageTooLarge = errors.Error("websocket message too large")
+
+	// ErrInvalidUTF8 is returned when an inbound WebSocket text message is not valid UTF-8
+	ErrInvalidUTF8 = errors.Error("websocket text message is not valid utf-8")
+
+	// ErrInvalidMessageType is returned when an invalid message type is provided
+	ErrInvalidMessageType = errors.Error("invalid websocket message type")
+
+	// ErrWebSocketClosed is returned when an action is attempted on a closed WebSocket
+	ErrWebSocketClosed = errors.Error("websocket is closed")
+)
+
+const (
+	// TextMessage represents a UTF-8 encoded text message
+	TextMessage MessageType = 1
+	// BinaryMessage represents a binary message
+	BinaryMessage MessageType = 2
+)
+
+const (
+	// CloseNormal represents a normal closure
+	CloseNormal = 1000
+	// CloseGoingAway represents an endpoint going away (IE: Server shutting down, browser navigating away)
+	CloseGoingAway = 1001
+	// CloseProtocolError represents a closure due to a protocol error
+	CloseProtocolError = 1002
+	// CloseUnsupportedData represents a closure due to receiving an unacceptable type of data
+	CloseUnsupportedData = 1003
+	// CloseNoStatus represents a close frame which did not contain a status code
+	// Note: This code must not be sent in a close frame
+	CloseNoStatus = 1005
+	// CloseAbnormal represents a connection which was closed without a close frame
+	// Note: This code must not be sent in a close frame
+	CloseAbnormal = 1006
+	// CloseInvalidPayload represents a closure due to data inconsistent with the message type (IE: Invalid UTF-8)
+	CloseInvalidPayload = 1007
+	// ClosePolicyViolation represents a closure due to a message which violates policy
+	ClosePolicyViolation = 1008
+	// CloseMessageTooBig represents a closure due to a message which is too large to process
+	CloseMessageTooBig = 1009
+	// CloseMandatoryExtension represents a closure due to the server not negotiating a required extension
+	CloseMandatoryExtension = 1010
+	// CloseInternalError represents a closure due to an unexpected server condition
+	CloseInternalError = 1011
+)
+
+const (
+	opContinuation byte = 0x0
+	opText         byte = 0x1
+	opBinary       byte = 0x2
+	opClose        byte = 0x8
+	opPing         byte = 0x9
+	opPong         byte = 0xA
+)
+
+const (
+	finBit  byte = 0x80
+	rsv1Bit byte = 0x40
+	rsvBits byte = 0x70
+	maskBit byte = 0x80
+)
+
+const (
+	// wsGUID is the magic value used to compute the Sec-WebSocket-Accept header
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	// wsVersion is the only supported WebSocket protocol version
+	wsVersion = "13"
+	// wsDeflate is the permessage-deflate extension token
+	wsDeflate = "permessage-deflate"
+	// wsCloseTimeout is how long we will wait for a peer to acknowledge a close frame
+	wsCloseTimeout = time.Second * 5
+	// maxControlLen is the maximum payload length for a control frame
+	maxControlLen = 125
+	// defaultWSMaxMessageSize is the default maximum size of an inbound message
+	defaultWSMaxMessageSize = 1 << 20
+)
+
+var (
+	// deflateTail is appended to compressed payloads so they may be read as a complete flate stream
+	deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+)
+
+// MessageType is the type of a WebSocket data message
+type MessageType uint8
+
+// WebSocketHandler is the func used for handling upgraded WebSocket connections
+// Note: The WebSocket is closed once the handler returns
+type WebSocketHandler func(*WebSocket)
+
+// WebSocketOpts are the options used when upgrading a connection to a WebSocket
+type WebSocketOpts struct {
+	// List of supported subprotocols, in order of preference
+	Protocols []string
+	// Whether or not the permessage-deflate extension will be negotiated
+	Compression bool
+	// Maximum size (in bytes) of an inbound message, defaults to 1MB
+	// Note: Compressed messages are limited by their decompressed size
+	MaxMessageSize int
+	// Maximum size (in bytes) of an outbound frame payload, messages larger than this will be fragmented
+	// Note: Zero will send each message as a single frame
+	MaxFrameSize int
+}
+
+// CloseError is returned when a WebSocket has been closed by the peer
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+// Error will return a string representation of the close error
+func (c *CloseError) Error() string {
+	return "websocket closed: " + strconv.Itoa(c.Code) + " " + c.Reason
+}
+
+// UpgradeWebSocket will validate the WebSocket handshake of the associated request and upgrade the connection
+// The connection is detached from the worker and the provided handler is called within its own goroutine
+func (r *Response) UpgradeWebSocket(o WebSocketOpts, fn WebSocketHandler) (err error) {
+	if r.headersSent {
+		return ErrHeadersSent
+	}
+
+	var (
+		req      = r.req
+		protocol string
+		compress bool
+	)
+
+	if err = validateHandshake(req); err != nil {
+		return
+	}
+
+	protocol = selectProtocol(req.wsProtocol, o.Protocols)
+	compress = o.Compression && acceptsDeflate(req.wsExtensions)
+
+	out := make([]byte, 0, 256)
+	out = append(out, httpType...)
+	out = append(out, ' ')
+	out = append(out, statusSwitchingProtocols...)
+	out = append(out, "\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: "...)
+	out = append(out, acceptKey(req.wsKey)...)
+	out = append(out, "\r\n"...)
+
+	if protocol != "" {
+		out = append(out, "Sec-WebSocket-Protocol: "+protocol+"\r\n"...)
+	}
+
+	if compress {
+		// We do not retain compression contexts between messages in either direction
+		out = append(out, "Sec-WebSocket-Extensions: "+wsDeflate+"; server_no_context_takeover; client_no_context_takeover\r\n"...)
+	}
+
+	out = append(out, "\r\n"...)
+
+	if _, err = r.conn.Write(out); err != nil {
+		return
+	}
+
+	r.headersSent = true
+	ws := newWebSocket(r.detach(), protocol, compress, o)
+
+	go ws.serve(fn, r.l)
+	return
+}
+
+// serve will call the provided handler, closing the WebSocket once it returns
+// Note: A panicking handler must not take down the server, the panic is logged and the peer is told we have failed
+func (ws *WebSocket) serve(fn WebSocketHandler, l *log.Logger) {
+	code := CloseInternalError
+	defer func() {
+		if v := recover(); v != nil && l != nil {
+			l.Printf("WebSocket handler panic: %v\n%s", v, debug.Stack())
+		}
+
+		ws.Close(code, "")
+	}()
+
+	fn(ws)
+	code = CloseNormal
+}
+
+// validateHandshake will return an error if the provided request is not a valid WebSocket handshake
+func validateHandshake(req *Request) error {
+	if string(req.method) != "GET" || !bytes.Equal(req.httpType, httpType) {
+		return ErrInvalidHandshake
+	}
+
+	if !hasToken(req.upgrade, "websocket") || !hasToken(req.connection, "upgrade") {
+		return ErrInvalidHandshake
+	}
+
+	if string(req.wsVersion) != wsVersion {
+		return ErrUnsupportedVersion
+	}
+
+	if key, err := base64.StdEncoding.DecodeString(string(req.wsKey)); err != nil || len(key) != 16 {
+		// Key must be a base64-encoded 16 byte value
+		return ErrInvalidHandshake
+	}
+
+	return nil
+}
+
+// acceptKey will return the Sec-WebSocket-Accept value for a provided Sec-WebSocket-Key
+func acceptKey(key []byte) string {
+	h := sha1.New()
+	h.Write(key)
+	h.Write([]byte(wsGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// selectProtocol will return the first supported subprotocol requested by the client
+func selectProtocol(requested []byte, supported []string) string {
+	for _, p := range supported {
+		if hasToken(requested, p) {
+			return p
+		}
+	}
+
+	return ""
+}
+
+// acceptsDeflate will return whether or not the client offered a permessage-deflate configuration we can honor
+func acceptsDeflate(extensions []byte) bool {
+	for _, ext := range bytes.Split(extensions, []byte{','}) {
+		params := bytes.Split(ext, []byte{';'})
+		if !bytes.EqualFold(bytes.TrimSpace(params[0]), []byte(wsDeflate)) {
+			continue
+		}
+
+		ok := true
+		for _, p := range params[1:] {
+			kv := bytes.SplitN(bytes.TrimSpace(p), []byte{'='}, 2)
+			// Our flate writer always uses a full window, we cannot honor a reduced server window
+			if bytes.EqualFold(kv[0], []byte("server_max_window_bits")) && (len(kv) < 2 || string(bytes.Trim(kv[1], `"`)) != "15") {
+				ok = false
+			}
+		}
+
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newWebSocket returns a new WebSocket
+func newWebSocket(c net.Conn, protocol string, compress bool, o WebSocketOpts) *WebSocket {
+	if o.MaxMessageSize <= 0 {
+		o.MaxMessageSize = defaultWSMaxMessageSize
+	}
+
+	return &WebSocket{
+		conn:     c,
+		br:       bufio.NewReader(c),
+		protocol: protocol,
+		compress: compress,
+		maxSize:  o.MaxMessageSize,
+		maxFrame: o.MaxFrameSize,
+	}
+}
+
+// WebSocket is an upgraded, message-oriented connection
+type WebSocket struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	// Write mutex, frames of different messages must not be interleaved
+	wmux sync.Mutex
+	fw   *flate.Writer
+	fbuf bytes.Buffer
+
+	protocol string
+	compress bool
+	maxSize  int
+	maxFrame int
+
+	closeSent bool
+	closed    bool
+}
+
+// Protocol will return the negotiated subprotocol
+func (ws *WebSocket) Protocol() string {
+	return ws.protocol
+}
+
+// RemoteAddr will return the remote network address
+func (ws *WebSocket) RemoteAddr() net.Addr {
+	return ws.conn.RemoteAddr()
+}
+
+// SetReadDeadline will set the deadline for future ReadMessage calls
+func (ws *WebSocket) SetReadDeadline(t time.Time) error {
+	return ws.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline will set the deadline for future write calls
+func (ws *WebSocket) SetWriteDeadline(t time.Time) error {
+	return ws.conn.SetWriteDeadline(t)
+}
+
+// ReadMessage will read the next data message
+// Note: Control frames are handled internally, pings are answered with pongs and close frames are acknowledged
+func (ws *WebSocket) ReadMessage() (mt MessageType, msg []byte, err error) {
+	var (
+		fin        bool
+		op         byte
+		compressed bool
+		started    bool
+		payload    []byte
+	)
+
+	for {
+		var rsv1 bool
+		if fin, rsv1, op, payload, err = ws.readFrame(); err != nil {
+			return
+		}
+
+		switch op {
+		case opPing:
+			if err = ws.writeFrame(opPong, payload, true, false); err != nil {
+				return
+			}
+
+			continue
+		case opPong:
+			continue
+		case opClose:
+			err = ws.onClose(payload)
+			return
+
+		case opText, opBinary:
+			if started {
+				// A new data message cannot begin before the previous message has finished
+				return 0, nil, ws.fail(CloseProtocolError, ErrProtocolViolation)
+			}
+
+			started = true
+			compressed = rsv1
+			mt = MessageType(op)
+			msg = msg[:0]
+
+		case opContinuation:
+			if !started || rsv1 {
+				return 0, nil, ws.fail(CloseProtocolError, ErrProtocolViolation)
+			}
+
+		default:
+			return 0, nil, ws.fail(CloseProtocolError, ErrProtocolViolation)
+		}
+
+		if len(msg)+len(payload) > ws.maxSize {
+			return 0, nil, ws.fail(CloseMessageTooBig, ErrMessageTooLarge)
+		}
+
+		msg = append(msg, payload...)
+
+		if !fin {
+			continue
+		}
+
+		if compressed {
+			if msg, err = ws.inflate(msg); err == ErrMessageTooLarge {
+				return 0, nil, ws.fail(CloseMessageTooBig, err)
+			} else if err != nil {
+				return 0, nil, ws.fail(CloseInvalidPayload, err)
+			}
+		}
+
+		if mt == TextMessage && !utf8.Valid(msg) {
+			return 0, nil, ws.fail(CloseInvalidPayload, ErrInvalidUTF8)
+		}
+
+		return
+	}
+}
+
+// WriteMessage will write a data message
+func (ws *WebSocket) WriteMessage(mt MessageType, msg []byte) (err error) {
+	if mt != TextMessage && mt != BinaryMessage {
+		return ErrInvalidMessageType
+	}
+
+	ws.wmux.Lock()
+	defer ws.wmux.Unlock()
+
+	if ws.closeSent {
+		return ErrWebSocketClosed
+	}
+
+	op := byte(mt)
+	compressed := ws.compress
+	if compressed {
+		if msg, err = ws.deflate(msg); err != nil {
+			return
+		}
+	}
+
+	for {
+		chunk := msg
+		if ws.maxFrame > 0 && len(chunk) > ws.maxFrame {
+			chunk = chunk[:ws.maxFrame]
+		}
+
+		msg = msg[len(chunk):]
+		if err = ws.writeFrameLocked(op, chunk, len(msg) == 0, compressed); err != nil {
+			return
+		}
+
+		if len(msg) == 0 {
+			return
+		}
+
+		// Subsequent frames are continuations and must not set RSV1
+		op = opContinuation
+		compressed = false
+	}
+}
+
+// Ping will send a ping control frame with the provided payload
+func (ws *WebSocket) Ping(payload []byte) error {
+	if len(payload) > maxControlLen {
+		return ErrProtocolViolation
+	}
+
+	return ws.writeFrame(opPing, payload, true, false)
+}
+
+// Close will send a close frame with the provided code and reason before closing the underlying connection
+// Note: Close may be called more than once, subsequent calls are no-ops
+func (ws *WebSocket) Close(code int, reason string) (err error) {
+	ws.wmux.Lock()
+	defer ws.wmux.Unlock()
+
+	if ws.closed {
+		return
+	}
+
+	ws.closed = true
+	if !ws.closeSent {
+		ws.closeSent = true
+		ws.conn.SetWriteDeadline(time.Now().Add(wsCloseTimeout))
+		ws.writeFrameLocked(opClose, closePayload(code, reason), true, false)
+	}
+
+	return ws.conn.Close()
+}
+
+// onClose will acknowledge a close frame sent by the peer
+func (ws *WebSocket) onClose(payload []byte) error {
+	ce := &CloseError{Code: CloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		return ws.fail(CloseProtocolError, ErrProtocolViolation)
+	case len(payload) >= 2:
+		ce.Code = int(binary.BigEndian.Uint16(payload))
+		ce.Reason = string(payload[2:])
+
+		if !isValidCloseCode(ce.Code) || !utf8.Valid(payload[2:]) {
+			return ws.fail(CloseProtocolError, ErrProtocolViolation)
+		}
+	}
+
+	ws.wmux.Lock()
+	if !ws.closeSent {
+		// Echo the status code back to the peer to complete the closing handshake
+		ws.closeSent = true
+		code := ce.Code
+		if code == CloseNoStatus {
+			code = CloseNormal
+		}
+
+		ws.writeFrameLocked(opClose, closePayload(code, ""), true, false)
+	}
+	ws.wmux.Unlock()
+
+	return ce
+}
+
+// fail will send a close frame with the provided code and return the provided error
+func (ws *WebSocket) fail(code int, err error) error {
+	ws.wmux.Lock()
+	if !ws.closeSent {
+		ws.closeSent = true
+		ws.writeFrameLocked(opClose, closePayload(code, ""), true, false)
+	}
+	ws.wmux.Unlock()
+	return err
+}
+
+// readFrame will read a single frame, returning the unmasked payload
+func (ws *WebSocket) readFrame() (fin, rsv1 bool, op byte, payload []byte, err error) {
+	var hdr [8]byte
+	if _, err = io.ReadFull(ws.br, hdr[:2]); err != nil {
+		return
+	}
+
+	fin = hdr[0]&finBit != 0
+	rsv1 = hdr[0]&rsv1Bit != 0
+	op = hdr[0] & 0x0f
+
+	if hdr[0]&rsvBits&^rsv1Bit != 0 || (rsv1 && !ws.compress) {
+		// No extension has been negotiated which defines RSV2 or RSV3
+		err = ws.fail(CloseProtocolError, ErrProtocolViolation)
+		return
+	}
+
+	if hdr[1]&maskBit == 0 {
+		// All frames sent from a client must be masked
+		err = ws.fail(CloseProtocolError, ErrProtocolViolation)
+		return
+	}
+
+	n := uint64(hdr[1] &^ maskBit)
+	switch n {
+	case 126:
+		if _, err = io.ReadFull(ws.br, hdr[:2]); err != nil {
+			return
+		}
+
+		n = uint64(binary.BigEndian.Uint16(hdr[:2]))
+	case 127:
+		if _, err = io.ReadFull(ws.br, hdr[:8]); err != nil {
+			return
+		}
+
+		if n = binary.BigEndian.Uint64(hdr[:8]); n>>63 != 0 {
+			err = ws.fail(CloseProtocolError, ErrProtocolViolation)
+			return
+		}
+	}
+
+	if op >= opClose && (n > maxControlLen || !fin || rsv1) {
+		// Control frames cannot be fragmented, compressed, or exceed 125 bytes
+		err = ws.fail(CloseProtocolError, ErrProtocolViolation)
+		return
+	}
+
+	if n > uint64(ws.maxSize) {
+		// Frames are rejected before their payload is allocated
+		err = ws.fail(CloseMessageTooBig, ErrMessageTooLarge)
+		return
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(ws.br, mask[:]); err != nil {
+		return
+	}
+
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(ws.br, payload); err != nil {
+		return
+	}
+
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return
+}
+
+// writeFrame will acquire the write lock and write a single frame
+func (ws *WebSocket) writeFrame(op byte, payload []byte, fin, compressed bool) (err error) {
+	ws.wmux.Lock()
+	defer ws.wmux.Unlock()
+
+	if ws.closeSent {
+		return ErrWebSocketClosed
+	}
+
+	return ws.writeFrameLocked(op, payload, fin, compressed)
+}
+
+// writeFrameLocked will write a single frame, the write lock must be held by the caller
+// Note: Server frames are never masked
+func (ws *WebSocket) writeFrameLocked(op byte, payload []byte, fin, compressed bool) (err error) {
+	var (
+		hdr [10]byte
+		hn  = 2
+		n   = len(payload)
+	)
+
+	hdr[0] = op
+	if fin {
+		hdr[0] |= finBit
+	}
+
+	if compressed {
+		hdr[0] |= rsv1Bit
+	}
+
+	switch {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n <= 0xffff:
+		hdr[1] = 126
+		binary.BigEndian.PutUint16(hdr[2:], uint16(n))
+		hn += 2
+	default:
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:], uint64(n))
+		hn += 8
+	}
+
+	bufs := net.Buffers{hdr[:hn], payload}
+	if _, err = bufs.WriteTo(ws.conn); err != nil {
+		ws.closeSent = true
+	}
+
+	return
+}
+
+// deflate will compress a message payload, the write lock must be held by the caller
+func (ws *WebSocket) deflate(msg []byte) (out []byte, err error) {
+	ws.fbuf.Reset()
+	if ws.fw == nil {
+		if ws.fw, err = flate.NewWriter(&ws.fbuf, flate.BestSpeed); err != nil {
+			return
+		}
+	} else {
+		ws.fw.Reset(&ws.fbuf)
+	}
+
+	if _, err = ws.fw.Write(msg); err != nil {
+		return
+	}
+
+	if err = ws.fw.Flush(); err != nil {
+		return
+	}
+
+	// Remove the empty stored block trailer produced by the flush (RFC 7692 section 7.2.1)
+	out = ws.fbuf.Bytes()
+	out = out[:len(out)-4]
+	return
+}
+
+// inflate will decompress a message payload
+func (ws *WebSocket) inflate(msg []byte) (out []byte, err error) {
+	fr := flate.NewReader(io.MultiReader(bytes.NewReader(msg), bytes.NewReader(deflateTail)))
+	defer fr.Close()
+
+	// Read one byte beyond our limit so we can detect oversized messages
+	r := io.LimitReader(fr, int64(ws.maxSize)+1)
+
+	var buf bytes.Buffer
+	if _, err = buf.ReadFrom(r); err != nil {
+		return
+	}
+
+	if buf.Len() > ws.maxSize {
+		return nil, ErrMessageTooLarge
+	}
+
+	return buf.Bytes(), nil
+}
+
+// closePayload will return the payload of a close frame
+func closePayload(code int, reason string) (p []byte) {
+	if code == CloseNoStatus || code == CloseAbnormal {
+		// These codes are reserved for local use and may not be sent
+		return
+	}
+
+	if len(reason) > maxControlLen-2 {
+		reason = reason[:maxControlLen-2]
+	}
+
+	p = make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(p, uint16(code))
+	return append(p, reason...)
+}
+
+// isValidCloseCode will return whether or not a close code may be sent by a peer
+func isValidCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003:
+		return true
+	case code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+
+	return false
+}
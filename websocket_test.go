@@ -0,0 +1,220 @@
+package webWorkers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+)
+
+const wsAddr = "localhost:11112"
+
+func TestWebSocketEcho(t *testing.T) {
+	c, br := wsDial(t, "")
+	defer c.Close()
+
+	wsWriteFrame(t, c, opText|finBit, []byte("hello"))
+	if op, p := wsReadFrame(t, br); op != opText|finBit || string(p) != "hello" {
+		t.Fatalf("invalid echo, received opcode %x and payload \"%s\"", op, p)
+	}
+
+	// Fragmented message with an interleaved ping
+	wsWriteFrame(t, c, opBinary, []byte("hel"))
+	wsWriteFrame(t, c, opPing|finBit, []byte("ping"))
+	wsWriteFrame(t, c, opContinuation|finBit, []byte("lo"))
+
+	if op, p := wsReadFrame(t, br); op != opPong|finBit || string(p) != "ping" {
+		t.Fatalf("invalid pong, received opcode %x and payload \"%s\"", op, p)
+	}
+
+	if op, p := wsReadFrame(t, br); op != opBinary|finBit || string(p) != "hello" {
+		t.Fatalf("invalid echo, received opcode %x and payload \"%s\"", op, p)
+	}
+
+	wsWriteFrame(t, c, opClose|finBit, closePayload(CloseGoingAway, "bye"))
+	if op, p := wsReadFrame(t, br); op != opClose|finBit || binary.BigEndian.Uint16(p) != CloseGoingAway {
+		t.Fatalf("invalid close, received opcode %x and payload %v", op, p)
+	}
+}
+
+func TestWebSocketDeflate(t *testing.T) {
+	c, br := wsDial(t, "Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n")
+	defer c.Close()
+
+	var buf bytes.Buffer
+	fw, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	fw.Write([]byte("hello hello hello"))
+	fw.Flush()
+
+	wsWriteFrame(t, c, opText|finBit|rsv1Bit, buf.Bytes()[:buf.Len()-4])
+	op, p := wsReadFrame(t, br)
+	if op != opText|finBit|rsv1Bit {
+		t.Fatalf("expected compressed text frame, received opcode %x", op)
+	}
+
+	ws := newWebSocket(nil, "", true, WebSocketOpts{})
+	if p, _ = ws.inflate(p); string(p) != "hello hello hello" {
+		t.Fatalf("invalid echo, received \"%s\"", p)
+	}
+}
+
+func TestWebSocketMessageTooBig(t *testing.T) {
+	c, br := wsDial(t, "")
+	defer c.Close()
+
+	// Masked binary frame header declaring a payload of 2^62 bytes, which must be rejected before it is allocated
+	c.Write([]byte{opBinary | finBit, maskBit | 127, 0x3f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	if op, p := wsReadFrame(t, br); op != opClose|finBit || binary.BigEndian.Uint16(p) != CloseMessageTooBig {
+		t.Fatalf("expected close with %d, received opcode %x and payload %v", CloseMessageTooBig, op, p)
+	}
+}
+
+func TestWebSocketInflateLimit(t *testing.T) {
+	var buf bytes.Buffer
+	fw, _ := flate.NewWriter(&buf, flate.BestCompression)
+	fw.Write(make([]byte, 1<<16))
+	fw.Flush()
+
+	ws := newWebSocket(nil, "", true, WebSocketOpts{MaxMessageSize: 1 << 10})
+	if _, err := ws.inflate(buf.Bytes()[:buf.Len()-4]); err != ErrMessageTooLarge {
+		t.Fatalf("expected ErrMessageTooLarge and received %v", err)
+	}
+}
+
+func TestWebSocketHandlerPanic(t *testing.T) {
+	c, peer := net.Pipe()
+	defer peer.Close()
+
+	// Our close frame is written synchronously, read it from the peer
+	closed := make(chan []byte, 1)
+	go func() {
+		_, p := wsReadFrame(t, bufio.NewReader(peer))
+		closed <- p
+	}()
+
+	var buf bytes.Buffer
+	ws := newWebSocket(c, "", false, WebSocketOpts{})
+	ws.serve(func(*WebSocket) { panic("boom") }, log.New(&buf, "", 0))
+
+	if p := <-closed; binary.BigEndian.Uint16(p) != CloseInternalError {
+		t.Fatalf("expected close with %d, received %v", CloseInternalError, p)
+	}
+
+	if out := buf.String(); !strings.Contains(out, "boom") || !strings.Contains(out, "websocket_test.go") {
+		t.Fatalf("expected our panic and its stack to be logged, received \"%s\"", out)
+	}
+}
+
+func TestWebSocketInvalidHandshake(t *testing.T) {
+	c, err := net.Dial("tcp", wsAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 8\r\n\r\n"))
+	status, _ := bufio.NewReader(c).ReadString('\n')
+	if !strings.HasPrefix(status, "HTTP/1.1 400 Bad Request\r\n") {
+		t.Fatalf("expected 400, received \"%s\"", status)
+	}
+}
+
+func initWebSocket() {
+	var (
+		ww  *Webworkers
+		err error
+	)
+
+	if ww, err = New(Opts{WorkerCap: 1, QueueLen: 16, Address: ":11112"}, wsHandler); err != nil {
+		panic(err)
+	}
+
+	ww.Listen()
+}
+
+func wsHandler(res *Response, req *Request) {
+	err := res.UpgradeWebSocket(WebSocketOpts{Compression: true, MaxFrameSize: 1024}, func(ws *WebSocket) {
+		for {
+			mt, msg, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			if err = ws.WriteMessage(mt, msg); err != nil {
+				return
+			}
+		}
+	})
+
+	if err != nil {
+		res.StatusCode(StatusBadRequest)
+		res.Write(nil)
+	}
+}
+
+func wsDial(t *testing.T, extra string) (c net.Conn, br *bufio.Reader) {
+	var err error
+	if c, err = net.Dial("tcp", wsAddr); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Write([]byte("GET /chat HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n" + extra + "\r\n"))
+
+	br = bufio.NewReader(c)
+	var resp []string
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if line == "\r\n" {
+			break
+		}
+
+		resp = append(resp, line)
+	}
+
+	if !strings.HasPrefix(resp[0], "HTTP/1.1 101 Switching Protocols\r\n") {
+		t.Fatalf("expected 101, received \"%s\"", resp[0])
+	}
+
+	if !strings.Contains(strings.Join(resp, ""), "Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n") {
+		t.Fatalf("invalid accept key: %v", resp)
+	}
+
+	return
+}
+
+func wsWriteFrame(t *testing.T, c net.Conn, b0 byte, payload []byte) {
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{b0, maskBit | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	if _, err := c.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func wsReadFrame(t *testing.T, br *bufio.Reader) (b0 byte, payload []byte) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	payload = make([]byte, hdr[1])
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	return hdr[0], payload
+}
@@ -2,24 +2,23 @@ package webWorkers
 
 import (
 	"bytes"
+	"crypto/tls"
 	"io"
 	"log"
+	"net"
 	"sync"
 	"time"
 )
 
 const (
-	dateFmt = time.RFC1123
+	dateFmt    = time.RFC1123
+	serverName = "PandaNet/0.0.1"
 )
 
 var (
-	httpType = []byte("HTTP/1.1")
-	server   = []byte("Server: PandaNet/0.0.1\n")
-)
-
-const (
-	stateKey state = iota
-	stateVal
+	httpType   = []byte("HTTP/1.1")
+	httpType10 = []byte("HTTP/1.0")
+	server     = []byte("Server: " + serverName + "\r\n")
 )
 
 const (
@@ -29,17 +28,27 @@ const (
 	ContentTypeJS = "application/js"
 )
 
-type state uint8
-
 // newWorker returns a new worker
-func newWorker(in queue, wg *sync.WaitGroup, l *log.Logger, fn Handler) (w *worker) {
+func newWorker(in queue, wg *sync.WaitGroup, l *log.Logger, o *Opts, fn Handler) (w *worker) {
 	w = &worker{
 		in: in,
 		wg: wg,
 		l:  l,
+		o:  o,
 		fn: fn,
+
+		hsts: hstsHeader(o),
+		hbuf: make([]byte, 0, 1024*8),
+		brdr: bytes.NewBuffer(nil),
 	}
 
+	w.req.Cookies = newCookies()
+	// Trusted proxies have already been validated
+	w.req.trusted, _ = parseCIDRs(o.TrustedProxies)
+	w.req.trustUnix = o.TrustUnixPeers
+	w.res.Cookies = newCookies()
+	w.res.l = l
+
 	wg.Add(1)
 	go w.listen()
 	return
@@ -50,55 +59,251 @@ type worker struct {
 	in queue
 	wg *sync.WaitGroup
 	l  *log.Logger
+	o  *Opts
 
 	fn Handler
+
+	req Request
+	res Response
+	cr  continueReader
+	lb  limitedBody
+	tls tls.ConnectionState
+
+	// Strict-Transport-Security header value, empty if HSTS is disabled
+	hsts string
+
+	// Request header buffer, grows up to MaxHeaderBytes
+	hbuf []byte
+	brdr *bytes.Buffer
 }
 
 // listen will listen to an inbound queue to process net.Conn's
 func (w *worker) listen() {
+	for c := range w.in {
+		if s, ok := c.(*h2Stream); ok {
+			// This is a stream of an HTTP/2 connection
+			w.serveStream(s)
+		} else {
+			w.serve(c)
+		}
+
+		w.req.clean()
+		w.res.clean()
+		w.brdr.Reset()
+		w.lb.reset(nil, 0)
+	}
+
+	w.wg.Done()
+}
+
+// serve will process a single HTTP/1.x request
+func (w *worker) serve(c net.Conn) {
 	var (
-		req Request
-		res Response
+		req = &w.req
+		res = &w.res
 
-		buf [1024 * 8]byte
-		n   int
+		bs  []byte
 		hn  int // Header length
 		err error
-
-		brdr = bytes.NewBuffer(nil)
 	)
 
-	req.Cookies = newCookies()
-	res.Cookies = newCookies()
+	if w.serveH2(c) {
+		// Connection negotiated HTTP/2 and has been detached
+		return
+	}
 
-	for c := range w.in {
-		if n, err = c.Read(buf[:]); err != nil && err != io.EOF {
-			log.Println("Error?!", err)
-			continue
-		}
+	if bs, err = w.readHeader(c); err != nil && err != ErrHeaderTooLarge && err != ErrURITooLong && err != ErrHeaderTimeout {
+		w.l.Println(err)
+		goto END
+	}
 
-		if hn, err = req.processHeader(buf[:n]); err != nil {
-			w.l.Println(err)
-			goto ITEREND
-		}
+	req.remoteAddr = c.RemoteAddr()
+	req.localAddr = c.LocalAddr()
 
-		brdr.Write(buf[hn:n])
+	if tc, ok := c.(*tls.Conn); ok {
+		// Our handshake has completed by the first read
+		w.tls = tc.ConnectionState()
+		req.tls = &w.tls
+	}
 
-		if req.contentLength > n-hn {
-			req.Body = io.MultiReader(brdr, c)
-		} else {
-			req.Body = brdr
+	if !isRedirect(c) && w.serveH2C(c, bs) {
+		// Connection opened with the HTTP/2 preface and has been detached
+		return
+	}
+
+	res.conn = c
+	res.req = req
+	res.rbuf = w.brdr
+
+	if err != nil {
+		// Request exceeds our header limits (or was too slow to arrive)
+		w.respondError(res, err)
+		goto END
+	}
+
+	if len(bs) == 0 {
+		// Connection was closed without sending a request
+		goto END
+	}
+
+	if hn, err = req.processHeader(bs); err != nil {
+		w.respondError(res, err)
+		goto END
+	}
+
+	if err = w.checkLimits(req); err != nil {
+		w.respondError(res, err)
+		goto END
+	}
+
+	if !w.allowsMethod(req.method) {
+		// Method is not within our allowed methods, respond with 501
+		w.respondError(res, ErrUnknownMethod)
+		goto END
+	}
+
+	w.brdr.Write(bs[hn:])
+
+	if req.contentLength > len(bs)-hn {
+		req.Body = io.MultiReader(w.brdr, c)
+	} else {
+		req.Body = w.brdr
+	}
+
+	if w.o.MaxBodyBytes > 0 {
+		w.lb.reset(req.Body, w.o.MaxBodyBytes)
+		req.Body = &w.lb
+	}
+
+	if !isRedirect(c) && w.upgradeH2C(c) {
+		// Connection has been upgraded to HTTP/2 and detached
+		return
+	}
+
+	if !req.normalizeHost() {
+		// Host is missing, duplicated, or invalid
+		w.respondError(res, ErrInvalidHost)
+		goto END
+	}
+
+	if isRedirect(c) && w.shouldRedirect(req) {
+		w.redirect(res, req)
+		goto END
+	}
+
+	if req.tls != nil && w.hsts != "" {
+		res.Header("Strict-Transport-Security", w.hsts)
+	}
+
+	if len(req.expect) > 0 && !bytes.Equal(req.httpType, httpType10) {
+		if !isValidExpect(req.expect) {
+			// We cannot meet the client's expectation, respond with 417
+			w.respondError(res, ErrUnsupportedExpectation)
+			goto END
 		}
 
-		res.conn = c
-		w.fn(&res, &req)
+		// Wrap our body so "100 Continue" is sent once the handler begins reading
+		w.cr.reset(res, req.Body)
+		req.Body = &w.cr
+	}
 
-	ITEREND:
+	w.fn(res, req)
+
+	if w.lb.exceeded && !res.headersSent && !res.detached {
+		// Handler read beyond our body limit without responding
+		w.respondError(res, ErrBodyTooLarge)
+	}
+
+END:
+	if !res.detached {
+		// Our connection has not been taken over by the handler, close it
 		c.Close()
-		req.clean()
-		res.clean()
-		brdr.Reset()
 	}
+}
 
-	w.wg.Done()
+// enqueue will push a net.Conn to the inbound queue without blocking the caller
+// Note: If the queue has been closed, the net.Conn is closed instead
+func (w *worker) enqueue(c net.Conn) {
+	go func() {
+		defer func() {
+			if recover() != nil {
+				c.Close()
+			}
+		}()
+
+		w.in <- c
+	}()
+}
+
+// allowsMethod will return whether or not a method is served by our handler
+func (w *worker) allowsMethod(method []byte) bool {
+	if len(w.o.AllowedMethods) == 0 {
+		// Every method is served
+		return true
+	}
+
+	for _, m := range w.o.AllowedMethods {
+		if string(method) == m {
+			return true
+		}
+	}
+
+	return false
+}
+
+// respondError will respond to a request which could not be served, rendered by our ErrorHandler if one is set
+func (w *worker) respondError(res *Response, err error) {
+	sc := errorStatus(err)
+	if w.o.ErrorHandler == nil {
+		w.respond(res, sc)
+		return
+	}
+
+	ct, body := w.o.ErrorHandler(sc, err)
+	if err = res.StatusCode(sc); err != nil {
+		w.l.Println(err)
+		return
+	}
+
+	if ct != "" {
+		res.ContentType(ct)
+	}
+
+	if err = res.Write(body); err != nil {
+		w.l.Println(err)
+	}
+}
+
+// errorStatus will return the status code of a request error
+func errorStatus(err error) int {
+	switch err {
+	case ErrUnknownMethod:
+		return StatusNotImplemented
+	case ErrUnsupportedHTTPVersion:
+		return StatusHTTPVersionUnsupported
+	case ErrHeaderTimeout:
+		return StatusRequestTimeout
+	case ErrURITooLong:
+		return StatusRequestURITooLong
+	case ErrHeaderTooLarge, ErrTooManyHeaders:
+		return StatusRequestHeaderFieldsTooLarge
+	case ErrBodyTooLarge:
+		return StatusRequestEntityTooLarge
+	case ErrUnsupportedExpectation:
+		return StatusExpectationFailed
+	}
+
+	return StatusBadRequest
+}
+
+// respond will write a response consisting only of the provided status code
+func (w *worker) respond(res *Response, sc int) {
+	if err := res.StatusCode(sc); err != nil {
+		w.l.Println(err)
+		return
+	}
+
+	if err := res.Write(nil); err != nil {
+		w.l.Println(err)
+	}
 }
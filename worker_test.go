@@ -0,0 +1,106 @@
+package webWorkers
+
+import (
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrorResponses(t *testing.T) {
+	ww, err := New(Opts{
+		WorkerCap: 2,
+		QueueLen:  16,
+		Address:   ":11129",
+		// PROPFIND ensures methods beyond RFC 7231 may be served
+		AllowedMethods: []string{"GET", "PROPFIND"},
+		ErrorHandler: func(code int, err error) (string, []byte) {
+			return ContentTypeHTML, []byte("<h1>" + err.Error() + "</h1>")
+		},
+	}, func(res *Response, req *Request) {
+		res.Write([]byte("hello"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ww.Close()
+
+	go ww.Listen()
+	time.Sleep(time.Millisecond * 100)
+
+	tests := []struct {
+		name   string
+		req    string
+		status string
+		body   string
+	}{
+		{"ok", "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", "HTTP/1.1 200 OK\r\n", "hello"},
+		{"malformed request line", "GET /\r\nHost: localhost\r\n\r\n", "HTTP/1.1 400 Bad Request\r\n", "<h1>invalid header status</h1>"},
+		{"malformed version", "GET / HTTP/one\r\nHost: localhost\r\n\r\n", "HTTP/1.1 400 Bad Request\r\n", "<h1>invalid header status</h1>"},
+		{"unsupported version", "GET / HTTP/2.0\r\nHost: localhost\r\n\r\n", "HTTP/1.1 505 HTTP Version Not Supported\r\n", "<h1>unsupported HTTP version</h1>"},
+		{"extension method", "PROPFIND / HTTP/1.1\r\nHost: localhost\r\n\r\n", "HTTP/1.1 200 OK\r\n", "hello"},
+		{"unknown method", "BREW / HTTP/1.1\r\nHost: localhost\r\n\r\n", "HTTP/1.1 501 Not Implemented\r\n", "<h1>unknown method</h1>"},
+		{"header without colon", "GET / HTTP/1.1\r\nHost: localhost\r\nX-Test\r\n\r\n", "HTTP/1.1 400 Bad Request\r\n", "<h1>invalid header status</h1>"},
+		{"whitespace before colon", "GET / HTTP/1.1\r\nHost: localhost\r\nContent-Length : 5\r\n\r\n", "HTTP/1.1 400 Bad Request\r\n", "<h1>invalid header status</h1>"},
+		{"obsolete line folding", "GET / HTTP/1.1\r\nHost: localhost\r\nX-Test: a\r\n b\r\n\r\n", "HTTP/1.1 400 Bad Request\r\n", "<h1>invalid header status</h1>"},
+		{"invalid content length", "GET / HTTP/1.1\r\nHost: localhost\r\nContent-Length: abc\r\n\r\n", "HTTP/1.1 400 Bad Request\r\n", "<h1>invalid header status</h1>"},
+		{"negative content length", "GET / HTTP/1.1\r\nHost: localhost\r\nContent-Length: -1\r\n\r\n", "HTTP/1.1 400 Bad Request\r\n", "<h1>invalid header status</h1>"},
+		{"conflicting content length", "GET / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\nContent-Length: 5\r\n\r\n", "HTTP/1.1 400 Bad Request\r\n", "<h1>invalid header status</h1>"},
+		{"duplicate content length", "GET / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\nContent-Length: 0\r\n\r\n", "HTTP/1.1 200 OK\r\n", "hello"},
+		{"missing host", "GET / HTTP/1.1\r\n\r\n", "HTTP/1.1 400 Bad Request\r\n", "<h1>missing, duplicated or invalid host</h1>"},
+	}
+
+	for _, tt := range tests {
+		c, err := net.Dial("tcp4", "localhost:11129")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Write([]byte(tt.req))
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		b, _ := ioutil.ReadAll(c)
+		c.Close()
+
+		out := string(b)
+		if !strings.HasPrefix(out, tt.status) || !strings.HasSuffix(out, "\r\n\r\n"+tt.body) {
+			t.Fatalf("%s: expected %q with body %q and received %q", tt.name, tt.status, tt.body, out)
+		}
+
+		if tt.body != "hello" && !strings.Contains(out, "Content-Type: "+ContentTypeHTML+"\r\n") {
+			t.Fatalf("%s: expected an HTML error page and received %q", tt.name, out)
+		}
+	}
+}
+
+func TestAllowsMethod(t *testing.T) {
+	var w worker
+	w.o = &Opts{}
+	if !w.allowsMethod([]byte("BREW")) {
+		t.Fatal("expected every method to be allowed without allowed methods")
+	}
+
+	w.o.AllowedMethods = []string{"GET"}
+	if !w.allowsMethod([]byte("GET")) || w.allowsMethod([]byte("get")) || w.allowsMethod([]byte("BREW")) {
+		t.Fatal("expected only GET to be allowed")
+	}
+}
+
+func TestResponseBytes(t *testing.T) {
+	var res Response
+	res.Cookies = newCookies()
+	res.Header("X-Test", "a")
+
+	out := string(res.bytes())
+	if !strings.HasPrefix(out, "HTTP/1.1 200 OK\r\nServer: "+serverName+"\r\n") {
+		t.Fatalf("expected a well-formed status line and received %q", out)
+	}
+
+	if strings.Contains(out, "Content-Type") {
+		t.Fatalf("expected no Content-Type without a content type and received %q", out)
+	}
+
+	if !strings.HasSuffix(out, "X-Test: a\r\n\r\n") {
+		t.Fatalf("expected our headers to be terminated by an empty line and received %q", out)
+	}
+}